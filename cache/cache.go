@@ -0,0 +1,205 @@
+// Package cache remembers where customers' bastions and stacks live, and
+// which regions exist, so lookups don't have to scan every region.
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/opsee/boop/util"
+)
+
+const DefaultTTL = 24 * time.Hour
+
+// Entry is the cached location of a bastion or stack.
+type Entry struct {
+	Region     string    `json:"region"`
+	InstanceId string    `json:"instance_id,omitempty"`
+	StackName  string    `json:"stack_name,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// customer is the content of a customer's cache file.
+type customer struct {
+	// by bastion id
+	Bastions map[string]*Entry `json:"bastions"`
+	// by stack name
+	Stacks map[string]*Entry `json:"stacks"`
+}
+
+// Cache stores entries in one json file per customer under Dir. A disabled
+// cache misses on every lookup and stores nothing. Errors reading or
+// writing the cache are treated as misses, it's only an optimization.
+type Cache struct {
+	Dir      string
+	TTL      time.Duration
+	Disabled bool
+
+	mu  sync.Mutex
+	now func() time.Time
+}
+
+func New(dir string, ttl time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Cache{Dir: dir, TTL: ttl, now: time.Now}
+}
+
+// DefaultDir is ~/.boop/cache
+func DefaultDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".boop", "cache")
+}
+
+// Bastion returns the unexpired entry for a customer's bastion.
+func (c *Cache) Bastion(customerID, bastionID string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.fresh(c.load(customerID).Bastions[bastionID])
+}
+
+// Stack returns the unexpired entry for a customer's stack.
+func (c *Cache) Stack(customerID, stackName string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.fresh(c.load(customerID).Stacks[stackName])
+}
+
+func (c *Cache) PutBastion(customerID, bastionID string, e Entry) error {
+	return c.update(customerID, func(cust *customer) {
+		e.Updated = c.now()
+		cust.Bastions[bastionID] = &e
+	})
+}
+
+func (c *Cache) PutStack(customerID, stackName string, e Entry) error {
+	return c.update(customerID, func(cust *customer) {
+		e.StackName = stackName
+		e.Updated = c.now()
+		cust.Stacks[stackName] = &e
+	})
+}
+
+// DeleteBastion removes a bastion entry, e.g. when it turned out to be wrong.
+func (c *Cache) DeleteBastion(customerID, bastionID string) error {
+	return c.update(customerID, func(cust *customer) {
+		delete(cust.Bastions, bastionID)
+	})
+}
+
+// DeleteStack removes a stack entry.
+func (c *Cache) DeleteStack(customerID, stackName string) error {
+	return c.update(customerID, func(cust *customer) {
+		delete(cust.Stacks, stackName)
+	})
+}
+
+// regions is the content of the regions file.
+type regions struct {
+	Regions []string  `json:"regions"`
+	Updated time.Time `json:"updated"`
+}
+
+// Regions returns the unexpired list of regions saved with PutRegions.
+func (c *Cache) Regions() ([]string, bool) {
+	if c.Disabled {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := &regions{}
+	b, err := ioutil.ReadFile(filepath.Join(c.Dir, "regions.json"))
+	if err != nil || json.Unmarshal(b, r) != nil {
+		return nil, false
+	}
+	if len(r.Regions) == 0 || c.now().Sub(r.Updated) > c.TTL {
+		return nil, false
+	}
+
+	return r.Regions, true
+}
+
+// PutRegions saves the list of regions, e.g. from DescribeRegions.
+func (c *Cache) PutRegions(list []string) error {
+	if c.Disabled {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := json.MarshalIndent(&regions{Regions: list, Updated: c.now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.write("regions.json", b)
+}
+
+func (c *Cache) fresh(e *Entry) (*Entry, bool) {
+	if e == nil || c.now().Sub(e.Updated) > c.TTL {
+		return nil, false
+	}
+	return e, true
+}
+
+func (c *Cache) path(customerID string) string {
+	return filepath.Join(c.Dir, filepath.Base(customerID)+".json")
+}
+
+func (c *Cache) load(customerID string) *customer {
+	cust := &customer{
+		Bastions: make(map[string]*Entry),
+		Stacks:   make(map[string]*Entry),
+	}
+	if c.Disabled {
+		return cust
+	}
+
+	b, err := ioutil.ReadFile(c.path(customerID))
+	if err != nil {
+		return cust
+	}
+	if err := json.Unmarshal(b, cust); err != nil {
+		return &customer{Bastions: make(map[string]*Entry), Stacks: make(map[string]*Entry)}
+	}
+	if cust.Bastions == nil {
+		cust.Bastions = make(map[string]*Entry)
+	}
+	if cust.Stacks == nil {
+		cust.Stacks = make(map[string]*Entry)
+	}
+
+	return cust
+}
+
+func (c *Cache) update(customerID string, f func(*customer)) error {
+	if c.Disabled {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cust := c.load(customerID)
+	f(cust)
+
+	b, err := json.MarshalIndent(cust, "", "  ")
+	if err != nil {
+		return err
+	}
+	return c.write(filepath.Base(c.path(customerID)), b)
+}
+
+// write replaces a file in the cache dir.
+func (c *Cache) write(name string, b []byte) error {
+	return util.WriteFileAtomic(filepath.Join(c.Dir, name), b)
+}
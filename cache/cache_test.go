@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+const (
+	customerID = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+	bastionID  = "d07cac86-df4a-11e5-a446-4b21b841f273"
+)
+
+func TestBastionEntries(t *testing.T) {
+	c := New(t.TempDir(), time.Hour)
+
+	if _, ok := c.Bastion(customerID, bastionID); ok {
+		t.Fatal("expected miss on empty cache")
+	}
+
+	if err := c.PutBastion(customerID, bastionID, Entry{Region: "us-west-2", InstanceId: "i-77a708b4"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new cache reads the entry back from disk
+	c = New(c.Dir, time.Hour)
+	e, ok := c.Bastion(customerID, bastionID)
+	if !ok || e.Region != "us-west-2" || e.InstanceId != "i-77a708b4" {
+		t.Fatalf("unexpected entry: %v %v", e, ok)
+	}
+
+	if err := c.DeleteBastion(customerID, bastionID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Bastion(customerID, bastionID); ok {
+		t.Fatal("expected miss after delete")
+	}
+}
+
+func TestStackEntries(t *testing.T) {
+	c := New(t.TempDir(), time.Hour)
+
+	if err := c.PutStack(customerID, "opsee-stack-"+customerID, Entry{Region: "us-east-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	e, ok := c.Stack(customerID, "opsee-stack-"+customerID)
+	if !ok || e.Region != "us-east-1" || e.StackName != "opsee-stack-"+customerID {
+		t.Fatalf("unexpected entry: %v %v", e, ok)
+	}
+	if _, ok := c.Bastion(customerID, "opsee-stack-"+customerID); ok {
+		t.Fatal("stack and bastion entries should be separate")
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	c := New(t.TempDir(), time.Hour)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	c.PutBastion(customerID, bastionID, Entry{Region: "us-west-2"})
+
+	now = now.Add(59 * time.Minute)
+	if _, ok := c.Bastion(customerID, bastionID); !ok {
+		t.Fatal("expected hit before ttl")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Bastion(customerID, bastionID); ok {
+		t.Fatal("expected miss after ttl")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	c := New(t.TempDir(), time.Hour)
+	c.PutBastion(customerID, bastionID, Entry{Region: "us-west-2"})
+
+	c.Disabled = true
+	if _, ok := c.Bastion(customerID, bastionID); ok {
+		t.Fatal("expected miss when disabled")
+	}
+	c.PutBastion(customerID, bastionID, Entry{Region: "eu-west-1"})
+
+	c.Disabled = false
+	if e, _ := c.Bastion(customerID, bastionID); e == nil || e.Region != "us-west-2" {
+		t.Fatalf("disabled cache shouldn't write, got %v", e)
+	}
+}
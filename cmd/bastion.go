@@ -4,19 +4,18 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/fatih/color"
 	log "github.com/mborsuk/jwalterweatherman"
 	"github.com/opsee/basic/schema"
 	"github.com/opsee/basic/service"
+	"github.com/opsee/boop/cache"
 	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
 	"github.com/opsee/boop/svc"
 	"github.com/opsee/boop/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
-	"text/tabwriter"
+	"io"
 	"time"
 )
 
@@ -36,16 +35,16 @@ var bastionListCmd = &cobra.Command{
 	Use:   "list [customer email|UUID]",
 	Short: "list customer's bastions and their status",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
 
 		if viper.GetBool("verbose") {
 			log.SetStdoutThreshold(log.LevelInfo)
 		}
 
-		var (
-			bastionStates []*schema.BastionState
-			err           error
-		)
+		var bastionStates []*schema.BastionState
 
 		if viper.GetBool("list-active") {
 			bastionStates, err = opseeServices.GetBastionStates([]string{}, &service.Filter{
@@ -70,34 +69,60 @@ var bastionListCmd = &cobra.Command{
 			log.INFO.Printf("user info: %s, %s, %s\n", u.Email, u.CustomerId, u.Name)
 		}
 
-		yellow := color.New(color.FgYellow).SprintFunc()
-		blue := color.New(color.FgBlue).SprintFunc()
-		header := color.New(color.FgWhite).SprintFunc()
-
-		w := new(tabwriter.Writer)
-		w.Init(os.Stdout, 1, 0, 2, ' ', 0)
-
-		if len(bastionStates) > 0 && !viper.GetBool("quiet") {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", header("customer_id"), header("id"), header("status"),
-				header("last seen"), header("region"))
+		res := &output.Result{
+			Data: bastionStates,
+			Columns: []output.Column{
+				{Name: "customer_id"},
+				{Name: "id", Color: output.Yellow},
+				{Name: "status"},
+				{Name: "last seen", Color: output.Blue},
+				{Name: "region"},
+			},
+			NoHeader: viper.GetBool("quiet"),
 		}
 
 		for _, b := range bastionStates {
 			lastSeenDur := time.Since(time.Unix(b.LastSeen.Seconds, 0))
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", b.CustomerId, yellow(b.Id), b.Status,
-				blue(roundDuration(lastSeenDur, time.Second)), b.Region)
+			res.Rows = append(res.Rows, []string{b.CustomerId, b.Id, b.Status,
+				roundDuration(lastSeenDur, time.Second).String(), b.Region})
 		}
-		w.Flush()
 
-		return nil
+		return render(res)
 	},
 }
 
 var bastionRestartCmd = &cobra.Command{
 	Use:   "restart [customer email|customer UUID] [bastion UUID]",
 	Short: "restart a customer bastion",
+	Long: `Restarts a customer's bastion. With --customers-file or --all-active it
+restarts all active bastions of each selected customer instead.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		if isFleet("restart") {
+			return runFleet("restart", opseeServices, func(u *schema.User, out io.Writer) error {
+				states, err := opseeServices.GetBastionStates([]string{u.CustomerId}, &service.Filter{
+					Key:   "status",
+					Value: "active",
+				})
+				if err != nil {
+					return err
+				}
+				for _, b := range states {
+					if err := restartBastion(u, b.Id, opseeServices, out); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
 
 		bastionID, err := util.GetUUIDFromArgs(args, 1)
 		if err != nil {
@@ -109,40 +134,39 @@ var bastionRestartCmd = &cobra.Command{
 			return err
 		}
 
-		if viper.GetBool("verbose") {
-			log.SetStdoutThreshold(log.LevelInfo)
-		}
+		return restartBastion(u, *bastionID, opseeServices, stdout)
+	},
+}
 
-		bastionInstance, err := findBastionInstance(u, *bastionID, opseeServices)
+func restartBastion(u *schema.User, bastionID string, opseeServices svc.Services, out io.Writer) error {
+	bastionInstance, err := findBastionInstance(u, bastionID, opseeServices)
+	if err != nil {
+		return err
+	}
+
+	if bastionInstance.Instance != nil {
+		log.INFO.Printf("found bastion instance: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
+		ec2client := awsClients.EC2(bastionInstance.Creds, bastionInstance.Region)
+		// REBOOT THIS MOTHER
+		_, err := ec2client.RebootInstances(&ec2.RebootInstancesInput{
+			InstanceIds: []*string{bastionInstance.Instance.InstanceId},
+		})
 		if err != nil {
 			return err
 		}
-
-		if bastionInstance != nil {
-			log.INFO.Printf("found bastion instance: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
-			ec2client := ec2.New(session.New(&aws.Config{
-				Credentials: bastionInstance.Creds,
-				MaxRetries:  aws.Int(3),
-				Region:      &bastionInstance.Region,
-			}))
-			// REBOOT THIS MOTHER
-			_, err := ec2client.RebootInstances(&ec2.RebootInstancesInput{
-				InstanceIds: []*string{bastionInstance.Instance.InstanceId},
-			})
-			if err != nil {
-				return err
-			}
-			fmt.Printf("instance restart requested for: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
-		}
-		return nil
-	},
+		fmt.Fprintf(out, "instance restart requested for: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
+	}
+	return nil
 }
 
 var bastionTermCmd = &cobra.Command{
 	Use:   "terminate [customer email|customer UUID] [bastion UUID]",
 	Short: "terminate a customer bastion",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
 
 		bastionID, err := util.GetUUIDFromArgs(args, 1)
 		if err != nil {
@@ -166,11 +190,7 @@ var bastionTermCmd = &cobra.Command{
 		if bastionInstance.Instance != nil {
 			log.INFO.Printf("found bastion instance: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
 			var err error
-			ec2client := ec2.New(session.New(&aws.Config{
-				Credentials: bastionInstance.Creds,
-				MaxRetries:  aws.Int(3),
-				Region:      &bastionInstance.Region,
-			}))
+			ec2client := awsClients.EC2(bastionInstance.Creds, bastionInstance.Region)
 			if !viper.GetBool("term-dry-run") {
 				// TERM THIS MOTHER
 				_, err = ec2client.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: []*string{bastionInstance.Instance.InstanceId}})
@@ -178,90 +198,150 @@ var bastionTermCmd = &cobra.Command{
 			if err != nil {
 				return err
 			}
-			fmt.Printf("instance termination requested for: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
+			fmt.Fprintf(stdout, "instance termination requested for: %s in %s\n", *bastionInstance.Instance.InstanceId, bastionInstance.Region)
 			if viper.GetBool("term-dry-run") {
-				fmt.Println("(but not really bc dry-run)")
+				fmt.Fprintln(stdout, "(but not really bc dry-run)")
 			}
 		}
 		return nil
 	},
 }
 
-func findBastionInstance(user *schema.User, bastionID string, opseeServices *svc.OpseeServices) (*bastionInstance, error) {
+func findBastionInstance(user *schema.User, bastionID string, opseeServices svc.Services) (*bastionInstance, error) {
 	bastionStates, err := opseeServices.GetBastionStates([]string{user.CustomerId})
 	if err != nil {
 		return nil, err
 	}
 
-	var foundBast bool
+	var state *schema.BastionState
 	for _, b := range bastionStates {
 		if b.Id == bastionID {
-			foundBast = true
+			state = b
 			break
 		}
 	}
-	if !foundBast {
-		return nil, errors.NewSystemErrorF("cannot find bastion: %s", bastionID)
+	if state == nil {
+		return nil, errors.NewNotFoundErrorF("cannot find bastion: %s", bastionID)
 	}
 
 	userCreds, err := opseeServices.GetRoleCreds(user)
 	if err != nil {
-		return nil, errors.NewSystemErrorF("cannot obtain AWS creds for user: %s", user.Id)
+		return nil, errors.Wrapf(err, "cannot obtain AWS creds for user %d", user.Id)
 	}
 	staticCreds := credentials.NewStaticCredentials(
 		*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
 
-	var instance *ec2.Instance
-	var bastionRegion string
-	var ec2client *ec2.EC2
+	regions, err := scanRegions(staticCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	found := &bastionInstance{Creds: staticCreds}
+	locations := newLocationCache()
 
-	if viper.GetBool("verbose") {
-		log.SetStdoutThreshold(log.LevelInfo)
+	// try the region keelhaul last heard from the bastion in and the one
+	// we found it in last time before scanning all regions
+	hints := []struct{ region, vpcID string }{{state.Region, state.VpcId}}
+	cached, isCached := locations.Bastion(user.CustomerId, bastionID)
+	if isCached {
+		hints = append(hints, struct{ region, vpcID string }{cached.Region, ""})
 	}
 
-	// TODO store bastion's region somewhere to avoid scanning
-RegionLoop:
-	for _, region := range regionList {
-		log.INFO.Printf("checking %s\n", region)
-		ec2client = ec2.New(session.New(&aws.Config{
-			Credentials: staticCreds,
-			MaxRetries:  aws.Int(3),
-			Region:      &region,
-		}))
-
-		descResponse, err := ec2client.DescribeInstances(&ec2.DescribeInstancesInput{
-			Filters: []*ec2.Filter{
-				{
-					Name:   aws.String("tag-key"),
-					Values: []*string{aws.String("opsee:id")},
-				},
-			},
-		})
+	for _, hint := range hints {
+		if !stringInSlice(hint.region, regions) {
+			continue
+		}
+
+		log.INFO.Printf("checking %s\n", hint.region)
+		instance, err := findBastionInRegion(staticCreds, hint.region, hint.vpcID, bastionID)
 		if err != nil {
-			return nil, err
+			log.WARN.Printf("error looking for bastion in %s: %s\n", hint.region, err)
+			continue
 		}
-		for _, r := range descResponse.Reservations {
-			for _, i := range r.Instances {
-				for _, tag := range i.Tags {
-					if *tag.Key == "opsee:id" {
-						if *tag.Value == bastionID {
-							instance = i
-							bastionRegion = region
-							if *i.State.Name == "running" {
-								break RegionLoop
-							}
-						}
-					}
+		if instance != nil && *instance.State.Name == "running" {
+			found.Instance = instance
+			found.Region = hint.region
+			break
+		}
+	}
+
+	if found.Instance == nil {
+		if isCached {
+			locations.DeleteBastion(user.CustomerId, bastionID)
+		}
+
+		results := newFanOut().Run(regions, func(region string) (interface{}, error) {
+			log.INFO.Printf("checking %s\n", region)
+			return findBastionInRegion(staticCreds, region, "", bastionID)
+		})
+
+		for _, r := range results {
+			if r.Err != nil {
+				continue
+			}
+			if instance := r.Value.(*ec2.Instance); instance != nil {
+				found.Instance = instance
+				found.Region = r.Region
+				if *instance.State.Name == "running" {
+					break
 				}
 			}
 		}
+
+		// a running instance could be hiding in a region that failed
+		if err := results.Err(); err != nil {
+			if found.Instance == nil || *found.Instance.State.Name != "running" {
+				return nil, err
+			}
+			log.WARN.Println(err)
+		}
+	}
+
+	if found.Instance != nil {
+		locations.PutBastion(user.CustomerId, bastionID, cache.Entry{
+			Region:     found.Region,
+			InstanceId: *found.Instance.InstanceId,
+			StackName:  "opsee-stack-" + user.CustomerId,
+		})
+	}
+
+	return found, nil
+}
+
+// findBastionInRegion returns the bastion's instance in region, preferring a
+// running one, or nil if there is none.
+func findBastionInRegion(creds *credentials.Credentials, region, vpcID, bastionID string) (*ec2.Instance, error) {
+	filters := []*ec2.Filter{
+		{
+			Name:   aws.String("tag:opsee:id"),
+			Values: []*string{aws.String(bastionID)},
+		},
+	}
+	if vpcID != "" {
+		filters = append(filters, &ec2.Filter{
+			Name:   aws.String("vpc-id"),
+			Values: []*string{aws.String(vpcID)},
+		})
+	}
+
+	descResponse, err := awsClients.EC2(creds, region).DescribeInstances(&ec2.DescribeInstancesInput{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var instance *ec2.Instance
+	for _, r := range descResponse.Reservations {
+		for _, i := range r.Instances {
+			instance = i
+			if *i.State.Name == "running" {
+				return i, nil
+			}
+		}
 	}
 
-	return &bastionInstance{
-		Instance: instance,
-		Creds:    staticCreds,
-		Region:   bastionRegion,
-	}, nil
+	return instance, nil
 }
 
 func init() {
@@ -277,6 +357,7 @@ func init() {
 	viper.BindPFlag("quiet", flags.Lookup("quiet"))
 
 	bastionCmd.AddCommand(bastionRestartCmd)
+	fleetFlags(bastionRestartCmd, "restart")
 
 	bastionCmd.AddCommand(bastionTermCmd)
 	flags = bastionTermCmd.Flags()
@@ -1,34 +1,19 @@
 package cmd
 
 import (
-	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
-	"github.com/fatih/color"
+	"github.com/opsee/boop/output"
 	"sort"
 	//log "github.com/mborsuk/jwalterweatherman"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"os"
-	"text/tabwriter"
 )
 
-var regions = map[string]bool{
-	"ap-northeast-1": true,
-	"ap-southeast-1": true,
-	"ap-southeast-2": true,
-	"eu-central-1":   true,
-	"eu-west-1":      true,
-	"sa-east-1":      true,
-	"us-east-1":      true,
-	"us-west-1":      true,
-	"us-west-2":      true,
-}
-
 type ImageList []*ec2.Image
 
 func (l ImageList) Len() int           { return len(l) }
@@ -60,16 +45,15 @@ var bastionAMIList = &cobra.Command{
 			return err
 		}
 
-		yellow := color.New(color.FgYellow).SprintFunc()
-		blue := color.New(color.FgBlue).SprintFunc()
-		header := color.New(color.FgWhite).SprintFunc()
-
-		w := new(tabwriter.Writer)
-		w.Init(os.Stdout, 1, 0, 2, ' ', 0)
-
-		if len(amiList) > 0 {
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", header("id"), "name",
-				header("sha"), "create date", header("release"))
+		res := &output.Result{
+			Data: amiList,
+			Columns: []output.Column{
+				{Name: "id", Color: output.Yellow},
+				{Name: "name"},
+				{Name: "sha", Color: output.Blue},
+				{Name: "create date"},
+				{Name: "release", Color: output.Blue},
+			},
 		}
 
 		for _, ami := range amiList {
@@ -86,11 +70,10 @@ var bastionAMIList = &cobra.Command{
 				}
 
 			}
-			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", yellow(*ami.ImageId), *ami.Name, blue(tag), *ami.CreationDate, blue(rel))
+			res.Rows = append(res.Rows, []string{*ami.ImageId, aws.StringValue(ami.Name), tag, aws.StringValue(ami.CreationDate), rel})
 		}
-		w.Flush()
 
-		return nil
+		return render(res)
 	},
 }
 
@@ -105,11 +88,7 @@ func getAMIList(region, releaseTag string) ([]*ec2.Image, error) {
 		},
 	)
 
-	ec2client := ec2.New(session.New(&aws.Config{
-		Credentials: creds,
-		MaxRetries:  aws.Int(3),
-		Region:      aws.String(region),
-	}))
+	ec2client := awsClients.EC2(creds, region)
 
 	filters := []*ec2.Filter{
 		{
@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const defaultHealthStaleAfter = 15 * time.Minute
+
+var bastionHealthCmd = &cobra.Command{
+	Use:   "health",
+	Short: "report stale and unhealthy bastions across all customers",
+	Long: `Pulls every bastion from keelhaul, grouped by status, and flags
+  - bastions not seen for --stale
+  - customers with more than one active bastion
+  - active bastions whose EC2 instance isn't running (skip with --no-ec2)`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		states, err := opseeServices.GetBastionStates([]string{})
+		if err != nil {
+			return err
+		}
+
+		stale := viper.GetDuration("health-stale")
+		if stale <= 0 {
+			stale = defaultHealthStaleAfter
+		}
+
+		report := newHealthReport(states, stale, time.Now())
+		if !viper.GetBool("health-no-ec2") {
+			checkBastionInstances(report, opseeServices)
+		}
+
+		res := report.result(viper.GetBool("health-problems"))
+		if err := render(res); err != nil {
+			return err
+		}
+
+		// for scheduled checks
+		if report.Problems > 0 {
+			return errors.NewSystemErrorF("%d of %d bastions have problems", report.Problems, len(states))
+		}
+		return nil
+	},
+}
+
+// bastionHealth is a bastion and what's wrong with it.
+type bastionHealth struct {
+	Id            string    `json:"id"`
+	CustomerId    string    `json:"customer_id"`
+	Status        string    `json:"status"`
+	Region        string    `json:"region"`
+	VpcId         string    `json:"vpc_id"`
+	LastSeen      time.Time `json:"last_seen"`
+	InstanceId    string    `json:"instance_id,omitempty"`
+	InstanceState string    `json:"instance_state,omitempty"`
+	Problems      []string  `json:"problems"`
+}
+
+// healthReport is the result of bastion health, its json form is meant for
+// scheduled checks.
+type healthReport struct {
+	Checked    time.Time      `json:"checked"`
+	StaleAfter string         `json:"stale_after"`
+	ByStatus   map[string]int `json:"by_status"`
+	// customer id to ids of their active bastions, for customers with more
+	// than one
+	MultipleActive map[string][]string `json:"multiple_active"`
+	Problems       int                 `json:"problems"`
+	Bastions       []*bastionHealth    `json:"bastions"`
+}
+
+func newHealthReport(states []*schema.BastionState, stale time.Duration, now time.Time) *healthReport {
+	report := &healthReport{
+		Checked:        now,
+		StaleAfter:     stale.String(),
+		ByStatus:       make(map[string]int),
+		MultipleActive: make(map[string][]string),
+		Bastions:       []*bastionHealth{},
+	}
+
+	active := make(map[string][]string)
+	for _, b := range states {
+		h := &bastionHealth{
+			Id:         b.Id,
+			CustomerId: b.CustomerId,
+			Status:     b.Status,
+			Region:     b.Region,
+			VpcId:      b.VpcId,
+			Problems:   []string{},
+		}
+		if b.LastSeen != nil {
+			h.LastSeen = time.Unix(b.LastSeen.Seconds, 0)
+		}
+
+		// inactive bastions are expected to have gone quiet
+		if b.Status == "active" {
+			if b.LastSeen == nil {
+				h.Problems = append(h.Problems, "never seen")
+			} else if now.Sub(h.LastSeen) > stale {
+				h.Problems = append(h.Problems, fmt.Sprintf("not seen for %s", roundDuration(now.Sub(h.LastSeen), time.Second)))
+			}
+			active[b.CustomerId] = append(active[b.CustomerId], b.Id)
+		}
+
+		report.ByStatus[b.Status]++
+		report.Bastions = append(report.Bastions, h)
+	}
+
+	for customerID, ids := range active {
+		if len(ids) > 1 {
+			report.MultipleActive[customerID] = ids
+		}
+	}
+	for _, h := range report.Bastions {
+		if ids, ok := report.MultipleActive[h.CustomerId]; ok && h.Status == "active" {
+			h.Problems = append(h.Problems, fmt.Sprintf("customer has %d active bastions", len(ids)))
+		}
+	}
+
+	sort.Sort(byStatus(report.Bastions))
+	report.count()
+
+	return report
+}
+
+func (r *healthReport) count() {
+	r.Problems = 0
+	for _, h := range r.Bastions {
+		if len(h.Problems) > 0 {
+			r.Problems++
+		}
+	}
+}
+
+type byStatus []*bastionHealth
+
+func (s byStatus) Len() int      { return len(s) }
+func (s byStatus) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byStatus) Less(i, j int) bool {
+	if s[i].Status != s[j].Status {
+		return s[i].Status < s[j].Status
+	}
+	if s[i].CustomerId != s[j].CustomerId {
+		return s[i].CustomerId < s[j].CustomerId
+	}
+	return s[i].Id < s[j].Id
+}
+
+// checkBastionInstances looks up the EC2 instance of each active bastion in
+// the region and vpc keelhaul reports, a few customers at a time.
+func checkBastionInstances(report *healthReport, opseeServices svc.Services) {
+	byCustomer := make(map[string][]*bastionHealth)
+	customers := []string{}
+	for _, h := range report.Bastions {
+		if h.Status != "active" {
+			continue
+		}
+		if _, ok := byCustomer[h.CustomerId]; !ok {
+			customers = append(customers, h.CustomerId)
+		}
+		byCustomer[h.CustomerId] = append(byCustomer[h.CustomerId], h)
+	}
+
+	workers := viper.GetInt("region-workers")
+	if workers <= 0 {
+		workers = svc.DefaultRegionWorkers
+	}
+	sem := make(chan struct{}, workers)
+	wg := &sync.WaitGroup{}
+
+	for _, customerID := range customers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(customerID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			creds, credsErr := customerCreds(customerID, opseeServices)
+			for _, h := range byCustomer[customerID] {
+				err := credsErr
+				if err == nil {
+					err = checkBastionInstance(h, creds)
+				}
+				if err != nil {
+					log.WARN.Printf("cannot check instance of %s: %s\n", h.Id, err)
+					h.Problems = append(h.Problems, "instance unknown: "+strings.TrimSpace(err.Error()))
+				}
+			}
+		}(customerID)
+	}
+	wg.Wait()
+
+	report.count()
+}
+
+func checkBastionInstance(h *bastionHealth, creds *credentials.Credentials) error {
+	instance, err := findBastionInRegion(creds, h.Region, h.VpcId, h.Id)
+	if err != nil {
+		return err
+	}
+	if instance == nil {
+		h.InstanceState = "missing"
+		h.Problems = append(h.Problems, "no instance")
+		return nil
+	}
+
+	h.InstanceId = aws.StringValue(instance.InstanceId)
+	h.InstanceState = aws.StringValue(instance.State.Name)
+	if h.InstanceState != "running" {
+		h.Problems = append(h.Problems, "instance "+h.InstanceState)
+	}
+	return nil
+}
+
+// customerCreds returns AWS credentials for a customer's role.
+func customerCreds(customerID string, opseeServices svc.Services) (*credentials.Credentials, error) {
+	user, err := opseeServices.GetUser("", customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	userCreds, err := opseeServices.GetRoleCreds(user)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot obtain AWS creds for user %d", user.Id)
+	}
+
+	return credentials.NewStaticCredentials(
+		*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken), nil
+}
+
+// result renders the report, with problemsOnly leaving out healthy bastions
+// but keeping the counts.
+func (r *healthReport) result(problemsOnly bool) *output.Result {
+	data := r
+	if problemsOnly {
+		filtered := *r
+		filtered.Bastions = []*bastionHealth{}
+		for _, h := range r.Bastions {
+			if len(h.Problems) > 0 {
+				filtered.Bastions = append(filtered.Bastions, h)
+			}
+		}
+		data = &filtered
+	}
+
+	res := &output.Result{
+		Data: data,
+		Columns: []output.Column{
+			{Name: "status"},
+			{Name: "customer_id"},
+			{Name: "id", Color: output.Yellow},
+			{Name: "last seen", Color: output.Blue},
+			{Name: "region"},
+			{Name: "instance"},
+			{Name: "problems", Color: output.Red},
+		},
+	}
+
+	for _, h := range data.Bastions {
+		lastSeen := "never"
+		if !h.LastSeen.IsZero() {
+			lastSeen = roundDuration(r.Checked.Sub(h.LastSeen), time.Second).String()
+		}
+		instance := h.InstanceId
+		if h.InstanceState != "" {
+			instance = strings.TrimSpace(instance + " " + h.InstanceState)
+		}
+		res.Rows = append(res.Rows, []string{h.Status, h.CustomerId, h.Id, lastSeen, h.Region, instance, strings.Join(h.Problems, ", ")})
+	}
+
+	res.Text = func(out io.Writer) error {
+		statuses := []string{}
+		for status := range r.ByStatus {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(out, "%s: %d\n", status, r.ByStatus[status])
+		}
+		customers := []string{}
+		for customerID := range r.MultipleActive {
+			customers = append(customers, customerID)
+		}
+		sort.Strings(customers)
+		for _, customerID := range customers {
+			ids := r.MultipleActive[customerID]
+			fmt.Fprintf(out, "%s has %d active bastions: %s\n", customerID, len(ids), strings.Join(ids, ", "))
+		}
+		fmt.Fprintf(out, "%d of %d bastions have problems\n\n", r.Problems, len(r.Bastions))
+
+		t, err := output.New(output.Table, "", "", out)
+		if err != nil {
+			return err
+		}
+		return t.Render(&output.Result{Columns: res.Columns, Rows: res.Rows})
+	}
+
+	return res
+}
+
+func init() {
+	bastionCmd.AddCommand(bastionHealthCmd)
+	flags := bastionHealthCmd.Flags()
+	flags.Duration("stale", defaultHealthStaleAfter, "flag active bastions not seen for this long")
+	viper.BindPFlag("health-stale", flags.Lookup("stale"))
+	flags.Bool("no-ec2", false, "don't check the bastions' EC2 instances")
+	viper.BindPFlag("health-no-ec2", flags.Lookup("no-ec2"))
+	flags.BoolP("problems", "p", false, "only show bastions with problems")
+	viper.BindPFlag("health-problems", flags.Lookup("problems"))
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"github.com/spf13/viper"
+)
+
+const testStaleBastionID = "e1c3a84a-df4b-11e5-a446-4b21b841f273"
+
+func TestBastionHealth(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := bastionHealthCmd.RunE(bastionHealthCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), "active: 1", "inactive: 1", "0 of 2 bastions have problems",
+		testBastionID, testInstanceID+" running")
+}
+
+func TestBastionHealthProblems(t *testing.T) {
+	env := newTestEnv(t)
+	env.services.BastionStates = append(env.services.BastionStates, &schema.BastionState{
+		Id:         testStaleBastionID,
+		CustomerId: testCustomerID,
+		Status:     "active",
+		LastSeen:   &opsee_types.Timestamp{Seconds: time.Now().Add(-time.Hour).Unix()},
+		Region:     testRegion,
+		VpcId:      "vpc-1234",
+	})
+	env.aws.Region(testRegion).Instances[0].State.Name = aws.String(ec2.InstanceStateNameStopped)
+	viper.Set("output", "json")
+	viper.Set("health-problems", true)
+
+	err := bastionHealthCmd.RunE(bastionHealthCmd, []string{})
+	if err == nil || errors.KindOf(err) != errors.KindSystem || err.Error() != "2 of 3 bastions have problems" {
+		t.Fatalf("expected a problems error, got %v", err)
+	}
+
+	report := &healthReport{}
+	if err := json.Unmarshal(env.out.Bytes(), report); err != nil {
+		t.Fatalf("invalid json output: %s\n%s", err, env.out.String())
+	}
+
+	if report.ByStatus["active"] != 2 || report.ByStatus["inactive"] != 1 || report.Problems != 2 {
+		t.Errorf("unexpected counts: %v, %d problems", report.ByStatus, report.Problems)
+	}
+	if ids := report.MultipleActive[testCustomerID]; len(ids) != 2 {
+		t.Errorf("expected 2 active bastions for %s, got %v", testCustomerID, ids)
+	}
+	if len(report.Bastions) != 2 {
+		t.Fatalf("expected only the 2 bastions with problems, got %d", len(report.Bastions))
+	}
+
+	for _, h := range report.Bastions {
+		switch h.Id {
+		case testBastionID:
+			if h.InstanceState != ec2.InstanceStateNameStopped || len(h.Problems) != 2 {
+				t.Errorf("expected a stopped instance, got %+v", h)
+			}
+		case testStaleBastionID:
+			if h.InstanceState != "missing" || len(h.Problems) != 3 {
+				t.Errorf("expected stale, duplicate and missing instance problems, got %+v", h)
+			}
+		default:
+			t.Errorf("unexpected bastion %s", h.Id)
+		}
+	}
+}
+
+func TestBastionHealthNoEC2(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("health-no-ec2", true)
+
+	if err := bastionHealthCmd.RunE(bastionHealthCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, env.out.String(), testInstanceID)
+	if calls := describeInstancesCalls(env); calls != 0 {
+		t.Errorf("expected no DescribeInstances calls, got %d", calls)
+	}
+}
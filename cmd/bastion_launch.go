@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultInstanceSize = "t2.micro"
+	defaultLaunchWait   = 15 * time.Minute
+)
+
+// how often keelhaul is polled for a new bastion, shortened in tests
+var launchPollInterval = 10 * time.Second
+
+var bastionLaunchCmd = &cobra.Command{
+	Use:   "launch [customer email|customer UUID]",
+	Short: "launch a new bastion for a customer",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		u, err := util.GetUserFromArgs(args, 0, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		if !viper.IsSet("launch-region") {
+			return errors.NewUserError("required option not set: region")
+		}
+
+		req, err := launchRequest(u, opseeServices, viper.GetString("launch-region"),
+			viper.GetString("launch-vpc-id"), viper.GetString("launch-subnet-id"), viper.GetString("launch-subnet-routing"))
+		if err != nil {
+			return err
+		}
+		if size := viper.GetString("launch-instance-size"); size != "" {
+			req.InstanceSize = size
+		}
+		req.ExecutionGroupId = viper.GetString("launch-execution-group-id")
+
+		fmt.Fprintf(stdout, "launching bastion for %s in %s/%s/%s (%s)\n", u.CustomerId, req.Region, req.VpcId, req.SubnetId, req.SubnetRouting)
+		if viper.GetBool("launch-dry-run") {
+			fmt.Fprintln(stdout, "(but not really bc dry-run)")
+			return nil
+		}
+
+		before, err := opseeServices.GetBastionStates([]string{u.CustomerId})
+		if err != nil {
+			return err
+		}
+
+		stackID, err := opseeServices.LaunchStack(req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "launched stack %s\n", stackID)
+
+		if !viper.GetBool("launch-wait") {
+			return nil
+		}
+
+		bastion, err := waitForNewBastion(u, opseeServices, before, req.Region, req.VpcId, viper.GetDuration("launch-timeout"))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "bastion %s is active\n", bastion.Id)
+
+		return nil
+	},
+}
+
+// launchRequest returns a request to launch a bastion in region. A given
+// subnet is used as is, the scan only fills in its vpc and routing if they
+// aren't given. Without a subnet, the customer's preferred subnet (in vpcID
+// if given) is picked from a keelhaul VPC scan.
+func launchRequest(user *schema.User, opseeServices svc.Services, region, vpcID, subnetID, routing string) (*service.LaunchStackRequest, error) {
+	if subnetID != "" {
+		req := &service.LaunchStackRequest{
+			User:          user,
+			Region:        region,
+			VpcId:         vpcID,
+			SubnetId:      subnetID,
+			SubnetRouting: routing,
+			InstanceSize:  defaultInstanceSize,
+		}
+		if vpcID == "" || routing == "" {
+			if s := scannedSubnet(user, opseeServices, region, subnetID); s != nil {
+				req.VpcId = firstNonEmpty(vpcID, s.VpcId)
+				req.SubnetRouting = firstNonEmpty(routing, s.Routing)
+			}
+		}
+
+		if req.VpcId == "" {
+			return nil, errors.NewUserErrorF("vpc of subnet %s is unknown, need --vpc-id", subnetID)
+		}
+		if req.SubnetRouting == "" {
+			log.WARN.Printf("routing of subnet %s is unknown, using %s\n", subnetID, schema.RoutingStatePublic)
+			req.SubnetRouting = schema.RoutingStatePublic
+		}
+		return req, nil
+	}
+
+	scan, err := opseeServices.ScanVpcs(user, region)
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := schema.SubnetsByPreference{}
+	for _, s := range scan.Subnets {
+		if vpcID != "" && s.VpcId != vpcID {
+			continue
+		}
+		subnets = append(subnets, s)
+	}
+
+	if len(subnets) == 0 {
+		if vpcID != "" {
+			return nil, errors.NewNotFoundErrorF("no subnets found in %s in %s", vpcID, region)
+		}
+		return nil, errors.NewNotFoundErrorF("no subnets found in %s", region)
+	}
+
+	sort.Sort(subnets)
+	subnet := subnets[0]
+	log.INFO.Printf("using subnet %s in %s (%s, %d instances)\n", subnet.SubnetId, subnet.VpcId, subnet.Routing, subnet.InstanceCount)
+
+	return &service.LaunchStackRequest{
+		User:          user,
+		Region:        region,
+		VpcId:         subnet.VpcId,
+		SubnetId:      subnet.SubnetId,
+		SubnetRouting: subnet.Routing,
+		InstanceSize:  defaultInstanceSize,
+	}, nil
+}
+
+// scannedSubnet returns a subnet from a keelhaul VPC scan, nil if the scan
+// fails or doesn't know it.
+func scannedSubnet(user *schema.User, opseeServices svc.Services, region, subnetID string) *schema.Subnet {
+	scan, err := opseeServices.ScanVpcs(user, region)
+	if err != nil {
+		log.WARN.Printf("cannot scan vpcs in %s: %s\n", region, err)
+		return nil
+	}
+	for _, s := range scan.Subnets {
+		if s.SubnetId == subnetID {
+			return s
+		}
+	}
+	log.WARN.Printf("subnet %s isn't in the vpc scan of %s\n", subnetID, region)
+	return nil
+}
+
+// waitForNewBastion polls keelhaul until an active bastion that isn't in
+// before shows up in region and vpc.
+func waitForNewBastion(user *schema.User, opseeServices svc.Services, before []*schema.BastionState, region, vpcID string, timeout time.Duration) (*schema.BastionState, error) {
+	known := make(map[string]bool)
+	for _, b := range before {
+		known[b.Id] = true
+	}
+
+	if timeout <= 0 {
+		timeout = defaultLaunchWait
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		states, err := opseeServices.GetBastionStates([]string{user.CustomerId}, &service.Filter{
+			Key:   "status",
+			Value: "active",
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range states {
+			if !known[b.Id] && b.Region == region && b.VpcId == vpcID {
+				return b, nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Newf(errors.KindTimeout, "no new active bastion in %s/%s after %s", region, vpcID, timeout)
+		}
+
+		log.INFO.Printf("waiting for new bastion in %s/%s\n", region, vpcID)
+		time.Sleep(launchPollInterval)
+	}
+}
+
+func init() {
+	bastionCmd.AddCommand(bastionLaunchCmd)
+	flags := bastionLaunchCmd.Flags()
+	flags.StringP("region", "r", "", "region to launch in")
+	viper.BindPFlag("launch-region", flags.Lookup("region"))
+	flags.String("vpc-id", "", "vpc to launch in (default: the vpc of the picked subnet)")
+	viper.BindPFlag("launch-vpc-id", flags.Lookup("vpc-id"))
+	flags.String("subnet-id", "", "subnet to launch in (default: the customer's preferred subnet)")
+	viper.BindPFlag("launch-subnet-id", flags.Lookup("subnet-id"))
+	flags.String("subnet-routing", "", "with --subnet-id, the subnet's routing: nat, gateway, public, private or occluded (default: from the vpc scan)")
+	viper.BindPFlag("launch-subnet-routing", flags.Lookup("subnet-routing"))
+	flags.String("instance-size", defaultInstanceSize, "bastion instance type")
+	viper.BindPFlag("launch-instance-size", flags.Lookup("instance-size"))
+	flags.String("execution-group-id", "", "execution group id")
+	viper.BindPFlag("launch-execution-group-id", flags.Lookup("execution-group-id"))
+	flags.BoolP("wait", "w", false, "wait for the new bastion to become active")
+	viper.BindPFlag("launch-wait", flags.Lookup("wait"))
+	flags.Duration("timeout", defaultLaunchWait, "max time to wait for the new bastion")
+	viper.BindPFlag("launch-timeout", flags.Lookup("timeout"))
+	flags.BoolP("dry-run", "n", false, "dry run")
+	viper.BindPFlag("launch-dry-run", flags.Lookup("dry-run"))
+}
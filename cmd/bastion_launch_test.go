@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+func testVpcScan(env *testEnv) {
+	env.services.Regions[testCustomerID] = map[string]*schema.Region{
+		testRegion: {
+			Region:     testRegion,
+			CustomerId: testCustomerID,
+			Subnets: []*schema.Subnet{
+				{SubnetId: "subnet-private", VpcId: "vpc-1234", Routing: schema.RoutingStatePrivate, InstanceCount: 10},
+				{SubnetId: "subnet-public", VpcId: "vpc-1234", Routing: schema.RoutingStatePublic, InstanceCount: 1},
+				{SubnetId: "subnet-nat", VpcId: "vpc-5678", Routing: schema.RoutingStateNAT, InstanceCount: 3},
+			},
+		},
+	}
+}
+
+func TestBastionLaunchPicksSubnet(t *testing.T) {
+	env := newTestEnv(t)
+	testVpcScan(env)
+	viper.Set("launch-region", testRegion)
+
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.services.Launched) != 1 {
+		t.Fatalf("expected 1 launch, got %d", len(env.services.Launched))
+	}
+	req := env.services.Launched[0]
+	if req.SubnetId != "subnet-nat" || req.VpcId != "vpc-5678" || req.SubnetRouting != schema.RoutingStateNAT {
+		t.Errorf("expected the nat subnet to be preferred, got %s/%s (%s)", req.VpcId, req.SubnetId, req.SubnetRouting)
+	}
+	if req.InstanceSize != defaultInstanceSize || req.User.CustomerId != testCustomerID {
+		t.Errorf("unexpected launch request: %v", req)
+	}
+	assertContains(t, env.out.String(), "launched stack "+testStackName)
+}
+
+func TestBastionLaunchInVpc(t *testing.T) {
+	env := newTestEnv(t)
+	testVpcScan(env)
+	viper.Set("launch-region", testRegion)
+	viper.Set("launch-vpc-id", "vpc-1234")
+	viper.Set("launch-wait", true)
+
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := env.services.Launched[0]
+	if req.SubnetId != "subnet-public" {
+		t.Errorf("expected public subnet in vpc-1234, got %s", req.SubnetId)
+	}
+	assertContains(t, env.out.String(), "is active")
+}
+
+func TestBastionLaunchErrors(t *testing.T) {
+	env := newTestEnv(t)
+	testVpcScan(env)
+
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); !errors.IsUserError(err) {
+		t.Errorf("expected user error without region, got %v", err)
+	}
+
+	viper.Set("launch-region", testRegion)
+	viper.Set("launch-subnet-id", "subnet-missing")
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); !errors.IsUserError(err) {
+		t.Errorf("expected user error for a subnet of unknown vpc, got %v", err)
+	}
+	viper.Set("launch-subnet-id", "")
+
+	viper.Set("launch-region", "us-east-1")
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); errors.KindOf(err) != errors.KindNotFound {
+		t.Errorf("expected not found error without subnets, got %v", err)
+	}
+
+	viper.Set("launch-region", testRegion)
+	viper.Set("launch-subnet-id", "subnet-private")
+	viper.Set("launch-dry-run", true)
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.services.Launched) != 0 {
+		t.Errorf("expected no launches, got %d", len(env.services.Launched))
+	}
+}
+
+func TestBastionLaunchInSubnet(t *testing.T) {
+	env := newTestEnv(t)
+	// the scan doesn't know the subnet, it's used anyway
+	testVpcScan(env)
+	viper.Set("launch-region", testRegion)
+	viper.Set("launch-vpc-id", "vpc-9999")
+	viper.Set("launch-subnet-id", "subnet-new")
+
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := env.services.Launched[0]
+	if req.SubnetId != "subnet-new" || req.VpcId != "vpc-9999" || req.SubnetRouting != schema.RoutingStatePublic {
+		t.Errorf("expected the given subnet, got %s/%s (%s)", req.VpcId, req.SubnetId, req.SubnetRouting)
+	}
+
+	// vpc and routing of a known subnet come from the scan
+	viper.Set("launch-vpc-id", "")
+	viper.Set("launch-subnet-id", "subnet-private")
+	if err := bastionLaunchCmd.RunE(bastionLaunchCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	req = env.services.Launched[1]
+	if req.SubnetId != "subnet-private" || req.VpcId != "vpc-1234" || req.SubnetRouting != schema.RoutingStatePrivate {
+		t.Errorf("expected the scanned subnet, got %s/%s (%s)", req.VpcId, req.SubnetId, req.SubnetRouting)
+	}
+}
+
+func TestWaitForNewBastionTimeout(t *testing.T) {
+	env := newTestEnv(t)
+
+	_, err := waitForNewBastion(env.services.Users[0], env.services, env.services.BastionStates, testRegion, "vpc-1234", 5*time.Millisecond)
+	if errors.KindOf(err) != errors.KindTimeout {
+		t.Errorf("expected timeout, got %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var bastionReplaceCmd = &cobra.Command{
+	Use:   "replace [customer email|customer UUID] [bastion UUID]",
+	Short: "launch a replacement for a customer bastion, then terminate the old one",
+	Long: `Launches a new bastion in the old bastion's region, VPC and subnet and waits
+for keelhaul to report it active before terminating the old instance. If a
+step fails, the replacement's stack is deleted and the old bastion is left alone.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		bastionID, err := util.GetUUIDFromArgs(args, 1)
+		if err != nil {
+			return err
+		}
+
+		u, err := util.GetUserFromArgs(args, 0, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		before, err := opseeServices.GetBastionStates([]string{u.CustomerId})
+		if err != nil {
+			return err
+		}
+		var old *schema.BastionState
+		for _, b := range before {
+			if b.Id == *bastionID {
+				old = b
+			}
+		}
+		if old == nil {
+			return errors.NewNotFoundErrorF("cannot find bastion: %s", *bastionID)
+		}
+
+		oldInstance, err := findBastionInstance(u, old.Id, opseeServices)
+		if err != nil {
+			return err
+		}
+		stack, err := findStack(u, "opsee-stack-"+u.CustomerId, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		region := firstNonEmpty(old.Region, oldInstance.Region, stack.Region)
+		if region == "" {
+			return errors.NewNotFoundErrorF("cannot determine region of bastion %s", old.Id)
+		}
+
+		req, err := launchRequest(u, opseeServices, region,
+			firstNonEmpty(old.VpcId, stack.getParam("VpcId")), stack.getParam("SubnetId"), "")
+		if err != nil {
+			return err
+		}
+		if size := firstNonEmpty(viper.GetString("replace-instance-size"), stack.getParam("InstanceType")); size != "" {
+			req.InstanceSize = size
+		}
+
+		fmt.Fprintf(stdout, "replacing bastion %s with a new one in %s/%s/%s (%s)\n", old.Id, req.Region, req.VpcId, req.SubnetId, req.InstanceSize)
+		if oldInstance.Instance == nil {
+			fmt.Fprintf(stdout, "no instance found for bastion %s, it won't be terminated\n", old.Id)
+		}
+		if viper.GetBool("replace-dry-run") {
+			fmt.Fprintln(stdout, "(but not really bc dry-run)")
+			return nil
+		}
+
+		stackID, err := opseeServices.LaunchStack(req)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "launched stack %s\n", stackID)
+
+		// the replacement may never check in with keelhaul, so it's removed
+		// by deleting its stack rather than by its bastion id
+		rb := &rollback{}
+		rb.add("delete replacement stack", func() error {
+			return deleteReplacementStack(stack, req.Region, stackID)
+		})
+
+		bastion, err := waitForNewBastion(u, opseeServices, before, req.Region, req.VpcId, viper.GetDuration("replace-timeout"))
+		if err != nil {
+			return rb.run(err)
+		}
+		fmt.Fprintf(stdout, "replacement bastion %s is active\n", bastion.Id)
+
+		if oldInstance.Instance != nil {
+			ec2client := awsClients.EC2(oldInstance.Creds, oldInstance.Region)
+			_, err := ec2client.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: []*string{oldInstance.Instance.InstanceId}})
+			if err != nil {
+				return rb.run(errors.Wrapf(err, "cannot terminate old instance %s", *oldInstance.Instance.InstanceId))
+			}
+			newLocationCache().DeleteBastion(u.CustomerId, old.Id)
+			fmt.Fprintf(stdout, "instance termination requested for: %s in %s\n", *oldInstance.Instance.InstanceId, oldInstance.Region)
+		}
+
+		return nil
+	},
+}
+
+// deleteReplacementStack deletes stackID, the stack launched for a
+// replacement bastion in region, and waits for it to be gone. It refuses to
+// delete old, the stack of the bastion being replaced.
+func deleteReplacementStack(old *cfnStack, region, stackID string) error {
+	if old.Stack != nil && old.Region == region &&
+		(stackID == aws.StringValue(old.Stack.StackId) || stackID == aws.StringValue(old.Stack.StackName)) {
+		return errors.NewSystemErrorF("launched stack %s is the old bastion's stack, not deleting it", stackID)
+	}
+
+	cfnClient := awsClients.CloudFormation(old.Creds, region)
+	events, err := newStackEvents(cfnClient, stackID)
+	if err != nil {
+		return err
+	}
+
+	_, err = cfnClient.DeleteStack(&cloudformation.DeleteStackInput{
+		StackName: aws.String(stackID),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "requested deletion of stack %s\n", stackID)
+
+	_, err = waitForStack(events, cloudformation.StackStatusDeleteComplete, 0, stdout)
+	return err
+}
+
+// rollback undoes completed steps in reverse order.
+type rollback struct {
+	steps []rollbackStep
+}
+
+type rollbackStep struct {
+	name string
+	undo func() error
+}
+
+func (r *rollback) add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name, undo})
+}
+
+// run rolls back after err and returns err, noting steps that couldn't be
+// undone.
+func (r *rollback) run(err error) error {
+	fmt.Fprintf(stdout, "%s, rolling back\n", strings.TrimSpace(err.Error()))
+
+	failed := []string{}
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		fmt.Fprintf(stdout, "rollback: %s\n", step.name)
+		if uErr := step.undo(); uErr != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", step.name, uErr))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.Wrapf(err, "rollback failed (%s)", strings.Join(failed, "; "))
+	}
+	return errors.Wrapf(err, "rolled back")
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func init() {
+	bastionCmd.AddCommand(bastionReplaceCmd)
+	flags := bastionReplaceCmd.Flags()
+	flags.String("instance-size", "", "instance type of the replacement (default: the old bastion's)")
+	viper.BindPFlag("replace-instance-size", flags.Lookup("instance-size"))
+	flags.Duration("timeout", defaultLaunchWait, "max time to wait for the replacement to become active")
+	viper.BindPFlag("replace-timeout", flags.Lookup("timeout"))
+	flags.BoolP("dry-run", "n", false, "dry run")
+	viper.BindPFlag("replace-dry-run", flags.Lookup("dry-run"))
+}
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	newInstanceID = "i-0e11e9b5"
+	newStackID    = "arn:aws:cloudformation:us-west-2:123456789012:stack/opsee-bastion-replacement/2"
+)
+
+// replaceEnv is a test env where launched bastions get a stack and an
+// instance in testRegion and the old stack has subnet and instance type
+// parameters.
+func replaceEnv(t *testing.T) *testEnv {
+	env := newTestEnv(t)
+	testVpcScan(env)
+
+	stack := env.aws.Region(testRegion).Stacks[0]
+	stack.Parameters = append(stack.Parameters,
+		&cloudformation.Parameter{ParameterKey: aws.String("SubnetId"), ParameterValue: aws.String("subnet-private")},
+		&cloudformation.Parameter{ParameterKey: aws.String("InstanceType"), ParameterValue: aws.String("t2.small")})
+
+	env.services.LaunchStackId = newStackID
+	env.services.OnLaunch = func(b *schema.BastionState) {
+		region := env.aws.Region(b.Region)
+		region.Stacks = append(region.Stacks, &cloudformation.Stack{
+			StackId:     aws.String(newStackID),
+			StackName:   aws.String("opsee-bastion-replacement"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+		})
+		region.Instances = append(region.Instances, &ec2.Instance{
+			InstanceId: aws.String(newInstanceID),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			VpcId:      aws.String(b.VpcId),
+			Tags:       []*ec2.Tag{{Key: aws.String("opsee:id"), Value: aws.String(b.Id)}},
+		})
+	}
+
+	return env
+}
+
+// assertReplacementDeleted checks that only the replacement's stack was
+// deleted.
+func assertReplacementDeleted(t *testing.T, env *testEnv) {
+	region := env.aws.Region(testRegion)
+	for _, s := range region.Stacks {
+		status := aws.StringValue(s.StackStatus)
+		switch aws.StringValue(s.StackId) {
+		case newStackID:
+			if status != cloudformation.StackStatusDeleteComplete {
+				t.Errorf("expected the replacement's stack to be deleted, got %s", status)
+			}
+		default:
+			if status == cloudformation.StackStatusDeleteComplete {
+				t.Errorf("expected stack %s to be left alone", aws.StringValue(s.StackName))
+			}
+		}
+	}
+	if len(region.Terminated) != 0 {
+		t.Errorf("expected no instances to be terminated, got %v", region.Terminated)
+	}
+}
+
+func TestBastionReplace(t *testing.T) {
+	env := replaceEnv(t)
+
+	if err := bastionReplaceCmd.RunE(bastionReplaceCmd, []string{testEmail, testBastionID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.services.Launched) != 1 {
+		t.Fatalf("expected 1 launch, got %d", len(env.services.Launched))
+	}
+	req := env.services.Launched[0]
+	if req.Region != testRegion || req.VpcId != "vpc-1234" || req.SubnetId != "subnet-private" || req.InstanceSize != "t2.small" {
+		t.Errorf("expected replacement in the old bastion's subnet, got %v", req)
+	}
+
+	terminated := env.aws.Region(testRegion).Terminated
+	if len(terminated) != 1 || terminated[0] != testInstanceID {
+		t.Errorf("expected only the old instance to be terminated, got %v", terminated)
+	}
+	assertContains(t, env.out.String(), "is active", "instance termination requested for: "+testInstanceID)
+}
+
+func TestBastionReplaceRollback(t *testing.T) {
+	env := replaceEnv(t)
+	env.services.LaunchStatus = "launching"
+	viper.Set("replace-timeout", 5*time.Millisecond)
+
+	err := bastionReplaceCmd.RunE(bastionReplaceCmd, []string{testEmail, testBastionID})
+	if errors.KindOf(err) != errors.KindTimeout {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+
+	assertReplacementDeleted(t, env)
+	assertContains(t, env.out.String(), "rolling back", "rollback: delete replacement stack", "requested deletion of stack "+newStackID)
+}
+
+func TestBastionReplaceNeverRegisters(t *testing.T) {
+	env := replaceEnv(t)
+	env.services.LaunchUnregistered = true
+	viper.Set("replace-timeout", 5*time.Millisecond)
+
+	err := bastionReplaceCmd.RunE(bastionReplaceCmd, []string{testEmail, testBastionID})
+	if errors.KindOf(err) != errors.KindTimeout {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	assertContains(t, err.Error(), "rolled back")
+
+	assertReplacementDeleted(t, env)
+	assertContains(t, env.out.String(), "rollback: delete replacement stack")
+}
+
+func TestBastionReplaceRollbackKeepsOldStack(t *testing.T) {
+	env := replaceEnv(t)
+	env.services.LaunchStatus = "launching"
+	env.services.LaunchStackId = ""
+	viper.Set("replace-timeout", 5*time.Millisecond)
+
+	err := bastionReplaceCmd.RunE(bastionReplaceCmd, []string{testEmail, testBastionID})
+	if errors.KindOf(err) != errors.KindTimeout {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	assertContains(t, err.Error(), "rollback failed", "is the old bastion's stack")
+
+	for _, s := range env.aws.Region(testRegion).Stacks {
+		if aws.StringValue(s.StackStatus) == cloudformation.StackStatusDeleteComplete {
+			t.Errorf("expected stack %s not to be deleted", aws.StringValue(s.StackName))
+		}
+	}
+}
+
+func TestBastionReplaceDryRun(t *testing.T) {
+	env := replaceEnv(t)
+	viper.Set("replace-dry-run", true)
+
+	if err := bastionReplaceCmd.RunE(bastionReplaceCmd, []string{testEmail, testBastionID}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.services.Launched) != 0 || len(env.aws.Region(testRegion).Terminated) != 0 {
+		t.Error("expected dry run not to launch or terminate anything")
+	}
+}
+
+func TestBastionReplaceUnknownBastion(t *testing.T) {
+	replaceEnv(t)
+
+	err := bastionReplaceCmd.RunE(bastionReplaceCmd, []string{testEmail, "11111111-df4a-11e5-a446-4b21b841f273"})
+	if errors.KindOf(err) != errors.KindNotFound {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/svc"
+	"github.com/spf13/viper"
+)
+
+func TestBastionList(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := bastionListCmd.RunE(bastionListCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), "customer_id", testBastionID, "active", testRegion)
+	assertNotContains(t, env.out.String(), "45cde7e8-d118-11e5-a310-ef438a026494")
+}
+
+func TestBastionListActive(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("list-active", true)
+
+	if err := bastionListCmd.RunE(bastionListCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), testBastionID)
+	assertNotContains(t, env.out.String(), "inactive")
+}
+
+func TestBastionListMissingUser(t *testing.T) {
+	newTestEnv(t)
+
+	if err := bastionListCmd.RunE(bastionListCmd, []string{}); err == nil {
+		t.Fatal("expected error for missing user argument")
+	}
+}
+
+func TestBastionRestart(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := bastionRestartCmd.RunE(bastionRestartCmd, []string{testEmail, testBastionID}); err != nil {
+		t.Fatal(err)
+	}
+
+	rebooted := env.aws.Region(testRegion).Rebooted
+	if len(rebooted) != 1 || rebooted[0] != testInstanceID {
+		t.Fatalf("expected %s to be rebooted, got %v", testInstanceID, rebooted)
+	}
+	assertContains(t, env.out.String(), "instance restart requested for: "+testInstanceID+" in "+testRegion)
+}
+
+func TestBastionRestartUnknownBastion(t *testing.T) {
+	env := newTestEnv(t)
+
+	err := bastionRestartCmd.RunE(bastionRestartCmd, []string{testEmail, "11111111-df4a-11e5-a446-4b21b841f273"})
+	if err == nil {
+		t.Fatal("expected error for unknown bastion")
+	}
+	if len(env.aws.Region(testRegion).Rebooted) != 0 {
+		t.Fatal("expected no reboots")
+	}
+}
+
+func TestBastionRestartNoInstance(t *testing.T) {
+	env := newTestEnv(t)
+	env.aws.Region(testRegion).Instances = nil
+
+	if err := bastionRestartCmd.RunE(bastionRestartCmd, []string{testEmail, testBastionID}); err != nil {
+		t.Fatal(err)
+	}
+	assertNotContains(t, env.out.String(), "restart requested")
+}
+
+func TestBastionTerminate(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := bastionTermCmd.RunE(bastionTermCmd, []string{testEmail, testBastionID}); err != nil {
+		t.Fatal(err)
+	}
+
+	terminated := env.aws.Region(testRegion).Terminated
+	if len(terminated) != 1 || terminated[0] != testInstanceID {
+		t.Fatalf("expected %s to be terminated, got %v", testInstanceID, terminated)
+	}
+	assertContains(t, env.out.String(), "instance termination requested for: "+testInstanceID)
+	assertNotContains(t, env.out.String(), "dry-run")
+}
+
+func TestBastionTerminateDryRun(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("term-dry-run", true)
+
+	if err := bastionTermCmd.RunE(bastionTermCmd, []string{testEmail, testBastionID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.aws.Region(testRegion).Terminated) != 0 {
+		t.Fatal("expected no terminations on dry run")
+	}
+	assertContains(t, env.out.String(), "dry-run")
+}
+
+func TestBastionTerminateInvalidBastionID(t *testing.T) {
+	newTestEnv(t)
+
+	if err := bastionTermCmd.RunE(bastionTermCmd, []string{testEmail, "not-a-uuid"}); err == nil {
+		t.Fatal("expected error for invalid bastion id")
+	}
+}
+
+func TestBastionListJSON(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("output", "json")
+
+	if err := bastionListCmd.RunE(bastionListCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	var states []*schema.BastionState
+	if err := json.Unmarshal(env.out.Bytes(), &states); err != nil {
+		t.Fatalf("invalid json output: %s\n%s", err, env.out.String())
+	}
+	if len(states) != 1 || states[0].Id != testBastionID || states[0].CustomerId != testCustomerID {
+		t.Errorf("unexpected bastion states: %v", states)
+	}
+}
+
+func TestBastionListCSV(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("output", "csv")
+	viper.Set("quiet", true)
+
+	if err := bastionListCmd.RunE(bastionListCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), testCustomerID+","+testBastionID+",active,")
+	assertNotContains(t, env.out.String(), "customer_id", "\x1b[")
+}
+
+func TestBastionListQuery(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("list-active", true)
+	viper.Set("query", "[].[customer_id, id]")
+
+	if err := bastionListCmd.RunE(bastionListCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if out := env.out.String(); out != testCustomerID+"\t"+testBastionID+"\n" {
+		t.Errorf("unexpected query output: %q", out)
+	}
+}
+
+func describeInstancesCalls(env *testEnv) int {
+	calls := 0
+	for _, region := range svc.DefaultRegions {
+		calls += env.aws.Region(region).Calls["DescribeInstances"]
+	}
+	return calls
+}
+
+func TestFindBastionUsesKeelhaulRegion(t *testing.T) {
+	env := newTestEnv(t)
+
+	b, err := findBastionInstance(env.services.Users[0], testBastionID, env.services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Region != testRegion || *b.Instance.InstanceId != testInstanceID {
+		t.Fatalf("unexpected bastion instance: %s %v", b.Region, b.Instance)
+	}
+	if n := describeInstancesCalls(env); n != 1 {
+		t.Errorf("expected a single DescribeInstances call, got %d", n)
+	}
+}
+
+func TestFindBastionCache(t *testing.T) {
+	env := newTestEnv(t)
+	// keelhaul doesn't know the region, the first lookup has to scan
+	env.services.BastionStates[0].Region = ""
+
+	if _, err := findBastionInstance(env.services.Users[0], testBastionID, env.services); err != nil {
+		t.Fatal(err)
+	}
+	if n := describeInstancesCalls(env); n < 2 {
+		t.Fatalf("expected a scan, got %d calls", n)
+	}
+
+	resetCalls(env)
+	b, err := findBastionInstance(env.services.Users[0], testBastionID, env.services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Region != testRegion {
+		t.Fatalf("expected %s, got %s", testRegion, b.Region)
+	}
+	if n := describeInstancesCalls(env); n != 1 {
+		t.Errorf("expected cached lookup with a single call, got %d", n)
+	}
+
+	// --no-cache scans again
+	resetCalls(env)
+	viper.Set("no-cache", true)
+	if _, err := findBastionInstance(env.services.Users[0], testBastionID, env.services); err != nil {
+		t.Fatal(err)
+	}
+	if n := describeInstancesCalls(env); n < 2 {
+		t.Errorf("expected a scan with --no-cache, got %d calls", n)
+	}
+}
+
+func TestFindBastionStaleHint(t *testing.T) {
+	env := newTestEnv(t)
+	// keelhaul's region is wrong, the bastion is found by scanning
+	env.services.BastionStates[0].Region = "us-east-1"
+
+	b, err := findBastionInstance(env.services.Users[0], testBastionID, env.services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Region != testRegion {
+		t.Fatalf("expected %s, got %s", testRegion, b.Region)
+	}
+}
+
+func resetCalls(env *testEnv) {
+	for _, r := range env.aws.Regions {
+		r.Calls = make(map[string]int)
+	}
+}
+
+func TestFindBastionPartialFailure(t *testing.T) {
+	env := newTestEnv(t)
+	env.services.BastionStates[0].Region = ""
+	env.aws.Region("eu-west-1").Err = awserr.New("RequestError", "send request failed", nil)
+
+	b, err := findBastionInstance(env.services.Users[0], testBastionID, env.services)
+	if err != nil {
+		t.Fatalf("a failing region shouldn't abort the scan: %s", err)
+	}
+	if b.Region != testRegion {
+		t.Fatalf("expected %s, got %s", testRegion, b.Region)
+	}
+
+	// without the bastion, the failed region could have had it
+	env.aws.Region(testRegion).Instances = nil
+	viper.Set("no-cache", true)
+	_, err = findBastionInstance(env.services.Users[0], testBastionID, env.services)
+	if err == nil || !strings.Contains(err.Error(), "eu-west-1") {
+		t.Fatalf("expected error naming the failed region, got %v", err)
+	}
+}
+
+func TestFindBastionRegionFilter(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("region-filter", "-"+testRegion)
+
+	b, err := findBastionInstance(env.services.Users[0], testBastionID, env.services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Instance != nil {
+		t.Fatalf("expected no instance outside of %s, got one in %s", testRegion, b.Region)
+	}
+	if n := env.aws.Region(testRegion).Calls["DescribeInstances"]; n != 0 {
+		t.Errorf("expected excluded region not to be queried, got %d calls", n)
+	}
+
+	viper.Set("region-filter", "mars-north-1")
+	if _, err := findBastionInstance(env.services.Users[0], testBastionID, env.services); !errors.IsUserError(err) {
+		t.Errorf("expected user error for unknown region, got %v", err)
+	}
+}
+
+func TestFindBastionRefreshRegions(t *testing.T) {
+	env := newTestEnv(t)
+	env.services.BastionStates[0].Region = ""
+	env.aws.RegionNames = []string{testRegion, "ap-south-1"}
+	viper.Set("refresh-regions", true)
+
+	for i := 0; i < 2; i++ {
+		if _, err := findBastionInstance(env.services.Users[0], testBastionID, env.services); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := env.aws.Region("us-east-1").Calls["DescribeRegions"]; n != 1 {
+		t.Errorf("expected regions to be described once and cached, got %d calls", n)
+	}
+	if n := env.aws.Region("ap-south-1").Calls["DescribeInstances"]; n != 1 {
+		t.Errorf("expected refreshed region to be scanned, got %d calls", n)
+	}
+	if n := env.aws.Region("eu-west-1").Calls["DescribeInstances"]; n != 0 {
+		t.Errorf("expected only refreshed regions to be scanned, got %d calls", n)
+	}
+}
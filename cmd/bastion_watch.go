@@ -0,0 +1,296 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/mattn/go-isatty"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultWatchInterval = 30 * time.Second
+	defaultStaleAfter    = 5 * time.Minute
+	clearScreen          = "\033[H\033[2J"
+	// events kept under the redrawn table
+	watchEventLog = 20
+)
+
+var bastionWatchCmd = &cobra.Command{
+	Use:   "watch [customer email|UUID]",
+	Short: "watch bastions' status, printing changes as they happen",
+	Long: `Polls keelhaul and redraws the bastion table (on a terminal), highlighting
+bastions that haven't been seen for --stale. Status changes, new and removed
+bastions and bastions going stale or fresh are printed as events.
+
+--hook runs a shell command for every event with BOOP_EVENT, BOOP_BASTION_ID,
+BOOP_CUSTOMER_ID, BOOP_REGION, BOOP_OLD_STATUS and BOOP_NEW_STATUS set.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		customerIDs := []string{}
+		if !viper.GetBool("watch-all") {
+			u, err := util.GetUserFromArgs(args, 0, opseeServices)
+			if err != nil {
+				return err
+			}
+			customerIDs = append(customerIDs, u.CustomerId)
+		}
+
+		interval := viper.GetDuration("watch-interval")
+		if interval <= 0 {
+			interval = defaultWatchInterval
+		}
+
+		w := &bastionWatcher{
+			stale: viper.GetDuration("watch-stale"),
+			hook:  viper.GetString("watch-hook"),
+			now:   time.Now,
+		}
+		if w.stale <= 0 {
+			w.stale = defaultStaleAfter
+		}
+		redraw := isTerminal(stdout)
+
+		for i := 0; ; i++ {
+			states, err := opseeServices.GetBastionStates(customerIDs)
+			if err != nil {
+				return err
+			}
+
+			events := w.update(states)
+			if redraw {
+				// clearing the screen wipes earlier events, so the
+				// latest are printed again under the table
+				fmt.Fprint(stdout, clearScreen)
+				if err := w.table(states).Text(stdout); err != nil {
+					return err
+				}
+				for _, e := range w.record(events) {
+					fmt.Fprintln(stdout, e)
+				}
+			} else {
+				if i == 0 {
+					if err := w.table(states).Text(stdout); err != nil {
+						return err
+					}
+				}
+				for _, e := range events {
+					fmt.Fprintln(stdout, e)
+				}
+			}
+			for _, e := range events {
+				w.runHook(e)
+			}
+
+			if n := viper.GetInt("watch-count"); n > 0 && i+1 >= n {
+				return nil
+			}
+			time.Sleep(interval)
+		}
+	},
+}
+
+type bastionSnapshot struct {
+	state *schema.BastionState
+	stale bool
+}
+
+// bastionEvent is a change between two polls.
+type bastionEvent struct {
+	Time       time.Time
+	Event      string
+	BastionId  string
+	CustomerId string
+	Region     string
+	OldStatus  string
+	NewStatus  string
+}
+
+func (e bastionEvent) String() string {
+	detail := e.NewStatus
+	if e.Event == "status" {
+		detail = e.OldStatus + " -> " + e.NewStatus
+	}
+	return fmt.Sprintf("%s  %-6s  %s  %s  %s  %s", e.Time.Format(time.RFC3339), e.Event, e.CustomerId, e.BastionId, e.Region, detail)
+}
+
+type bastionWatcher struct {
+	stale time.Duration
+	hook  string
+	now   func() time.Time
+	// previous poll by bastion id, nil before the first poll
+	last map[string]*bastionSnapshot
+	// previous poll in keelhaul's order, to report gone bastions in order
+	lastStates []*schema.BastionState
+	// the latest events, oldest first
+	log []bastionEvent
+}
+
+func (w *bastionWatcher) isStale(b *schema.BastionState) bool {
+	return b.LastSeen == nil || w.now().Sub(time.Unix(b.LastSeen.Seconds, 0)) > w.stale
+}
+
+// update records a poll and returns what changed since the last one.
+func (w *bastionWatcher) update(states []*schema.BastionState) []bastionEvent {
+	current := make(map[string]*bastionSnapshot)
+	for _, b := range states {
+		current[b.Id] = &bastionSnapshot{state: b, stale: w.isStale(b)}
+	}
+
+	first := w.last == nil
+	previous, previousStates := w.last, w.lastStates
+	w.last, w.lastStates = current, states
+	if first {
+		return nil
+	}
+
+	events := []bastionEvent{}
+	event := func(name string, b *schema.BastionState, oldStatus string) {
+		events = append(events, bastionEvent{
+			Time:       w.now(),
+			Event:      name,
+			BastionId:  b.Id,
+			CustomerId: b.CustomerId,
+			Region:     b.Region,
+			OldStatus:  oldStatus,
+			NewStatus:  b.Status,
+		})
+	}
+
+	for _, b := range states {
+		prev, ok := previous[b.Id]
+		switch {
+		case !ok:
+			event("new", b, "")
+		case prev.state.Status != b.Status:
+			event("status", b, prev.state.Status)
+		case !prev.stale && current[b.Id].stale:
+			event("stale", b, prev.state.Status)
+		case prev.stale && !current[b.Id].stale:
+			event("fresh", b, prev.state.Status)
+		}
+	}
+
+	for _, b := range previousStates {
+		if _, ok := current[b.Id]; !ok {
+			events = append(events, bastionEvent{
+				Time:       w.now(),
+				Event:      "gone",
+				BastionId:  b.Id,
+				CustomerId: b.CustomerId,
+				Region:     b.Region,
+				OldStatus:  b.Status,
+			})
+		}
+	}
+
+	return events
+}
+
+// record adds events to the event log and returns the log, dropping all but
+// the last watchEventLog events.
+func (w *bastionWatcher) record(events []bastionEvent) []bastionEvent {
+	w.log = append(w.log, events...)
+	if n := len(w.log) - watchEventLog; n > 0 {
+		w.log = append([]bastionEvent(nil), w.log[n:]...)
+	}
+	return w.log
+}
+
+func (w *bastionWatcher) table(states []*schema.BastionState) *output.Result {
+	res := &output.Result{
+		Data: states,
+		Columns: []output.Column{
+			{Name: "customer_id"},
+			{Name: "id", Color: output.Yellow},
+			{Name: "status"},
+			{Name: "last seen"},
+			{Name: "region"},
+		},
+	}
+
+	for _, b := range states {
+		lastSeen := "never"
+		if b.LastSeen != nil {
+			lastSeen = roundDuration(w.now().Sub(time.Unix(b.LastSeen.Seconds, 0)), time.Second).String()
+		}
+		if w.isStale(b) {
+			lastSeen = output.Red.SprintFunc()(lastSeen + " (stale)")
+		} else {
+			lastSeen = output.Blue.SprintFunc()(lastSeen)
+		}
+		res.Rows = append(res.Rows, []string{b.CustomerId, b.Id, b.Status, lastSeen, b.Region})
+	}
+
+	res.Text = func(out io.Writer) error {
+		r, err := output.New(output.Table, "", "", out)
+		if err != nil {
+			return err
+		}
+		return r.Render(&output.Result{Columns: res.Columns, Rows: res.Rows})
+	}
+
+	return res
+}
+
+// runHook runs the hook command for an event. Failures are logged, they
+// don't stop the watch.
+func (w *bastionWatcher) runHook(e bastionEvent) {
+	if w.hook == "" {
+		return
+	}
+
+	hook := exec.Command("sh", "-c", w.hook)
+	hook.Env = append(os.Environ(),
+		"BOOP_EVENT="+e.Event,
+		"BOOP_BASTION_ID="+e.BastionId,
+		"BOOP_CUSTOMER_ID="+e.CustomerId,
+		"BOOP_REGION="+e.Region,
+		"BOOP_OLD_STATUS="+e.OldStatus,
+		"BOOP_NEW_STATUS="+e.NewStatus,
+	)
+	hook.Stdout = stdout
+	hook.Stderr = os.Stderr
+
+	if err := hook.Run(); err != nil {
+		log.ERROR.Println(errors.Wrapf(err, "hook failed for %s %s", e.Event, e.BastionId))
+	}
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isatty.IsTerminal(f.Fd())
+}
+
+func init() {
+	bastionCmd.AddCommand(bastionWatchCmd)
+	flags := bastionWatchCmd.Flags()
+	flags.BoolP("all", "a", false, "watch all customers' bastions")
+	viper.BindPFlag("watch-all", flags.Lookup("all"))
+	flags.DurationP("interval", "i", defaultWatchInterval, "poll interval")
+	viper.BindPFlag("watch-interval", flags.Lookup("interval"))
+	flags.Duration("stale", defaultStaleAfter, "highlight bastions not seen for this long")
+	viper.BindPFlag("watch-stale", flags.Lookup("stale"))
+	flags.String("hook", "", "shell command to run for each event")
+	viper.BindPFlag("watch-hook", flags.Lookup("hook"))
+	flags.IntP("count", "c", 0, "stop after this many polls (default: run until interrupted)")
+	viper.BindPFlag("watch-count", flags.Lookup("count"))
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"github.com/spf13/viper"
+)
+
+func TestBastionWatch(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("watch-all", true)
+	viper.Set("watch-count", 2)
+	viper.Set("watch-interval", time.Millisecond)
+
+	if err := bastionWatchCmd.RunE(bastionWatchCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), testBastionID, "45cde7e8-d118-11e5-a310-ef438a026494", "(stale)")
+	assertNotContains(t, env.out.String(), clearScreen, " -> ")
+	// not a terminal, so the table is only printed once
+	if n := strings.Count(env.out.String(), "customer_id"); n != 1 {
+		t.Errorf("expected the table once, got %d", n)
+	}
+}
+
+func TestBastionWatcherEvents(t *testing.T) {
+	now := time.Unix(1456790400, 0)
+	w := &bastionWatcher{stale: 5 * time.Minute, now: func() time.Time { return now }}
+
+	seen := func(ago time.Duration) *opsee_types.Timestamp {
+		return &opsee_types.Timestamp{Seconds: now.Add(-ago).Unix()}
+	}
+	a := &schema.BastionState{Id: "a", CustomerId: testCustomerID, Status: "active", LastSeen: seen(time.Minute), Region: testRegion}
+	b := &schema.BastionState{Id: "b", CustomerId: testCustomerID, Status: "active", LastSeen: seen(time.Minute), Region: testRegion}
+
+	if events := w.update([]*schema.BastionState{a, b}); len(events) != 0 {
+		t.Fatalf("expected no events on the first poll, got %v", events)
+	}
+
+	a2 := *a
+	a2.Status = "inactive"
+	b2 := *b
+	b2.LastSeen = seen(10 * time.Minute)
+	c := &schema.BastionState{Id: "c", CustomerId: testCustomerID, Status: "launching", Region: testRegion}
+
+	events := w.update([]*schema.BastionState{&a2, &b2, c})
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %v", events)
+	}
+	for i, expected := range []struct{ event, id, old, new string }{
+		{"status", "a", "active", "inactive"},
+		{"stale", "b", "active", "active"},
+		{"new", "c", "", "launching"},
+	} {
+		e := events[i]
+		if e.Event != expected.event || e.BastionId != expected.id || e.OldStatus != expected.old || e.NewStatus != expected.new {
+			t.Errorf("expected %+v, got %+v", expected, e)
+		}
+	}
+	assertContains(t, events[0].String(), "active -> inactive")
+
+	b3 := *b
+	events = w.update([]*schema.BastionState{&b3})
+	if len(events) != 3 || events[0].Event != "fresh" || events[1].Event != "gone" || events[2].Event != "gone" {
+		t.Fatalf("expected fresh and 2 gone events, got %v", events)
+	}
+}
+
+func TestBastionWatcherHook(t *testing.T) {
+	newTestEnv(t)
+	out := filepath.Join(t.TempDir(), "hook")
+	w := &bastionWatcher{hook: "echo $BOOP_EVENT $BOOP_BASTION_ID $BOOP_OLD_STATUS $BOOP_NEW_STATUS > " + out}
+
+	w.runHook(bastionEvent{Event: "status", BastionId: testBastionID, OldStatus: "active", NewStatus: "inactive"})
+
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "status "+testBastionID+" active inactive\n" {
+		t.Fatalf("unexpected hook output %q", b)
+	}
+
+	// a failing hook is logged, not fatal
+	w.hook = "exit 1"
+	w.runHook(bastionEvent{Event: "gone", BastionId: testBastionID})
+}
+
+func TestBastionWatcherEventLog(t *testing.T) {
+	w := &bastionWatcher{}
+	for i := 0; i < watchEventLog; i++ {
+		w.record([]bastionEvent{{BastionId: fmt.Sprint(i)}})
+	}
+	log := w.record([]bastionEvent{{BastionId: "new"}, {BastionId: "newer"}})
+
+	if len(log) != watchEventLog {
+		t.Fatalf("expected %d events, got %d", watchEventLog, len(log))
+	}
+	if log[0].BastionId != "2" || log[len(log)-1].BastionId != "newer" {
+		t.Errorf("expected the oldest events to be dropped, got %v ... %v", log[0], log[len(log)-1])
+	}
+}
@@ -2,24 +2,23 @@ package cmd
 
 import (
 	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"io"
+	"net/http"
 	"os"
+	"strings"
 )
 
-// TODO read from config
-var regionList = []string{
-	"us-west-1",
-	"us-west-2",
-	"us-east-1",
-	"eu-west-1",
-	"eu-central-1",
-	"sa-east-1",
-	"ap-southeast-1",
-	"ap-southeast-2",
-	"ap-northeast-1",
-	"ap-northeast-2",
-}
+// external dependencies, replaced with fakes in tests
+var (
+	awsClients           = svc.NewAWS()
+	httpClient           = http.DefaultClient
+	stdout     io.Writer = os.Stdout
+	stdin      io.Reader = os.Stdin
+)
 
 var BoopCmd = &cobra.Command{
 	Use: "boop",
@@ -31,12 +30,30 @@ func Execute() {
 	flags := BoopCmd.PersistentFlags()
 	flags.BoolP("verbose", "v", false, "verbose output")
 	viper.BindPFlag("verbose", flags.Lookup("verbose"))
+	flags.String("profile", svc.DefaultProfile, "service endpoint profile (production, local or one from the config file)")
+	viper.BindPFlag("profile", flags.Lookup("profile"))
+	flags.Bool("no-cache", false, "don't use or update the bastion location cache")
+	viper.BindPFlag("no-cache", flags.Lookup("no-cache"))
+	flags.String("regions", "", "regions to scan, comma separated, prefix with - to exclude (e.g. -sa-east-1)")
+	viper.BindPFlag("region-filter", flags.Lookup("regions"))
+	flags.Bool("refresh-regions", false, "get the list of regions from AWS instead of the config")
+	viper.BindPFlag("refresh-regions", flags.Lookup("refresh-regions"))
+	flags.Int("region-workers", svc.DefaultRegionWorkers, "max number of regions queried at once")
+	viper.BindPFlag("region-workers", flags.Lookup("region-workers"))
+	flags.Duration("region-timeout", svc.DefaultRegionTimeout, "time to wait for each region")
+	viper.BindPFlag("region-timeout", flags.Lookup("region-timeout"))
+	flags.StringP("output", "o", output.Table, "output format ("+strings.Join(output.Formats, "|")+")")
+	viper.BindPFlag("output", flags.Lookup("output"))
+	flags.String("template", "", "go template applied to each result (implies --output template)")
+	viper.BindPFlag("template", flags.Lookup("template"))
+	flags.String("query", "", "JMESPath query applied to the json form of the result")
+	viper.BindPFlag("query", flags.Lookup("query"))
 
 	if c, err := BoopCmd.ExecuteC(); err != nil {
-		if errors.IsUserError(err) {
+		if errors.ShowUsage(err) {
 			c.Println(c.UsageString())
 		}
 
-		os.Exit(-1)
+		os.Exit(errors.ExitCode(err))
 	}
 }
@@ -5,29 +5,26 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
-	"github.com/fatih/color"
 	log "github.com/mborsuk/jwalterweatherman"
 	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/cache"
 	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
 	"github.com/opsee/boop/svc"
 	"github.com/opsee/boop/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"io/ioutil"
-	"net/http"
+	"io"
 	"net/url"
-	"os"
 	"path"
 	"strings"
-	"text/tabwriter"
 )
 
 const (
 	secGrpTemplate = "bastion-ingress-cf.template"
 	cfnTemplate    = "bastion-cf.template"
-	cfnS3BucketURL = "https://s3%s%s.amazonaws.com/opsee-bastion-cf-%s/beta"
+	cfnS3BucketURL = "https://s3%s%s.amazonaws.com/opsee-bastion-cf-%s"
 	badUserdata    = `        - name: "10-cgroupfs.conf"
           content: |
             [Service]
@@ -35,94 +32,82 @@ const (
 `
 )
 
+// stackInfo is the output of cfn print
+type stackInfo struct {
+	Region string
+	*cloudformation.Stack
+}
+
+func (s stackInfo) print(w io.Writer) error {
+	fmt.Fprintf(w, "requesting stack deletion for %s\n", aws.StringValue(s.StackName))
+	fmt.Fprintf(w, "name: %s\n", aws.StringValue(s.StackName))
+	fmt.Fprintf(w, "region: %s\n", s.Region)
+	fmt.Fprintln(w, "stack params: ")
+	for _, p := range s.Parameters {
+		fmt.Fprintf(w, "   %s: %s\n", aws.StringValue(p.ParameterKey), aws.StringValue(p.ParameterValue))
+	}
+	fmt.Fprintln(w, "stack tags: ")
+	for _, t := range s.Tags {
+		fmt.Fprintf(w, "   %s: %s\n", aws.StringValue(t.Key), aws.StringValue(t.Value))
+	}
+	return nil
+}
+
 type cfnStack struct {
 	Creds  *credentials.Credentials
 	Region string
 	Stack  *cloudformation.Stack
 }
 
-func (s cfnStack) getStackParams(amiId string) ([]*cloudformation.Parameter, error) {
-	params := []*cloudformation.Parameter{
-		{
-			ParameterKey:     aws.String("InstanceType"),
-			UsePreviousValue: aws.Bool(true),
-		},
-		{
-			ParameterKey:     aws.String("VpcId"),
-			UsePreviousValue: aws.Bool(true),
-		},
-		{
-			ParameterKey:     aws.String("SubnetId"),
-			UsePreviousValue: aws.Bool(true),
-		},
-		{
-			ParameterKey:     aws.String("AssociatePublicIpAddress"),
-			UsePreviousValue: aws.Bool(true),
-		},
-		{
-			ParameterKey:     aws.String("CustomerId"),
-			UsePreviousValue: aws.Bool(true),
-		},
-		{
-			ParameterKey:     aws.String("BastionId"),
-			UsePreviousValue: aws.Bool(true),
-		},
+// getStackParams returns the parameters of an update to the image amiId, ""
+// to keep the image, set as policy says. Only parameters the template
+// declares are sent: the ones boop manages get their new values, the others
+// keep their previous values, or the template's default if the stack doesn't
+// have them yet.
+func (s cfnStack) getStackParams(amiId string, declared []*cloudformation.TemplateParameter, policy *paramPolicy) ([]*cloudformation.Parameter, error) {
+	keys := []string{}
+	for _, d := range declared {
+		keys = append(keys, aws.StringValue(d.ParameterKey))
+	}
+	if amiId != "" && !stringInSlice("ImageId", keys) {
+		return nil, errors.NewUserError("template has no parameter ImageId, it can't change the image")
+	}
+	if policy.userdata && !stringInSlice("UserData", keys) {
+		return nil, errors.NewUserError("template has no parameter UserData, it can't refresh the userdata")
 	}
 
-	if amiId != "" {
-		params = append(params, &cloudformation.Parameter{
-			ParameterKey:   aws.String("ImageId"),
-			ParameterValue: aws.String(amiId),
-		})
-	} else {
-		params = append(params, &cloudformation.Parameter{
-			ParameterKey:     aws.String("ImageId"),
-			UsePreviousValue: aws.Bool(true),
-		})
-	}
-
-	if viper.IsSet("cfnup-allow-ssh") {
-		params = append(params, &cloudformation.Parameter{
-			ParameterKey:   aws.String("AllowSSH"),
-			ParameterValue: aws.String("True"),
-		})
-	} else {
-		params = append(params, &cloudformation.Parameter{
-			ParameterKey:   aws.String("AllowSSH"),
-			ParameterValue: aws.String("False"),
-		})
-	}
-
-	if viper.GetBool("userdata") {
-		userdata, err := s.getUserdata()
-		if err != nil {
-			return nil, err
+	params := []*cloudformation.Parameter{}
+	for _, key := range keys {
+		p := &cloudformation.Parameter{ParameterKey: aws.String(key)}
+		switch {
+		case key == "ImageId" && amiId != "":
+			p.ParameterValue = aws.String(amiId)
+		case key == "AllowSSH" && policy.allowSSH != "":
+			p.ParameterValue = aws.String(policy.allowSSH)
+		case key == "UserData" && policy.userdata:
+			userdata, err := s.getUserdata()
+			if err != nil {
+				return nil, err
+			}
+			p.ParameterValue = aws.String(base64.StdEncoding.EncodeToString([]byte(userdata)))
+		case s.hasParam(key):
+			p.UsePreviousValue = aws.Bool(true)
+		default:
+			continue
 		}
-
-		params = append(params, &cloudformation.Parameter{
-			ParameterKey:   aws.String("UserData"),
-			ParameterValue: aws.String(base64.StdEncoding.EncodeToString([]byte(userdata))),
-		})
-	} else {
-		params = append(params, &cloudformation.Parameter{
-			ParameterKey:     aws.String("UserData"),
-			UsePreviousValue: aws.Bool(true),
-		})
+		params = append(params, p)
 	}
 
-	return params, nil
+	return s.overrideParams(params, keys, policy.overrides)
 }
 
-func (s cfnStack) getCFNTemplate() ([]byte, error) {
-	resp, err := http.Get(s.getS3URL(cfnTemplate))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+func (s cfnStack) getCFNTemplate(src templateSource) ([]byte, error) {
+	return getTemplate(s.Region, cfnTemplate, src)
 }
 
-func (s cfnStack) getS3URL(template string) string {
+// getS3URL returns the url of a template in a channel of the region's
+// template bucket.
+func (s cfnStack) getS3URL(channel, template string) string {
 	sep := "-"
 	reg := s.Region
 
@@ -138,12 +123,41 @@ func (s cfnStack) getS3URL(template string) string {
 		return ""
 	}
 
-	u.Path = path.Join(u.Path, template)
+	u.Path = path.Join(u.Path, channel, template)
 
 	return u.String()
 }
 
+// hasParam returns whether the stack has a parameter, which only then has a
+// previous value to use.
+func (s cfnStack) hasParam(key string) bool {
+	return s.Stack != nil && stackParam(s.Stack.Parameters, key) != nil
+}
+
+// getParam returns the value of a stack parameter, "" if it isn't set.
+func (s cfnStack) getParam(key string) string {
+	if s.Stack != nil {
+		for _, p := range s.Stack.Parameters {
+			if aws.StringValue(p.ParameterKey) == key {
+				return aws.StringValue(p.ParameterValue)
+			}
+		}
+	}
+	return ""
+}
+
 func (s cfnStack) getUserdata() (string, error) {
+	data, err := s.getRawUserdata()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Replace(data, badUserdata, "", 1), nil
+}
+
+// getRawUserdata returns the stack's decoded userdata as it is, without
+// getUserdata's fixes.
+func (s cfnStack) getRawUserdata() (string, error) {
 	if s.Stack != nil {
 		for _, p := range s.Stack.Parameters {
 			if aws.StringValue(p.ParameterKey) == "UserData" {
@@ -152,12 +166,12 @@ func (s cfnStack) getUserdata() (string, error) {
 					return "", err
 				}
 
-				return strings.Replace(string(data), badUserdata, "", 1), nil
+				return string(data), nil
 			}
 		}
 	}
 
-	return "", fmt.Errorf("no stack found")
+	return "", errors.NewNotFoundErrorF("no stack found")
 
 }
 
@@ -166,13 +180,11 @@ var cfnCommand = &cobra.Command{
 	Short: "bastion cloud formation commands",
 }
 
-var cfnUserdata = &cobra.Command{
-	Use:   "userdata [customer email|customer UUID]",
-	Short: "show a cloudformation stack's userdata",
+var cfnUpdate = &cobra.Command{
+	Use:   "update [customer email|customer UUID]",
+	Short: "update CFN template for a customer bastion stack",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
-
-		u, err := util.GetUserFromArgs(args, 0, opseeServices)
+		opseeServices, err := newOpseeServices()
 		if err != nil {
 			return err
 		}
@@ -181,124 +193,157 @@ var cfnUserdata = &cobra.Command{
 			log.SetStdoutThreshold(log.LevelInfo)
 		}
 
-		stackName := "opsee-stack-" + u.CustomerId
-		stack, err := findStack(u, stackName, opseeServices)
+		policy, err := updateParamPolicy(cmd.Flags())
 		if err != nil {
 			return err
 		}
 
-		ud, err := stack.getUserdata()
+		if viper.GetBool("cfnup-plan") {
+			if isFleet("cfnup") {
+				return errors.NewUserError("--plan asks for confirmation, it can't be used for many customers")
+			}
+
+			u, err := util.GetUserFromArgs(args, 0, opseeServices)
+			if err != nil {
+				return err
+			}
+			return planStackUpdate(u, opseeServices, updateTemplate, updateImage, policy, viper.GetBool("cfnup-wait"), stdout)
+		}
+
+		if isFleet("cfnup") {
+			return runFleet("cfnup", opseeServices, func(u *schema.User, out io.Writer) error {
+				return updateStack(u, opseeServices, updateTemplate, updateImage, policy, viper.GetBool("cfnup-wait"), out)
+			})
+		}
+
+		u, err := util.GetUserFromArgs(args, 0, opseeServices)
 		if err != nil {
 			return err
 		}
 
-		fmt.Println(ud)
-		return nil
+		return updateStack(u, opseeServices, updateTemplate, updateImage, policy, viper.GetBool("cfnup-wait"), stdout)
 	},
 }
 
-var cfnUpdate = &cobra.Command{
-	Use:   "update [customer email|customer UUID]",
-	Short: "update CFN template for a customer bastion stack",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+// updateTemplate returns the bastion template for region from the source
+// selected with --template-file or --channel.
+func updateTemplate(region string) ([]byte, error) {
+	src := updateTemplateSource()
+	log.INFO.Printf("using template from %s", src)
+	return cfnStack{Region: region}.getCFNTemplate(src)
+}
 
-		u, err := util.GetUserFromArgs(args, 0, opseeServices)
-		if err != nil {
-			return err
-		}
+// defaultImageChannel is the release channel of --latest images.
+const defaultImageChannel = "stable"
 
-		if viper.GetBool("verbose") {
-			log.SetStdoutThreshold(log.LevelInfo)
+// updateImage returns the image selected with --latest or --ami-id.
+func updateImage(region string) (string, error) {
+	if viper.GetBool("latest") {
+		channel := viper.GetString("cfnup-image-channel")
+		if channel == "" {
+			channel = defaultImageChannel
 		}
+		return latestImage(region, channel)
+	}
+	return viper.GetString("cfnup-ami-id"), nil
+}
 
-		stackName := "opsee-stack-" + u.CustomerId
-		return doStacks(u, stackName, opseeServices, func(stack *cfnStack) error {
-			log.INFO.Printf("found stack: %s in %s\n", *stack.Stack.StackId, stack.Region)
+// latestImage returns the newest bastion image of a channel in region.
+func latestImage(region, channel string) (string, error) {
+	log.INFO.Printf("requesting latest image in region: %s", region)
+	imageList, err := getAMIList(region, channel)
+	if err != nil {
+		return "", err
+	}
 
-			templateBytes, err := stack.getCFNTemplate()
-			if err != nil {
-				return err
-			}
+	if len(imageList) == 0 {
+		return "", errors.NewNotFoundErrorF("no images found in %s", region)
+	}
 
-			var amiId string
-			if viper.GetBool("latest") {
-				log.INFO.Printf("requesting latest image in region: %s", stack.Region)
-				imageList, err := getAMIList(stack.Region, "stable")
-				if err != nil {
-					return err
-				}
+	return aws.StringValue(imageList[0].ImageId), nil
+}
 
-				if len(imageList) == 0 {
-					return fmt.Errorf("no images found")
-				}
+// updateStack updates a customer's bastion stack to the template and the
+// image returned by template and image for the stack's region, "" to keep
+// the image, with parameters set as policy says. With wait, it prints the
+// stack's events until the update finishes.
+func updateStack(u *schema.User, opseeServices svc.Services, template func(region string) ([]byte, error), image func(region string) (string, error), policy *paramPolicy, wait bool, out io.Writer) error {
+	stackName := "opsee-stack-" + u.CustomerId
+	return doStacks(u, stackName, opseeServices, func(stack *cfnStack) error {
+		log.INFO.Printf("found stack: %s in %s\n", *stack.Stack.StackId, stack.Region)
 
-				amiId = aws.StringValue(imageList[0].ImageId)
-			} else {
-				amiId = viper.GetString("cfnup-ami-id")
-			}
+		in, err := stack.updateInput(stackName, template, image, policy)
+		if err != nil {
+			return err
+		}
 
-			log.INFO.Printf("updating with image id: %s", amiId)
+		cfnClient := awsClients.CloudFormation(stack.Creds, stack.Region)
 
-			params, err := stack.getStackParams(amiId)
+		var events *stackEvents
+		if wait {
+			events, err = newStackEvents(cfnClient, stackName)
 			if err != nil {
 				return err
 			}
+		}
 
-			cfnClient := cloudformation.New(session.New(),
-				aws.NewConfig().WithCredentials(stack.Creds).WithRegion(stack.Region).WithMaxRetries(10))
+		_, err = cfnClient.UpdateStack(in)
+		if err != nil {
+			return err
+		}
 
-			_, err = cfnClient.UpdateStack(&cloudformation.UpdateStackInput{
-				StackName:    aws.String(stackName),
-				TemplateBody: aws.String(string(templateBytes)),
-				Capabilities: []*string{
-					aws.String("CAPABILITY_IAM"),
-				},
-				Parameters: params,
-			})
-			if err != nil {
-				return err
-			}
+		fmt.Fprintf(out, "requested stack update\n")
+		if wait {
+			return waitForStackUpdate(events, viper.GetDuration("cfnup-timeout"), out)
+		}
 
-			fmt.Printf("requested stack update\n")
-			if viper.GetBool("cfnup-wait") {
-				// TODO replace with better waiter
-				err = cfnClient.WaitUntilStackUpdateComplete(&cloudformation.DescribeStacksInput{
-					StackName: aws.String(stackName),
-				})
-				if err != nil {
-					return err
-				}
+		return nil
+	})
+}
 
-				descResponse, err := cfnClient.DescribeStacks(&cloudformation.DescribeStacksInput{
-					StackName: aws.String(stackName),
-				})
-				if err != nil {
-					return err
-				}
+// updateInput returns the update of the stack to the template and the image
+// returned by template and image, with parameters set as policy says.
+func (s cfnStack) updateInput(stackName string, template func(region string) ([]byte, error), image func(region string) (string, error), policy *paramPolicy) (*cloudformation.UpdateStackInput, error) {
+	templateBytes, err := template(s.Region)
+	if err != nil {
+		return nil, err
+	}
+	log.INFO.Printf("validating template")
+	validated, err := validateTemplate(s.Creds, s.Region, templateBytes)
+	if err != nil {
+		return nil, err
+	}
 
-				if len(descResponse.Stacks) > 1 {
-					return errors.NewSystemErrorF("multiple opsee stacks found for cust %s in ", u.CustomerId)
-				}
+	amiId, err := image(s.Region)
+	if err != nil {
+		return nil, err
+	}
 
-				if len(descResponse.Stacks) == 0 {
-					return errors.NewSystemErrorF("cannot find opsee stack for cust: %s", u.CustomerId)
-				}
+	log.INFO.Printf("updating with image id: %s", amiId)
 
-				stk := descResponse.Stacks[0]
-				fmt.Printf("update complete: %s, %s\n", *stk.StackStatus, *stk.StackStatusReason)
-			}
+	params, err := s.getStackParams(amiId, validated.Parameters, policy)
+	if err != nil {
+		return nil, err
+	}
 
-			return nil
-		})
-	},
+	return &cloudformation.UpdateStackInput{
+		StackName:    aws.String(stackName),
+		TemplateBody: aws.String(string(templateBytes)),
+		Capabilities: []*string{
+			aws.String("CAPABILITY_IAM"),
+		},
+		Parameters: params,
+	}, nil
 }
 
 var cfnPrint = &cobra.Command{
 	Use:   "print [customer email|customer UUID]",
 	Short: "print CFN info for customer bastion stack",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
 
 		u, err := util.GetUserFromArgs(args, 0, opseeServices)
 		if err != nil {
@@ -312,169 +357,172 @@ var cfnPrint = &cobra.Command{
 		}
 
 		if stack.Stack == nil {
-			return errors.NewUserErrorF("stack %s not found", stackName)
+			return errors.NewNotFoundErrorF("stack %s not found", stackName)
 		}
 
-		fmt.Printf("requesting stack deletion for %s\n", *stack.Stack.StackName)
-		fmt.Printf("name: %s\n", *stack.Stack.StackName)
-		fmt.Printf("region: %s\n", stack.Region)
-		fmt.Println("stack params: ")
+		info := stackInfo{Region: stack.Region, Stack: stack.Stack}
+		res := &output.Result{
+			Data:    info,
+			Columns: []output.Column{{Name: "section"}, {Name: "key"}, {Name: "value"}},
+			Rows: [][]string{
+				{"stack", "name", aws.StringValue(stack.Stack.StackName)},
+				{"stack", "region", stack.Region},
+			},
+			Text: info.print,
+		}
 		for _, p := range stack.Stack.Parameters {
-			fmt.Printf("   %s: %s\n", *p.ParameterKey, *p.ParameterValue)
+			res.Rows = append(res.Rows, []string{"param", aws.StringValue(p.ParameterKey), aws.StringValue(p.ParameterValue)})
 		}
-		fmt.Println("stack tags: ")
 		for _, t := range stack.Stack.Tags {
-			fmt.Printf("   %s: %s\n", *t.Key, *t.Value)
+			res.Rows = append(res.Rows, []string{"tag", aws.StringValue(t.Key), aws.StringValue(t.Value)})
 		}
-		return nil
+
+		return render(res)
 	},
 }
 
-var cfnEvents = &cobra.Command{
-	Use:   "events [customer email|customer UUID]",
-	Short: "list recent CFN events for a customer's bastions",
-	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+func doStacks(user *schema.User, stackname string, opseeServices svc.Services, stackFunc func(*cfnStack) error) error {
+	userCreds, err := opseeServices.GetRoleCreds(user)
+	if err != nil {
+		return errors.Wrapf(err, "cannot obtain AWS creds for user %d", user.Id)
+	}
 
-		u, err := util.GetUserFromArgs(args, 0, opseeServices)
-		if err != nil {
-			return err
-		}
+	staticCreds := credentials.NewStaticCredentials(
+		*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
 
-		if viper.GetBool("verbose") {
-			log.SetStdoutThreshold(log.LevelInfo)
-		}
+	regions, err := scanRegions(staticCreds)
+	if err != nil {
+		return err
+	}
 
-		stackName := "opsee-stack-" + u.CustomerId
-		if viper.IsSet("list-events-stack-name") {
-			stackName = viper.GetString("list-events-stack-name")
-		}
-		stack, err := findStack(u, stackName, opseeServices)
-		if err != nil {
+	// every region, a stack outside the hinted ones mustn't be skipped
+	stacks, results := searchStacks(staticCreds, regions, stackname)
+	if err := results.Err(); err != nil {
+		if len(stacks) == 0 {
 			return err
 		}
+		log.WARN.Println(err)
+	}
 
-		if stack.Stack != nil {
-			log.INFO.Printf("found bastion stack: %s in %s\n", *stack.Stack.StackId, stack.Region)
-
-			cfnClient := cloudformation.New(session.New(), aws.NewConfig().WithCredentials(stack.Creds).WithRegion(stack.Region))
-			resp, err := cfnClient.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{
-				StackName: aws.String(stackName),
-			})
-			if err != nil {
-				return err
-			}
-
-			w := new(tabwriter.Writer)
-			w.Init(os.Stdout, 1, 0, 2, ' ', 0)
-			yellow := color.New(color.FgYellow).SprintFunc()
-			blue := color.New(color.FgBlue).SprintFunc()
-			header := color.New(color.FgWhite).SprintFunc()
-
-			vinfoHead := ""
-			if viper.GetBool("verbose") {
-				vinfoHead = "extra info"
-			}
-			if len(resp.StackEvents) > 0 {
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", header("time"), "status", header("resource"), "reason", vinfoHead)
-			}
-			for i, e := range resp.StackEvents {
-				if i > viper.GetInt("list-events-num") {
-					break
-				}
-				t := *e.Timestamp
-				event := *e.ResourceStatus
-				eres := *e.LogicalResourceId
-				ereason := ""
-				vinfo := ""
-				if e.ResourceStatusReason != nil {
-					ereason = *e.ResourceStatusReason
-				}
-				if viper.GetBool("verbose") {
-					vinfo = fmt.Sprintf("%s", *e.PhysicalResourceId)
-				}
-				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", yellow(t), event, blue(eres), ereason, vinfo)
-			}
-			w.Flush()
-
-		}
-		return nil
-	},
-}
-
-func updateStackParam(params []*cloudformation.Parameter, key string, newValue string) {
-	for _, p := range params {
-		if *p.ParameterKey == key {
-			*p.ParameterValue = newValue
+	locations := newLocationCache()
+	for _, stack := range stacks {
+		locations.PutStack(user.CustomerId, stackname, cache.Entry{Region: stack.Region})
+		if err := stackFunc(stack); err != nil {
+			return err
 		}
 	}
+
+	return nil
 }
 
-func doStacks(user *schema.User, stackname string, opseeServices *svc.OpseeServices, stackFunc func(*cfnStack) error) error {
+func findStack(user *schema.User, stackname string, opseeServices svc.Services) (*cfnStack, error) {
 	userCreds, err := opseeServices.GetRoleCreds(user)
 	if err != nil {
-		return errors.NewSystemErrorF("cannot obtain AWS creds for user: %s", user.Id)
+		return nil, errors.Wrapf(err, "cannot obtain AWS creds for user %d", user.Id)
 	}
 
 	staticCreds := credentials.NewStaticCredentials(
 		*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
 
-	for _, region := range regionList {
-		log.INFO.Printf("checking %s\n", region)
-		// TODO reuse existing client/session
-		cfnClient := cloudformation.New(session.New(), aws.NewConfig().WithCredentials(staticCreds).WithRegion(region))
-		descResponse, _ := cfnClient.DescribeStacks(&cloudformation.DescribeStacksInput{
-			StackName: aws.String(stackname),
-		})
-
-		for _, stack := range descResponse.Stacks {
-			err = stackFunc(&cfnStack{
-				Creds:  staticCreds,
-				Region: region,
-				Stack:  stack,
-			})
-			if err != nil {
-				return err
+	stack := &cfnStack{
+		Creds: staticCreds,
+	}
+
+	// hinted regions are checked again by the full scan if the stack moved
+	regions, err := scanRegions(staticCreds)
+	if err != nil {
+		return nil, err
+	}
+
+	stacks, _ := searchStacks(staticCreds, stackRegionHints(user, stackname, regions, opseeServices), stackname)
+	if len(stacks) == 0 {
+		var results svc.RegionResults
+		stacks, results = searchStacks(staticCreds, regions, stackname)
+		if err := results.Err(); err != nil {
+			if len(stacks) == 0 {
+				return nil, err
 			}
+			log.WARN.Println(err)
 		}
 	}
 
-	return nil
-}
+	if len(stacks) == 0 {
+		return stack, nil
+	}
 
-func findStack(user *schema.User, stackname string, opseeServices *svc.OpseeServices) (*cfnStack, error) {
-	userCreds, err := opseeServices.GetRoleCreds(user)
-	if err != nil {
-		return nil, errors.NewSystemErrorF("cannot obtain AWS creds for user: %s", user.Id)
+	// take the first region with the stack
+	region := stacks[0].Region
+	if len(stacks) > 1 && stacks[1].Region == region {
+		return nil, errors.NewSystemErrorF("multiple opsee stacks found for cust %s in %s", user.CustomerId, region)
 	}
 
-	staticCreds := credentials.NewStaticCredentials(
-		*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
+	stack.Stack = stacks[0].Stack
+	stack.Region = region
+	newLocationCache().PutStack(user.CustomerId, stackname, cache.Entry{Region: region})
 
-	stack := &cfnStack{
-		Creds: staticCreds,
+	return stack, nil
+}
+
+// searchStacks looks for the named stack in all regions at once. Stacks are
+// returned in the order of regions.
+func searchStacks(creds *credentials.Credentials, regions []string, stackname string) ([]*cfnStack, svc.RegionResults) {
+	results := newFanOut().Run(regions, func(region string) (interface{}, error) {
+		return describeStacks(creds, region, stackname)
+	})
+
+	stacks := []*cfnStack{}
+	for _, r := range results {
+		if r.Err != nil {
+			continue
+		}
+		for _, s := range r.Value.([]*cloudformation.Stack) {
+			stacks = append(stacks, &cfnStack{Creds: creds, Region: r.Region, Stack: s})
+		}
 	}
 
-	for _, region := range regionList {
-		log.INFO.Printf("checking %s\n", region)
-		// TODO reuse existing client/session
-		cfnClient := cloudformation.New(session.New(), aws.NewConfig().WithCredentials(staticCreds).WithRegion(region))
-		descResponse, _ := cfnClient.DescribeStacks(&cloudformation.DescribeStacksInput{
-			StackName: aws.String(stackname),
-		})
+	return stacks, results
+}
 
-		if len(descResponse.Stacks) > 1 {
-			return nil, errors.NewSystemErrorF("multiple opsee stacks found for cust %s in %s", user.CustomerId, region)
+// stackRegionHints returns the regions a customer's stack is likely in: the
+// one it was cached in and the regions of the customer's bastions, limited
+// to regions. --no-cache turns them off.
+func stackRegionHints(user *schema.User, stackname string, regions []string, opseeServices svc.Services) []string {
+	hints := []string{}
+	if viper.GetBool("no-cache") {
+		return hints
+	}
+	if e, ok := newLocationCache().Stack(user.CustomerId, stackname); ok && stringInSlice(e.Region, regions) {
+		hints = append(hints, e.Region)
+	}
+
+	bastionStates, err := opseeServices.GetBastionStates([]string{user.CustomerId})
+	if err != nil {
+		log.WARN.Printf("cannot get bastion states for %s: %s\n", user.CustomerId, err)
+	}
+	for _, b := range bastionStates {
+		if stringInSlice(b.Region, regions) && !stringInSlice(b.Region, hints) {
+			hints = append(hints, b.Region)
 		}
+	}
+
+	return hints
+}
 
-		if len(descResponse.Stacks) > 0 {
-			stack.Stack = descResponse.Stacks[0]
-			stack.Region = region
-			break
+// describeStacks returns the named stacks in region, none if the stack
+// doesn't exist there.
+func describeStacks(creds *credentials.Credentials, region, stackname string) ([]*cloudformation.Stack, error) {
+	log.INFO.Printf("checking %s\n", region)
+	descResponse, err := awsClients.CloudFormation(creds, region).DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackname),
+	})
+	if err != nil {
+		if errors.KindOf(err) == errors.KindNotFound {
+			return nil, nil
 		}
+		return nil, err
 	}
 
-	return stack, nil
+	return descResponse.Stacks, nil
 }
 
 func ptos(s *string) string {
@@ -489,25 +537,33 @@ func init() {
 
 	cfnCommand.AddCommand(cfnPrint)
 
-	cfnCommand.AddCommand(cfnEvents)
-	flags := cfnEvents.Flags()
-	flags.IntP("num", "n", 10, "max number of events to display")
-	viper.BindPFlag("list-events-num", flags.Lookup("num"))
-	flags.StringP("stack", "s", "", "stack name to display instead of default opsee-stack)")
-	viper.BindPFlag("list-events-stack-name", flags.Lookup("stack"))
-
 	cfnCommand.AddCommand(cfnUpdate)
-	flags = cfnUpdate.Flags()
+	flags := cfnUpdate.Flags()
 	flags.BoolP("allow-ssh", "s", false, "allow ssh to bastion")
 	viper.BindPFlag("cfnup-allow-ssh", flags.Lookup("allow-ssh"))
 	flags.StringP("ami-id", "i", "", "use this AMI instead of template default")
 	viper.BindPFlag("cfnup-ami-id", flags.Lookup("ami-id"))
-	flags.BoolP("wait", "w", false, "wait for update to complate")
+	flags.BoolP("wait", "w", false, "wait for update to complete, printing stack events")
 	viper.BindPFlag("cfnup-wait", flags.Lookup("wait"))
+	flags.Duration("timeout", defaultStackTimeout, "with --wait, max time to wait for the update")
+	viper.BindPFlag("cfnup-timeout", flags.Lookup("timeout"))
 	flags.BoolP("userdata", "u", false, "refresh userdata")
 	viper.BindPFlag("userdata", flags.Lookup("userdata"))
-	flags.BoolP("latest", "l", false, "use latest stable ami in this region")
+	flags.BoolP("latest", "l", false, "use the latest ami of --image-channel in this region")
 	viper.BindPFlag("latest", flags.Lookup("latest"))
-
-	cfnCommand.AddCommand(cfnUserdata)
+	flags.String("image-channel", defaultImageChannel, "with --latest, image release channel")
+	viper.BindPFlag("cfnup-image-channel", flags.Lookup("image-channel"))
+	flags.String("template-file", "", "use this template instead of the one in the region's bucket")
+	viper.BindPFlag("cfnup-template-file", flags.Lookup("template-file"))
+	flags.String("channel", defaultTemplateChannel, "template channel of the region's bucket (beta, stable or another prefix)")
+	viper.BindPFlag("cfnup-channel", flags.Lookup("channel"))
+	flags.Var(&stringList{}, "param", "set a template parameter, Key=Value (repeatable)")
+	flags.Var(&stringList{}, "use-previous", "keep the previous value of a template parameter (repeatable)")
+	flags.Bool("plan", false, "preview the update as a change set and ask before executing it")
+	viper.BindPFlag("cfnup-plan", flags.Lookup("plan"))
+	flags.Bool("execute", false, "with --plan, execute the change set without asking")
+	viper.BindPFlag("cfnup-execute", flags.Lookup("execute"))
+	flags.Bool("discard", false, "with --plan, delete the change set without asking")
+	viper.BindPFlag("cfnup-discard", flags.Lookup("discard"))
+	fleetFlags(cfnUpdate, "cfnup")
 }
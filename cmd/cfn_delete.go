@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// listStackResources returns all resources of a stack.
+func listStackResources(cfnClient svc.CloudFormation, stackID string) ([]*cloudformation.StackResourceSummary, error) {
+	in := &cloudformation.ListStackResourcesInput{
+		StackName: aws.String(stackID),
+	}
+
+	resources := []*cloudformation.StackResourceSummary{}
+	for {
+		resp, err := cfnClient.ListStackResources(in)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resp.StackResourceSummaries...)
+
+		if resp.NextToken == nil {
+			return resources, nil
+		}
+		in.NextToken = resp.NextToken
+	}
+}
+
+func printStackResources(out io.Writer, resources []*cloudformation.StackResourceSummary) error {
+	res := &output.Result{
+		Columns: []output.Column{
+			{Name: "resource", Color: output.Blue},
+			{Name: "type"},
+			{Name: "physical id"},
+			{Name: "status"},
+			{Name: "reason"},
+		},
+	}
+	for _, r := range resources {
+		res.Rows = append(res.Rows, []string{aws.StringValue(r.LogicalResourceId), aws.StringValue(r.ResourceType),
+			aws.StringValue(r.PhysicalResourceId), aws.StringValue(r.ResourceStatus), aws.StringValue(r.ResourceStatusReason)})
+	}
+
+	t, err := output.New(output.Table, "", "", out)
+	if err != nil {
+		return err
+	}
+	return t.Render(res)
+}
+
+// reportLeftBehind prints the resources a stack deletion failed to delete
+// or retained, and the network interfaces keeping security groups around.
+func reportLeftBehind(out io.Writer, cfnClient svc.CloudFormation, ec2Client svc.EC2, stackID string) error {
+	resources, err := listStackResources(cfnClient, stackID)
+	if err != nil {
+		return err
+	}
+
+	left := []*cloudformation.StackResourceSummary{}
+	for _, r := range resources {
+		switch aws.StringValue(r.ResourceStatus) {
+		case cloudformation.ResourceStatusDeleteFailed, cloudformation.ResourceStatusDeleteSkipped:
+			left = append(left, r)
+		}
+	}
+	if len(left) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(out, "\nresources left behind:")
+	if err := printStackResources(out, left); err != nil {
+		return err
+	}
+
+	for _, r := range left {
+		if aws.StringValue(r.ResourceType) != "AWS::EC2::SecurityGroup" || aws.StringValue(r.PhysicalResourceId) == "" {
+			continue
+		}
+
+		groupID := aws.StringValue(r.PhysicalResourceId)
+		resp, err := ec2Client.DescribeNetworkInterfaces(&ec2.DescribeNetworkInterfacesInput{
+			Filters: []*ec2.Filter{{Name: aws.String("group-id"), Values: []*string{aws.String(groupID)}}},
+		})
+		if err != nil {
+			log.WARN.Printf("cannot get network interfaces of %s: %s\n", groupID, err)
+			continue
+		}
+		for _, ni := range resp.NetworkInterfaces {
+			fmt.Fprintf(out, "%s is used by %s (%s): %s\n", groupID, aws.StringValue(ni.NetworkInterfaceId),
+				aws.StringValue(ni.Status), aws.StringValue(ni.Description))
+		}
+	}
+
+	return nil
+}
+
+var cfnDelete = &cobra.Command{
+	Use:   "delete [customer email|customer UUID]",
+	Short: "delete a customer's bastion stack",
+	Long: `Shows the resources of a customer's bastion stack and, once the stack's name
+is typed in, deletes it. The stack's events are printed until it's deleted.
+Resources the deletion leaves behind are listed at the end, along with the
+network interfaces still using security groups that couldn't be deleted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		u, err := util.GetUserFromArgs(args, 0, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		stackName := "opsee-stack-" + u.CustomerId
+		stack, err := findStack(u, stackName, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		if stack.Stack == nil {
+			return errors.NewNotFoundErrorF("stack %s not found", stackName)
+		}
+
+		// deleted stacks can only be looked up by id
+		stackID := aws.StringValue(stack.Stack.StackId)
+		cfnClient := awsClients.CloudFormation(stack.Creds, stack.Region)
+
+		resources, err := listStackResources(cfnClient, stackID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "stack %s in %s is %s\n\n", stackName, stack.Region, aws.StringValue(stack.Stack.StackStatus))
+		if err := printStackResources(stdout, resources); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+
+		if viper.GetBool("delete-dry-run") {
+			fmt.Fprintln(stdout, "(but not really bc dry-run)")
+			return nil
+		}
+
+		ok, err := confirmTyped(stdout, stackName)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(stdout, "not deleting")
+			return nil
+		}
+
+		events, err := newStackEvents(cfnClient, stackID)
+		if err != nil {
+			return err
+		}
+
+		_, err = cfnClient.DeleteStack(&cloudformation.DeleteStackInput{
+			StackName: aws.String(stackID),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout, "requested stack deletion")
+
+		_, err = waitForStack(events, cloudformation.StackStatusDeleteComplete, viper.GetDuration("delete-timeout"), stdout)
+		if errors.KindOf(err) == errors.KindTimeout {
+			return err
+		}
+
+		if rerr := reportLeftBehind(stdout, cfnClient, awsClients.EC2(stack.Creds, stack.Region), stackID); rerr != nil {
+			log.WARN.Printf("cannot get the resources left behind: %s\n", rerr)
+		}
+		if err != nil {
+			return err
+		}
+
+		newLocationCache().DeleteStack(u.CustomerId, stackName)
+		fmt.Fprintf(stdout, "deleted stack %s\n", stackName)
+		return nil
+	},
+}
+
+func init() {
+	cfnCommand.AddCommand(cfnDelete)
+	flags := cfnDelete.Flags()
+	flags.BoolP("dry-run", "n", false, "dry run")
+	viper.BindPFlag("delete-dry-run", flags.Lookup("dry-run"))
+	flags.Duration("timeout", defaultStackTimeout, "max time to wait for the deletion")
+	viper.BindPFlag("delete-timeout", flags.Lookup("timeout"))
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+func addStackResources(env *testEnv) {
+	env.aws.Region(testRegion).StackResources[testStackName] = []*cloudformation.StackResourceSummary{
+		{
+			LogicalResourceId:  aws.String("BastionInstance"),
+			PhysicalResourceId: aws.String(testInstanceID),
+			ResourceType:       aws.String("AWS::EC2::Instance"),
+			ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateComplete),
+		},
+		{
+			LogicalResourceId:  aws.String("BastionSecurityGroup"),
+			PhysicalResourceId: aws.String("sg-1234"),
+			ResourceType:       aws.String("AWS::EC2::SecurityGroup"),
+			ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateComplete),
+		},
+	}
+}
+
+func TestCfnDelete(t *testing.T) {
+	env := newTestEnv(t)
+	addStackResources(env)
+	stdin = strings.NewReader(testStackName + "\n")
+
+	if err := cfnDelete.RunE(cfnDelete, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := env.aws.Region(testRegion)
+	if aws.StringValue(r.Stacks[0].StackStatus) != cloudformation.StackStatusDeleteComplete {
+		t.Errorf("expected the stack to be deleted, got %s", aws.StringValue(r.Stacks[0].StackStatus))
+	}
+	assertContains(t, env.out.String(), "BastionSecurityGroup", "sg-1234", "type "+testStackName+" to confirm",
+		"DELETE_IN_PROGRESS", "deleted stack "+testStackName)
+	assertNotContains(t, env.out.String(), "left behind")
+
+	if e, ok := newLocationCache().Stack(testCustomerID, testStackName); ok {
+		t.Errorf("expected the cached stack location to be removed, got %+v", e)
+	}
+}
+
+func TestCfnDeleteStatusDelay(t *testing.T) {
+	env := newTestEnv(t)
+	addStackResources(env)
+	stdin = strings.NewReader(testStackName + "\n")
+	env.aws.Region(testRegion).StatusDelay = 2
+
+	if err := cfnDelete.RunE(cfnDelete, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "deleted stack "+testStackName)
+}
+
+func TestCfnDeleteNotConfirmed(t *testing.T) {
+	env := newTestEnv(t)
+	stdin = strings.NewReader("y\n")
+
+	if err := cfnDelete.RunE(cfnDelete, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := env.aws.Region(testRegion).Calls["DeleteStack"]; calls != 0 {
+		t.Errorf("expected no deletion, got %d calls", calls)
+	}
+	assertContains(t, env.out.String(), "not deleting")
+}
+
+func TestCfnDeleteDryRun(t *testing.T) {
+	env := newTestEnv(t)
+	addStackResources(env)
+	viper.Set("delete-dry-run", true)
+
+	if err := cfnDelete.RunE(cfnDelete, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if calls := env.aws.Region(testRegion).Calls["DeleteStack"]; calls != 0 {
+		t.Errorf("expected no deletion, got %d calls", calls)
+	}
+	assertContains(t, env.out.String(), "BastionInstance", "dry-run")
+	assertNotContains(t, env.out.String(), "to confirm")
+}
+
+func TestCfnDeleteLeftBehind(t *testing.T) {
+	env := newTestEnv(t)
+	addStackResources(env)
+	stdin = strings.NewReader(testStackName + "\n")
+	r := env.aws.Region(testRegion)
+	r.DeleteFailures = map[string]string{"BastionSecurityGroup": "resource sg-1234 has a dependent object"}
+	r.NetworkInterfaces = []*ec2.NetworkInterface{
+		{
+			NetworkInterfaceId: aws.String("eni-5678"),
+			Status:             aws.String("in-use"),
+			Description:        aws.String("ELB app-lb"),
+			Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-1234")}},
+		},
+		{
+			NetworkInterfaceId: aws.String("eni-9999"),
+			Groups:             []*ec2.GroupIdentifier{{GroupId: aws.String("sg-other")}},
+		},
+	}
+
+	err := cfnDelete.RunE(cfnDelete, []string{testEmail})
+	if errors.KindOf(err) != errors.KindAWS {
+		t.Fatalf("expected an aws error, got %v", err)
+	}
+	assertContains(t, err.Error(), "BastionSecurityGroup DELETE_FAILED: resource sg-1234 has a dependent object")
+
+	out := env.out.String()
+	assertContains(t, out, "resources left behind", "sg-1234 is used by eni-5678 (in-use): ELB app-lb")
+	assertNotContains(t, out, "eni-9999", "deleted stack")
+}
@@ -0,0 +1,376 @@
+package cmd
+
+import (
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const cfnStackType = "AWS::CloudFormation::Stack"
+
+// stackEvent is a stack event, from a nested stack if Stack is set.
+type stackEvent struct {
+	*cloudformation.StackEvent
+	// logical ids of the nested stacks, separated by /
+	Stack string `json:",omitempty"`
+}
+
+// resource returns the event's logical id, prefixed with its nested stack.
+func (e stackEvent) resource() string {
+	if e.Stack != "" {
+		return e.Stack + "/" + aws.StringValue(e.LogicalResourceId)
+	}
+	return aws.StringValue(e.LogicalResourceId)
+}
+
+// eventFilter selects the events cfn events shows.
+type eventFilter struct {
+	since time.Time
+	// logical ids, with or without their nested stack
+	resources []string
+	// glob patterns, e.g. *_FAILED
+	statuses []string
+}
+
+func (f eventFilter) match(e stackEvent) bool {
+	if len(f.resources) > 0 && !stringInSlice(aws.StringValue(e.LogicalResourceId), f.resources) && !stringInSlice(e.resource(), f.resources) {
+		return false
+	}
+	if len(f.statuses) == 0 {
+		return true
+	}
+	for _, pattern := range f.statuses {
+		if ok, _ := path.Match(strings.ToUpper(pattern), aws.StringValue(e.ResourceStatus)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSince parses a duration before now or a timestamp.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.NewUserErrorF("--since %s is neither a duration (e.g. 1h) nor a timestamp (e.g. 2016-03-01T15:04:05Z)", s)
+}
+
+// splitList splits a comma separated flag value.
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// eventLister reads the events of a stack and, with nested, of the stacks
+// nested in it.
+type eventLister struct {
+	cfnClient svc.CloudFormation
+	filter    eventFilter
+	nested    bool
+	// nested stack id -> logical ids of the stacks it's nested in
+	stacks map[string]string
+	// time of the newest event read
+	newest time.Time
+}
+
+func newEventLister(cfnClient svc.CloudFormation, filter eventFilter, nested bool) *eventLister {
+	return &eventLister{
+		cfnClient: cfnClient,
+		filter:    filter,
+		nested:    nested,
+		stacks:    make(map[string]string),
+	}
+}
+
+// list returns the matching events of a stack and its nested stacks from
+// since on, newest first. limit caps the number of events, 0 for no limit.
+func (l *eventLister) list(stackID string, since time.Time, limit int) ([]stackEvent, error) {
+	if l.filter.since.After(since) {
+		since = l.filter.since
+	}
+
+	events, err := l.stackEvents(stackID, "", since, limit)
+	if err != nil {
+		return nil, err
+	}
+	if !l.nested {
+		return events, nil
+	}
+	if err := l.nestedStacks(stackID, ""); err != nil {
+		return nil, err
+	}
+
+	// nested stacks can have more of them
+	done := make(map[string]bool)
+	for {
+		ids := []string{}
+		for id := range l.stacks {
+			if !done[id] {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+		sort.Strings(ids)
+
+		for _, id := range ids {
+			done[id] = true
+			nested, err := l.stackEvents(id, l.stacks[id], since, limit)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, nested...)
+			if err := l.nestedStacks(id, l.stacks[id]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return aws.TimeValue(events[i].Timestamp).After(aws.TimeValue(events[j].Timestamp))
+	})
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// stackEvents returns the matching events of one stack, reading pages
+// until it has limit events or reaches since.
+func (l *eventLister) stackEvents(stackID, prefix string, since time.Time, limit int) ([]stackEvent, error) {
+	in := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackID),
+	}
+
+	events := []stackEvent{}
+	for {
+		resp, err := l.cfnClient.DescribeStackEvents(in)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range resp.StackEvents {
+			ts := aws.TimeValue(e.Timestamp)
+			if ts.Before(since) {
+				return events, nil
+			}
+			if ts.After(l.newest) {
+				l.newest = ts
+			}
+
+			se := stackEvent{StackEvent: e, Stack: prefix}
+			if l.filter.match(se) {
+				events = append(events, se)
+				if limit > 0 && len(events) >= limit {
+					return events, nil
+				}
+			}
+		}
+
+		if resp.NextToken == nil {
+			return events, nil
+		}
+		in.NextToken = resp.NextToken
+	}
+}
+
+// nestedStacks adds the stacks nested in a stack, from its resources, to
+// l.stacks with their logical ids after prefix.
+func (l *eventLister) nestedStacks(stackID, prefix string) error {
+	in := &cloudformation.ListStackResourcesInput{
+		StackName: aws.String(stackID),
+	}
+
+	for {
+		resp, err := l.cfnClient.ListStackResources(in)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range resp.StackResourceSummaries {
+			id := aws.StringValue(r.PhysicalResourceId)
+			if aws.StringValue(r.ResourceType) != cfnStackType || id == "" {
+				continue
+			}
+			if _, ok := l.stacks[id]; !ok {
+				name := aws.StringValue(r.LogicalResourceId)
+				if prefix != "" {
+					name = prefix + "/" + name
+				}
+				l.stacks[id] = name
+			}
+		}
+
+		if resp.NextToken == nil {
+			return nil
+		}
+		in.NextToken = resp.NextToken
+	}
+}
+
+// followEvents prints the events listed so far, oldest first, and then new
+// events of a stack until it's deleted. polls limits how often the events
+// are read again, 0 for no limit.
+func followEvents(l *eventLister, stack *cloudformation.Stack, listed []stackEvent, polls int, out io.Writer) error {
+	seen := make(map[string]bool)
+	for i := len(listed) - 1; i >= 0; i-- {
+		printStackEvent(out, listed[i])
+		seen[aws.StringValue(listed[i].EventId)] = true
+	}
+
+	for i := 1; polls <= 0 || i <= polls; i++ {
+		time.Sleep(stackPollInterval)
+
+		// events can share a timestamp, so start at the newest one again
+		events, err := l.list(aws.StringValue(stack.StackId), l.newest, 0)
+		if err != nil {
+			return err
+		}
+
+		for j := len(events) - 1; j >= 0; j-- {
+			e := events[j]
+			if seen[aws.StringValue(e.EventId)] {
+				continue
+			}
+			seen[aws.StringValue(e.EventId)] = true
+			printStackEvent(out, e)
+
+			if e.Stack == "" && aws.StringValue(e.LogicalResourceId) == aws.StringValue(stack.StackName) &&
+				aws.StringValue(e.ResourceStatus) == cloudformation.ResourceStatusDeleteComplete {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+var cfnEvents = &cobra.Command{
+	Use:   "events [customer email|customer UUID]",
+	Short: "list recent CFN events for a customer's bastions",
+	Long: `Lists the events of a customer's bastion stack, newest first. --follow
+prints the events oldest first and then keeps printing new ones as they
+happen, until the stack is deleted or boop is interrupted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		u, err := util.GetUserFromArgs(args, 0, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		since, err := parseSince(viper.GetString("list-events-since"), time.Now())
+		if err != nil {
+			return err
+		}
+		filter := eventFilter{
+			since:     since,
+			resources: splitList(viper.GetString("list-events-resource")),
+			statuses:  splitList(viper.GetString("list-events-status")),
+		}
+		limit := viper.GetInt("list-events-num")
+		// --since without -n shows everything since then
+		if !since.IsZero() && !cmd.Flags().Changed("num") {
+			limit = 0
+		}
+
+		stackName := "opsee-stack-" + u.CustomerId
+		if viper.GetString("list-events-stack-name") != "" {
+			stackName = viper.GetString("list-events-stack-name")
+		}
+		stack, err := findStack(u, stackName, opseeServices)
+		if err != nil {
+			return err
+		}
+
+		if stack.Stack == nil {
+			return errors.NewNotFoundErrorF("stack %s not found", stackName)
+		}
+		log.INFO.Printf("found bastion stack: %s in %s\n", *stack.Stack.StackId, stack.Region)
+
+		l := newEventLister(awsClients.CloudFormation(stack.Creds, stack.Region), filter, viper.GetBool("list-events-nested"))
+		events, err := l.list(aws.StringValue(stack.Stack.StackId), time.Time{}, limit)
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("list-events-follow") {
+			return followEvents(l, stack.Stack, events, 0, stdout)
+		}
+
+		res := &output.Result{
+			Data: events,
+			Columns: []output.Column{
+				{Name: "time", Color: output.Yellow},
+				{Name: "status"},
+				{Name: "resource", Color: output.Blue},
+				{Name: "reason"},
+			},
+		}
+		if viper.GetBool("verbose") {
+			res.Columns = append(res.Columns, output.Column{Name: "extra info"})
+		}
+
+		for _, e := range events {
+			row := []string{aws.TimeValue(e.Timestamp).String(), aws.StringValue(e.ResourceStatus),
+				e.resource(), aws.StringValue(e.ResourceStatusReason)}
+			if viper.GetBool("verbose") {
+				row = append(row, aws.StringValue(e.PhysicalResourceId))
+			}
+			res.Rows = append(res.Rows, row)
+		}
+
+		return render(res)
+	},
+}
+
+func init() {
+	cfnCommand.AddCommand(cfnEvents)
+	flags := cfnEvents.Flags()
+	flags.IntP("num", "n", 10, "max number of events to display, 0 for all")
+	viper.BindPFlag("list-events-num", flags.Lookup("num"))
+	flags.StringP("stack", "s", "", "stack name to display instead of default opsee-stack)")
+	viper.BindPFlag("list-events-stack-name", flags.Lookup("stack"))
+	flags.BoolP("follow", "f", false, "keep printing new events")
+	viper.BindPFlag("list-events-follow", flags.Lookup("follow"))
+	flags.String("since", "", "only events since a duration ago (e.g. 2h) or a timestamp (e.g. 2016-03-01T15:04:05Z)")
+	viper.BindPFlag("list-events-since", flags.Lookup("since"))
+	flags.String("resource", "", "only events of these logical resource ids, comma separated")
+	viper.BindPFlag("list-events-resource", flags.Lookup("resource"))
+	flags.String("status", "", "only events with these statuses, comma separated, * matches anything (e.g. *_FAILED)")
+	viper.BindPFlag("list-events-status", flags.Lookup("status"))
+	flags.Bool("nested", false, "include the events of nested stacks")
+	viper.BindPFlag("list-events-nested", flags.Lookup("nested"))
+}
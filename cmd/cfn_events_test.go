@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/spf13/viper"
+)
+
+const testNestedStackID = "arn:aws:cloudformation:us-west-2:123456789012:stack/" + testStackName + "-Ingress/2"
+
+// addNestedStack nests a stack with one event in the test stack.
+func addNestedStack(env *testEnv) {
+	r := env.aws.Region(testRegion)
+	r.Stacks = append(r.Stacks, &cloudformation.Stack{
+		StackId:     aws.String(testNestedStackID),
+		StackName:   aws.String(testStackName + "-Ingress"),
+		StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+	})
+	r.StackEvents[testStackName+"-Ingress"] = []*cloudformation.StackEvent{
+		{
+			EventId:              aws.String("nested-1"),
+			Timestamp:            aws.Time(time.Now().Add(-30 * time.Second)),
+			ResourceStatus:       aws.String(cloudformation.ResourceStatusCreateFailed),
+			ResourceStatusReason: aws.String("ingress rule already exists"),
+			LogicalResourceId:    aws.String("SecurityGroupIngress"),
+		},
+	}
+	r.StackEvents[testStackName] = append(r.StackEvents[testStackName], &cloudformation.StackEvent{
+		EventId:            aws.String("create-0"),
+		Timestamp:          aws.Time(time.Now().Add(-2 * time.Minute)),
+		ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateInProgress),
+		ResourceType:       aws.String(cfnStackType),
+		LogicalResourceId:  aws.String("Ingress"),
+		PhysicalResourceId: aws.String(testNestedStackID),
+	})
+	r.StackResources[testStackName] = append(r.StackResources[testStackName], &cloudformation.StackResourceSummary{
+		LogicalResourceId:  aws.String("Ingress"),
+		PhysicalResourceId: aws.String(testNestedStackID),
+		ResourceType:       aws.String(cfnStackType),
+		ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateComplete),
+	})
+}
+
+func TestCfnEventsNum(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("list-events-num", 1)
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), cloudformation.ResourceStatusCreateComplete)
+	assertNotContains(t, env.out.String(), "BastionInstance")
+}
+
+func TestCfnEventsPages(t *testing.T) {
+	env := newTestEnv(t)
+	env.aws.Region(testRegion).EventPageSize = 1
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), cloudformation.ResourceStatusCreateComplete, "BastionInstance")
+	if calls := env.aws.Region(testRegion).Calls["DescribeStackEvents"]; calls != 2 {
+		t.Errorf("expected 2 pages, got %d", calls)
+	}
+}
+
+func TestCfnEventsFilters(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("list-events-status", "*_in_progress")
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "BastionInstance")
+	assertNotContains(t, env.out.String(), cloudformation.ResourceStatusCreateComplete)
+
+	env = newTestEnv(t)
+	viper.Set("list-events-resource", testStackName)
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), cloudformation.ResourceStatusCreateComplete)
+	assertNotContains(t, env.out.String(), "BastionInstance")
+}
+
+func TestCfnEventsSince(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("list-events-since", "30s")
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), cloudformation.ResourceStatusCreateComplete)
+	assertNotContains(t, env.out.String(), "BastionInstance")
+
+	viper.Set("list-events-since", "yesterday")
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err == nil {
+		t.Error("expected an error for a bad --since")
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	for s, expected := range map[string]time.Time{
+		"":                     {},
+		"2h":                   time.Date(2016, 3, 1, 10, 0, 0, 0, time.UTC),
+		"2016-02-01T08:30:00Z": time.Date(2016, 2, 1, 8, 30, 0, 0, time.UTC),
+		"2016-02-01":           time.Date(2016, 2, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		since, err := parseSince(s, now)
+		if err != nil {
+			t.Errorf("%q: %s", s, err)
+		} else if !since.Equal(expected) {
+			t.Errorf("%q: expected %s, got %s", s, expected, since)
+		}
+	}
+}
+
+func TestCfnEventsNested(t *testing.T) {
+	env := newTestEnv(t)
+	addNestedStack(env)
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertNotContains(t, env.out.String(), "SecurityGroupIngress")
+
+	env = newTestEnv(t)
+	addNestedStack(env)
+	viper.Set("list-events-nested", true)
+	viper.Set("list-events-status", "*_FAILED")
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "Ingress/SecurityGroupIngress", "ingress rule already exists")
+	assertNotContains(t, env.out.String(), "BastionInstance")
+}
+
+func TestCfnEventsNestedSince(t *testing.T) {
+	env := newTestEnv(t)
+	addNestedStack(env)
+	viper.Set("list-events-nested", true)
+	// the nested stack's creation is older than that
+	viper.Set("list-events-since", "1m")
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "Ingress/SecurityGroupIngress")
+}
+
+func TestCfnEventsFollow(t *testing.T) {
+	env := newTestEnv(t)
+	stack := env.aws.Region(testRegion).Stacks[0]
+	l := newEventLister(env.aws.CloudFormation(nil, testRegion), eventFilter{}, false)
+	events, err := l.list(aws.StringValue(stack.StackId), time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := followEvents(l, stack, events, 2, env.out); err != nil {
+		t.Fatal(err)
+	}
+
+	out := env.out.String()
+	// oldest first, and printed once
+	if strings.Index(out, "BastionInstance") > strings.Index(out, cloudformation.ResourceStatusCreateComplete) {
+		t.Errorf("expected the oldest event first:\n%s", out)
+	}
+	if n := strings.Count(out, "BastionInstance"); n != 1 {
+		t.Errorf("expected the event once, got %d times", n)
+	}
+	if calls := env.aws.Region(testRegion).Calls["DescribeStackEvents"]; calls != 3 {
+		t.Errorf("expected 3 reads of the events, got %d", calls)
+	}
+}
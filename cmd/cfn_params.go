@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// paramOverrides are the stack parameters set with --param and kept with
+// --use-previous, in the order given.
+type paramOverrides struct {
+	keys     []string
+	values   map[string]string
+	previous map[string]bool
+}
+
+// paramPolicy is how an update sets the stack parameters boop manages.
+type paramPolicy struct {
+	// AllowSSH value, "" to keep the previous value
+	allowSSH string
+	// replace the userdata with the stack's, fixed up
+	userdata  bool
+	overrides *paramOverrides
+}
+
+// updateParamPolicy returns the policy of cfn update's --allow-ssh,
+// --userdata, --param and --use-previous.
+func updateParamPolicy(flags *pflag.FlagSet) (*paramPolicy, error) {
+	overrides, err := parseParamOverrides(flagList(flags, "param"), flagList(flags, "use-previous"))
+	if err != nil {
+		return nil, err
+	}
+
+	p := &paramPolicy{
+		allowSSH:  "False",
+		userdata:  viper.GetBool("userdata"),
+		overrides: overrides,
+	}
+	if viper.IsSet("cfnup-allow-ssh") {
+		p.allowSSH = "True"
+	}
+	return p, nil
+}
+
+// previousParams returns the policy of updates that change only the template
+// and the image, keeping the previous value of every other parameter.
+func previousParams() *paramPolicy {
+	return &paramPolicy{overrides: &paramOverrides{}}
+}
+
+// parseParamOverrides reads Key=Value pairs and the keys whose previous
+// values are kept. Values are taken as they are, so --param
+// Subnets=subnet-1,subnet-2 sets a CommaDelimitedList.
+func parseParamOverrides(params, usePrevious []string) (*paramOverrides, error) {
+	o := &paramOverrides{
+		values:   make(map[string]string),
+		previous: make(map[string]bool),
+	}
+
+	for _, p := range params {
+		i := strings.Index(p, "=")
+		if i < 0 {
+			return nil, errors.NewUserErrorF("parameters are Key=Value, got %q", p)
+		}
+
+		key := strings.TrimSpace(p[:i])
+		if key == "" {
+			return nil, errors.NewUserErrorF("parameters are Key=Value, got %q", p)
+		}
+		if _, ok := o.values[key]; ok {
+			return nil, errors.NewUserErrorF("parameter %s is set more than once", key)
+		}
+		o.keys = append(o.keys, key)
+		o.values[key] = p[i+1:]
+	}
+
+	for _, key := range usePrevious {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, errors.NewUserError("--use-previous needs a parameter key")
+		}
+		if _, ok := o.values[key]; ok {
+			return nil, errors.NewUserErrorF("parameter %s can't be set and use its previous value", key)
+		}
+		if !o.previous[key] {
+			o.keys = append(o.keys, key)
+			o.previous[key] = true
+		}
+	}
+
+	return o, nil
+}
+
+// overrideParams applies overrides to params once the template's declared
+// parameter keys show it takes them.
+func (s cfnStack) overrideParams(params []*cloudformation.Parameter, keys []string, o *paramOverrides) ([]*cloudformation.Parameter, error) {
+	for _, key := range o.keys {
+		if !stringInSlice(key, keys) {
+			if len(keys) == 0 {
+				return nil, errors.NewUserErrorF("template has no parameter %s, it declares none", key)
+			}
+			return nil, errors.NewUserErrorF("template has no parameter %s, it declares %s", key, strings.Join(keys, ", "))
+		}
+
+		p := &cloudformation.Parameter{ParameterKey: aws.String(key)}
+		if o.previous[key] {
+			if !s.hasParam(key) {
+				return nil, errors.NewUserErrorF("stack has no previous value for %s", key)
+			}
+			p.UsePreviousValue = aws.Bool(true)
+		} else {
+			p.ParameterValue = aws.String(o.values[key])
+		}
+		params = updateStackParam(params, p)
+	}
+
+	return params, nil
+}
+
+// stackParam returns the parameter with key, nil if there's none.
+func stackParam(params []*cloudformation.Parameter, key string) *cloudformation.Parameter {
+	for _, p := range params {
+		if aws.StringValue(p.ParameterKey) == key {
+			return p
+		}
+	}
+	return nil
+}
+
+// updateStackParam replaces the parameter with p's key, or adds p.
+func updateStackParam(params []*cloudformation.Parameter, p *cloudformation.Parameter) []*cloudformation.Parameter {
+	for i, old := range params {
+		if aws.StringValue(old.ParameterKey) == aws.StringValue(p.ParameterKey) {
+			params[i] = p
+			return params
+		}
+	}
+	return append(params, p)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+const testParamsTemplate = `{
+  "Parameters": {
+    "AllowSSH": {},
+    "ImageId": {},
+    "InstanceType": {"Default": "t2.micro"},
+    "KeyName": {},
+    "Subnets": {},
+    "UserData": {},
+    "VpcId": {}
+  }
+}`
+
+func TestCfnUpdateParams(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.aws.Region(testRegion)
+	r.Stacks[0].Parameters = append(r.Stacks[0].Parameters,
+		&cloudformation.Parameter{ParameterKey: aws.String("KeyName"), ParameterValue: aws.String("ops")})
+	viper.Set("cfnup-template-file", writeTemplate(t, testParamsTemplate))
+	setFlagList(t, cfnUpdate, "param", "InstanceType=t2.small", "Subnets=subnet-1,subnet-2")
+	setFlagList(t, cfnUpdate, "use-previous", "AllowSSH")
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.StackUpdates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(r.StackUpdates))
+	}
+	params := r.StackUpdates[0].Parameters
+	if p := stackParam(params, "InstanceType"); aws.StringValue(p.ParameterValue) != "t2.small" {
+		t.Errorf("unexpected InstanceType %v", p)
+	}
+	if p := stackParam(params, "Subnets"); aws.StringValue(p.ParameterValue) != "subnet-1,subnet-2" {
+		t.Errorf("unexpected Subnets %v", p)
+	}
+	if p := stackParam(params, "AllowSSH"); !aws.BoolValue(p.UsePreviousValue) || p.ParameterValue != nil {
+		t.Errorf("expected AllowSSH to use its previous value, got %v", p)
+	}
+	// declared, on the stack and unknown to boop
+	if p := stackParam(params, "KeyName"); p == nil || !aws.BoolValue(p.UsePreviousValue) {
+		t.Errorf("expected KeyName to use its previous value, got %v", p)
+	}
+}
+
+func TestCfnUpdateDeclaredSubset(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-template-file", writeTemplate(t, `{"Parameters": {"ImageId": {}, "InstanceType": {"Default": "t2.micro"}}}`))
+	viper.Set("cfnup-ami-id", "ami-new")
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := env.aws.Region(testRegion)
+	params := r.StackUpdates[0].Parameters
+	// the stack has no InstanceType, the template's default applies
+	if len(params) != 1 || aws.StringValue(params[0].ParameterKey) != "ImageId" || aws.StringValue(params[0].ParameterValue) != "ami-new" {
+		t.Errorf("expected only the new ImageId, got %v", params)
+	}
+
+	viper.Set("userdata", true)
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	assertContains(t, err.Error(), "template has no parameter UserData")
+	if len(r.StackUpdates) != 1 {
+		t.Error("expected no more stack updates")
+	}
+}
+
+func TestCfnUpdateUndeclaredParam(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-template-file", writeTemplate(t, testParamsTemplate))
+	setFlagList(t, cfnUpdate, "param", "DiskSize=20")
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	assertContains(t, err.Error(), "template has no parameter DiskSize", "AllowSSH, ImageId, InstanceType")
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected no stack updates")
+	}
+}
+
+func TestCfnUpdateUsePreviousMissing(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-template-file", writeTemplate(t, testParamsTemplate))
+	setFlagList(t, cfnUpdate, "use-previous", "KeyName")
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	assertContains(t, err.Error(), "no previous value for KeyName")
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected no stack updates")
+	}
+}
+
+func TestParseParamOverrides(t *testing.T) {
+	for _, tc := range []struct {
+		params, previous []string
+		err              string
+	}{
+		{params: []string{"subnet-1"}, err: "parameters are Key=Value"},
+		{params: []string{"Subnets=subnet-1", "subnet-2"}, err: "parameters are Key=Value"},
+		{previous: []string{" "}, err: "needs a parameter key"},
+		{params: []string{"=1"}, err: "parameters are Key=Value"},
+		{params: []string{"A=1", "A=2"}, err: "A is set more than once"},
+		{params: []string{"A=1"}, previous: []string{"A"}, err: "A can't be set and use its previous value"},
+	} {
+		_, err := parseParamOverrides(tc.params, tc.previous)
+		if !errors.IsUserError(err) {
+			t.Errorf("%v %v: expected a user error, got %v", tc.params, tc.previous, err)
+			continue
+		}
+		assertContains(t, err.Error(), tc.err)
+	}
+
+	o, err := parseParamOverrides([]string{"A=x=y", "B=", `D= "a,,b" `}, []string{"C", "C"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.keys) != 4 || o.values["A"] != "x=y" || o.values["B"] != "" || o.values["D"] != ` "a,,b" ` || !o.previous["C"] {
+		t.Errorf("unexpected overrides %+v", o)
+	}
+}
@@ -0,0 +1,292 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/viper"
+)
+
+const (
+	changeSetTimeout = 5 * time.Minute
+	diffContext      = 3
+)
+
+// how often a change set's status is polled, shortened in tests
+var changeSetPollInterval = 2 * time.Second
+
+// planStackUpdate shows what updateStack would change: the parameters, the
+// template and, from a change set, the resources. The change set is then
+// executed or deleted, asking first unless --execute or --discard is given.
+func planStackUpdate(u *schema.User, opseeServices svc.Services, template func(region string) ([]byte, error), image func(region string) (string, error), policy *paramPolicy, wait bool, out io.Writer) error {
+	stackName := "opsee-stack-" + u.CustomerId
+	return doStacks(u, stackName, opseeServices, func(stack *cfnStack) error {
+		log.INFO.Printf("found stack: %s in %s\n", *stack.Stack.StackId, stack.Region)
+
+		in, err := stack.updateInput(stackName, template, image, policy)
+		if err != nil {
+			return err
+		}
+
+		cfnClient := awsClients.CloudFormation(stack.Creds, stack.Region)
+
+		fmt.Fprintf(out, "stack %s in %s\n\n", stackName, stack.Region)
+		if err := printParamDiff(out, stack.Stack.Parameters, in.Parameters); err != nil {
+			return err
+		}
+
+		current, err := cfnClient.GetTemplate(&cloudformation.GetTemplateInput{StackName: aws.String(stackName)})
+		if err != nil {
+			return err
+		}
+		if diff := templateDiff(aws.StringValue(current.TemplateBody), aws.StringValue(in.TemplateBody)); diff != "" {
+			fmt.Fprintf(out, "template:\n%s\n", diff)
+		} else {
+			fmt.Fprint(out, "template: no changes\n\n")
+		}
+
+		name := fmt.Sprintf("boop-%d", time.Now().Unix())
+		_, err = cfnClient.CreateChangeSet(&svc.CreateChangeSetInput{
+			ChangeSetName: aws.String(name),
+			StackName:     in.StackName,
+			Description:   aws.String("boop cfn update --plan"),
+			TemplateBody:  in.TemplateBody,
+			Capabilities:  in.Capabilities,
+			Parameters:    in.Parameters,
+		})
+		if err != nil {
+			return err
+		}
+
+		changeSet, err := waitForChangeSet(cfnClient, stackName, name)
+		if err != nil {
+			return err
+		}
+
+		discard := func() error {
+			_, err := cfnClient.DeleteChangeSet(&svc.DeleteChangeSetInput{
+				ChangeSetName: aws.String(name),
+				StackName:     aws.String(stackName),
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "discarded change set %s\n", name)
+			return nil
+		}
+
+		if aws.StringValue(changeSet.Status) == svc.ChangeSetStatusFailed {
+			// CloudFormation fails change sets without changes
+			if strings.Contains(aws.StringValue(changeSet.StatusReason), "didn't contain changes") {
+				fmt.Fprintln(out, "no changes")
+				return discard()
+			}
+			return errors.Newf(errors.KindAWS, "change set %s failed: %s", name, aws.StringValue(changeSet.StatusReason))
+		}
+
+		fmt.Fprintf(out, "change set %s:\n", name)
+		if err := printChanges(out, changeSet.Changes); err != nil {
+			return err
+		}
+		fmt.Fprintln(out)
+
+		execute := viper.GetBool("cfnup-execute")
+		if !execute && !viper.GetBool("cfnup-discard") {
+			execute, err = confirm(out, "execute change set "+name+"?")
+			if err != nil {
+				return err
+			}
+		}
+		if !execute {
+			return discard()
+		}
+
+		var events *stackEvents
+		if wait {
+			events, err = newStackEvents(cfnClient, stackName)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = cfnClient.ExecuteChangeSet(&svc.ExecuteChangeSetInput{
+			ChangeSetName: aws.String(name),
+			StackName:     aws.String(stackName),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "executing change set %s\n", name)
+
+		if wait {
+			return waitForStackUpdate(events, viper.GetDuration("cfnup-timeout"), out)
+		}
+		return nil
+	})
+}
+
+// waitForChangeSet polls a change set until CloudFormation is done
+// creating it.
+func waitForChangeSet(cfnClient svc.CloudFormation, stackName, name string) (*svc.DescribeChangeSetOutput, error) {
+	deadline := time.Now().Add(changeSetTimeout)
+
+	for {
+		changeSet, err := describeChangeSet(cfnClient, stackName, name)
+		if err != nil {
+			return nil, err
+		}
+
+		switch aws.StringValue(changeSet.Status) {
+		case svc.ChangeSetStatusCreateComplete, svc.ChangeSetStatusFailed:
+			return changeSet, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, errors.Newf(errors.KindTimeout, "change set %s not created after %s", name, changeSetTimeout)
+		}
+		log.INFO.Printf("waiting for change set %s: %s\n", name, aws.StringValue(changeSet.Status))
+		time.Sleep(changeSetPollInterval)
+	}
+}
+
+// describeChangeSet returns a change set with the changes of all pages.
+func describeChangeSet(cfnClient svc.CloudFormation, stackName, name string) (*svc.DescribeChangeSetOutput, error) {
+	in := &svc.DescribeChangeSetInput{
+		ChangeSetName: aws.String(name),
+		StackName:     aws.String(stackName),
+	}
+
+	changeSet, err := cfnClient.DescribeChangeSet(in)
+	if err != nil {
+		return nil, err
+	}
+	for changeSet.NextToken != nil {
+		in.NextToken = changeSet.NextToken
+		page, err := cfnClient.DescribeChangeSet(in)
+		if err != nil {
+			return nil, err
+		}
+		changeSet.Changes = append(changeSet.Changes, page.Changes...)
+		changeSet.NextToken = page.NextToken
+	}
+
+	return changeSet, nil
+}
+
+// printParamDiff prints the parameters that change, with UserData decoded
+// and diffed.
+func printParamDiff(out io.Writer, current, update []*cloudformation.Parameter) error {
+	old := make(map[string]string)
+	for _, p := range current {
+		old[aws.StringValue(p.ParameterKey)] = aws.StringValue(p.ParameterValue)
+	}
+
+	res := &output.Result{
+		Columns: []output.Column{
+			{Name: "parameter", Color: output.Yellow},
+			{Name: "old", Color: output.Red},
+			{Name: "new", Color: output.Green},
+		},
+	}
+	userdataDiff := ""
+	for _, p := range update {
+		key := aws.StringValue(p.ParameterKey)
+		if aws.BoolValue(p.UsePreviousValue) || old[key] == aws.StringValue(p.ParameterValue) {
+			continue
+		}
+
+		if key == "UserData" {
+			oldUserdata, _ := base64.StdEncoding.DecodeString(old[key])
+			newUserdata, _ := base64.StdEncoding.DecodeString(aws.StringValue(p.ParameterValue))
+			userdataDiff = util.Diff("UserData (current)", "UserData (update)", string(oldUserdata), string(newUserdata), diffContext)
+			res.Rows = append(res.Rows, []string{key, "(see diff)", "(see diff)"})
+			continue
+		}
+		res.Rows = append(res.Rows, []string{key, old[key], aws.StringValue(p.ParameterValue)})
+	}
+
+	if len(res.Rows) == 0 {
+		fmt.Fprint(out, "parameters: no changes\n\n")
+		return nil
+	}
+
+	fmt.Fprintln(out, "parameters:")
+	t, err := output.New(output.Table, "", "", out)
+	if err != nil {
+		return err
+	}
+	if err := t.Render(res); err != nil {
+		return err
+	}
+	fmt.Fprintln(out)
+	if userdataDiff != "" {
+		fmt.Fprintln(out, userdataDiff)
+	}
+	return nil
+}
+
+// templateDiff diffs two templates, reformatted first if they're json so
+// only real changes show.
+func templateDiff(current, update string) string {
+	return util.Diff("template (current)", "template (update)", normalizeTemplate(current), normalizeTemplate(update), diffContext)
+}
+
+func normalizeTemplate(template string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(template), &v); err != nil {
+		return template
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return template
+	}
+	return string(b) + "\n"
+}
+
+func printChanges(out io.Writer, changes []*svc.Change) error {
+	res := &output.Result{
+		Columns: []output.Column{
+			{Name: "action"},
+			{Name: "resource", Color: output.Blue},
+			{Name: "type"},
+			{Name: "replacement"},
+			{Name: "scope"},
+		},
+	}
+
+	for _, c := range changes {
+		rc := c.ResourceChange
+		if rc == nil {
+			continue
+		}
+		replacement := aws.StringValue(rc.Replacement)
+		if replacement == "True" {
+			replacement = output.Red.SprintFunc()(replacement)
+		}
+		res.Rows = append(res.Rows, []string{aws.StringValue(rc.Action), aws.StringValue(rc.LogicalResourceId),
+			aws.StringValue(rc.ResourceType), replacement, strings.Join(aws.StringValueSlice(rc.Scope), ",")})
+	}
+
+	if len(res.Rows) == 0 {
+		fmt.Fprintln(out, "no resource changes")
+		return nil
+	}
+
+	t, err := output.New(output.Table, "", "", out)
+	if err != nil {
+		return err
+	}
+	return t.Render(res)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// rollbackStack runs op on a customer's bastion stack in status and prints
+// the stack's events until it's rolled back.
+func rollbackStack(args []string, status string, timeout time.Duration, op func(cfnClient svc.CloudFormation, stackName string) error) error {
+	opseeServices, err := newOpseeServices()
+	if err != nil {
+		return err
+	}
+
+	u, err := util.GetUserFromArgs(args, 0, opseeServices)
+	if err != nil {
+		return err
+	}
+
+	if viper.GetBool("verbose") {
+		log.SetStdoutThreshold(log.LevelInfo)
+	}
+
+	stackName := "opsee-stack-" + u.CustomerId
+	stack, err := findStack(u, stackName, opseeServices)
+	if err != nil {
+		return err
+	}
+
+	if stack.Stack == nil {
+		return errors.NewNotFoundErrorF("stack %s not found", stackName)
+	}
+	if s := aws.StringValue(stack.Stack.StackStatus); s != status {
+		return errors.NewUserErrorF("stack %s is %s, not %s", stackName, s, status)
+	}
+	log.INFO.Printf("found stack: %s in %s\n", *stack.Stack.StackId, stack.Region)
+
+	cfnClient := awsClients.CloudFormation(stack.Creds, stack.Region)
+	events, err := newStackEvents(cfnClient, stackName)
+	if err != nil {
+		return err
+	}
+
+	if err := op(cfnClient, stackName); err != nil {
+		return err
+	}
+
+	s, err := waitForStack(events, cloudformation.StackStatusUpdateRollbackComplete, timeout, stdout)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "rollback complete: %s\n", aws.StringValue(s.StackStatus))
+	return nil
+}
+
+var cfnRollback = &cobra.Command{
+	Use:   "rollback [customer email|customer UUID]",
+	Short: "continue rolling back a bastion stack stuck in UPDATE_ROLLBACK_FAILED",
+	Long: `Continues rolling back a customer's bastion stack after the rollback of an
+update failed. Resources that can't be rolled back, e.g. because they were
+deleted by hand, can be skipped with --resources-to-skip. Resources of nested
+stacks are given as NestedStack.Resource.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackStack(args, cloudformation.StackStatusUpdateRollbackFailed, viper.GetDuration("rollback-timeout"),
+			func(cfnClient svc.CloudFormation, stackName string) error {
+				in := &svc.ContinueUpdateRollbackInput{
+					StackName:       aws.String(stackName),
+					ResourcesToSkip: aws.StringSlice(splitList(viper.GetString("rollback-resources-to-skip"))),
+				}
+				if len(in.ResourcesToSkip) == 0 {
+					in.ResourcesToSkip = nil
+				}
+
+				if _, err := cfnClient.ContinueUpdateRollback(in); err != nil {
+					return err
+				}
+				fmt.Fprintln(stdout, "continuing rollback")
+				return nil
+			})
+	},
+}
+
+var cfnCancelUpdate = &cobra.Command{
+	Use:   "cancel-update [customer email|customer UUID]",
+	Short: "cancel a bastion stack update in progress and roll it back",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return rollbackStack(args, cloudformation.StackStatusUpdateInProgress, viper.GetDuration("cancel-timeout"),
+			func(cfnClient svc.CloudFormation, stackName string) error {
+				_, err := cfnClient.CancelUpdateStack(&cloudformation.CancelUpdateStackInput{
+					StackName: aws.String(stackName),
+				})
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(stdout, "cancelled update")
+				return nil
+			})
+	},
+}
+
+func init() {
+	cfnCommand.AddCommand(cfnRollback)
+	flags := cfnRollback.Flags()
+	flags.String("resources-to-skip", "", "logical ids of resources not to roll back, comma separated")
+	viper.BindPFlag("rollback-resources-to-skip", flags.Lookup("resources-to-skip"))
+	flags.Duration("timeout", defaultStackTimeout, "max time to wait for the rollback")
+	viper.BindPFlag("rollback-timeout", flags.Lookup("timeout"))
+
+	cfnCommand.AddCommand(cfnCancelUpdate)
+	flags = cfnCancelUpdate.Flags()
+	flags.Duration("timeout", defaultStackTimeout, "max time to wait for the rollback")
+	viper.BindPFlag("cancel-timeout", flags.Lookup("timeout"))
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+func TestCfnRollback(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.aws.Region(testRegion)
+	r.Stacks[0].StackStatus = aws.String(cloudformation.StackStatusUpdateRollbackFailed)
+	viper.Set("rollback-resources-to-skip", "BastionInstance, Ingress.SecurityGroup")
+
+	if err := cfnRollback.RunE(cfnRollback, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.SkippedResources) != 2 || r.SkippedResources[0] != "BastionInstance" || r.SkippedResources[1] != "Ingress.SecurityGroup" {
+		t.Errorf("unexpected skipped resources %v", r.SkippedResources)
+	}
+	assertContains(t, env.out.String(), "continuing rollback", "UPDATE_ROLLBACK_IN_PROGRESS",
+		"rollback complete: UPDATE_ROLLBACK_COMPLETE")
+}
+
+func TestCfnRollbackStatusDelay(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.aws.Region(testRegion)
+	r.Stacks[0].StackStatus = aws.String(cloudformation.StackStatusUpdateRollbackFailed)
+	r.StatusDelay = 2
+
+	if err := cfnRollback.RunE(cfnRollback, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "rollback complete: UPDATE_ROLLBACK_COMPLETE")
+}
+
+func TestCfnRollbackWrongStatus(t *testing.T) {
+	env := newTestEnv(t)
+
+	err := cfnRollback.RunE(cfnRollback, []string{testEmail})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	if calls := env.aws.Region(testRegion).Calls["ContinueUpdateRollback"]; calls != 0 {
+		t.Errorf("expected no rollback, got %d calls", calls)
+	}
+}
+
+func TestCfnCancelUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	r := env.aws.Region(testRegion)
+	r.Stacks[0].StackStatus = aws.String(cloudformation.StackStatusUpdateInProgress)
+
+	if err := cfnCancelUpdate.RunE(cfnCancelUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Calls["CancelUpdateStack"] != 1 {
+		t.Errorf("expected the update to be cancelled, got %d calls", r.Calls["CancelUpdateStack"])
+	}
+	if aws.StringValue(r.Stacks[0].StackStatus) != cloudformation.StackStatusUpdateRollbackComplete {
+		t.Errorf("unexpected stack status %s", aws.StringValue(r.Stacks[0].StackStatus))
+	}
+	assertContains(t, env.out.String(), "cancelled update", "rollback complete: UPDATE_ROLLBACK_COMPLETE")
+}
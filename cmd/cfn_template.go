@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const defaultTemplateChannel = "beta"
+
+// templateSource is where bastion stack templates come from: a local file
+// or a channel (beta, stable or any other prefix) of the regional buckets.
+type templateSource struct {
+	File    string
+	Channel string
+}
+
+func (t templateSource) String() string {
+	if t.File != "" {
+		return t.File
+	}
+	return "channel " + t.Channel
+}
+
+// updateTemplateSource returns the source picked with --template-file or
+// --channel.
+func updateTemplateSource() templateSource {
+	src := templateSource{
+		File:    viper.GetString("cfnup-template-file"),
+		Channel: viper.GetString("cfnup-channel"),
+	}
+	if src.Channel == "" {
+		src.Channel = defaultTemplateChannel
+	}
+	return src
+}
+
+// getTemplate returns the named template for region. A file source is
+// always the bastion template.
+func getTemplate(region, name string, src templateSource) ([]byte, error) {
+	if src.File != "" {
+		b, err := ioutil.ReadFile(src.File)
+		if err != nil {
+			return nil, errors.NewUserErrorF("cannot read template: %s", err)
+		}
+		return b, nil
+	}
+
+	b, _, err := fetchTemplate(cfnStack{Region: region}.getS3URL(src.Channel, name))
+	return b, err
+}
+
+// fetchTemplate downloads a template and returns it with the time it was
+// last modified, zero if unknown.
+func fetchTemplate(u string) ([]byte, time.Time, error) {
+	resp, err := httpClient.Get(u)
+	if err != nil {
+		return nil, time.Time{}, errors.Wrapf(err, "cannot get template %s", u)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	// the buckets can't be listed, so missing templates are forbidden
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden:
+		return nil, time.Time{}, errors.NewNotFoundErrorF("no template at %s (%s)", u, resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return nil, time.Time{}, errors.Newf(errors.KindUnavailable, "cannot get template %s: %s", u, resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	modified, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return b, modified, nil
+}
+
+// validateTemplate has CloudFormation check a template.
+func validateTemplate(creds *credentials.Credentials, region string, body []byte) (*cloudformation.ValidateTemplateOutput, error) {
+	out, err := awsClients.CloudFormation(creds, region).ValidateTemplate(&cloudformation.ValidateTemplateInput{
+		TemplateBody: aws.String(string(body)),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid template")
+	}
+	return out, nil
+}
+
+// getTemplateSpec returns the template named by spec: a region, optionally
+// followed by :channel, file:path or stack:customer for the template a
+// customer's stack runs.
+func getTemplateSpec(spec string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(spec, "file:"):
+		return getTemplate("", cfnTemplate, templateSource{File: strings.TrimPrefix(spec, "file:")})
+
+	case strings.HasPrefix(spec, "stack:"):
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return nil, err
+		}
+
+		u, err := util.GetUserFromArgs([]string{strings.TrimPrefix(spec, "stack:")}, 0, opseeServices)
+		if err != nil {
+			return nil, err
+		}
+
+		stackName := "opsee-stack-" + u.CustomerId
+		stack, err := findStack(u, stackName, opseeServices)
+		if err != nil {
+			return nil, err
+		}
+		if stack.Stack == nil {
+			return nil, errors.NewNotFoundErrorF("stack %s not found", stackName)
+		}
+
+		resp, err := awsClients.CloudFormation(stack.Creds, stack.Region).GetTemplate(&cloudformation.GetTemplateInput{
+			StackName: aws.String(stackName),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []byte(aws.StringValue(resp.TemplateBody)), nil
+	}
+
+	region, channel := spec, viper.GetString("template-channel")
+	if i := strings.Index(spec, ":"); i >= 0 {
+		region, channel = spec[:i], spec[i+1:]
+	}
+	if channel == "" {
+		channel = defaultTemplateChannel
+	}
+
+	name := cfnTemplate
+	if viper.GetBool("template-ingress") {
+		name = secGrpTemplate
+	}
+	return getTemplate(region, name, templateSource{Channel: channel})
+}
+
+var cfnTemplateCmd = &cobra.Command{
+	Use:   "template",
+	Short: "inspect bastion stack templates",
+	Long: `Templates are named by a region (us-west-2), a region and channel
+(us-west-2:stable), file:<path> or stack:<customer email|UUID> for the
+template a customer's stack runs.`,
+}
+
+var cfnTemplateGet = &cobra.Command{
+	Use:   "get [template]",
+	Short: "print a bastion stack template",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return errors.NewUserError("need a template")
+		}
+
+		b, err := getTemplateSpec(args[0])
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprint(stdout, string(b))
+		if !strings.HasSuffix(string(b), "\n") {
+			fmt.Fprintln(stdout)
+		}
+		return nil
+	},
+}
+
+var cfnTemplateDiff = &cobra.Command{
+	Use:   "diff [template] [template]",
+	Short: "diff two bastion stack templates",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.NewUserError("need two templates")
+		}
+
+		a, err := getTemplateSpec(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := getTemplateSpec(args[1])
+		if err != nil {
+			return err
+		}
+
+		diff := util.Diff(args[0], args[1], normalizeTemplate(string(a)), normalizeTemplate(string(b)), diffContext)
+		if diff == "" {
+			fmt.Fprintln(stdout, "templates are the same")
+			return nil
+		}
+		fmt.Fprint(stdout, diff)
+		return nil
+	},
+}
+
+func init() {
+	cfnCommand.AddCommand(cfnTemplateCmd)
+	flags := cfnTemplateCmd.PersistentFlags()
+	flags.String("channel", defaultTemplateChannel, "channel of templates named by region alone")
+	viper.BindPFlag("template-channel", flags.Lookup("channel"))
+	flags.Bool("ingress", false, "use the ingress template ("+secGrpTemplate+") of regional buckets")
+	viper.BindPFlag("template-ingress", flags.Lookup("ingress"))
+
+	cfnTemplateCmd.AddCommand(cfnTemplateGet)
+	cfnTemplateCmd.AddCommand(cfnTemplateDiff)
+}
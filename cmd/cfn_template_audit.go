@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// templateCopy is a region's copy of a template.
+type templateCopy struct {
+	Template string    `json:"template"`
+	Region   string    `json:"region"`
+	Status   string    `json:"status"`
+	SHA256   string    `json:"sha256,omitempty"`
+	Modified time.Time `json:"modified"`
+	Error    string    `json:"error,omitempty"`
+	body     []byte
+}
+
+// templateAudit is the result of cfn template audit. Copies differing from
+// the reference are stale if they're older than it, different otherwise.
+type templateAudit struct {
+	Channel string `json:"channel"`
+	// template name to the sha256 every region should serve
+	Reference map[string]string `json:"reference"`
+	Problems  int               `json:"problems"`
+	Copies    []*templateCopy   `json:"copies"`
+}
+
+// auditTemplates downloads the named templates from every region's bucket
+// and compares them to the reference region's copies or, without one, the
+// copies most regions serve.
+func auditTemplates(regions, names []string, channel, reference string) (*templateAudit, error) {
+	audit := &templateAudit{
+		Channel:   channel,
+		Reference: make(map[string]string),
+		Copies:    []*templateCopy{},
+	}
+
+	for _, name := range names {
+		results := newFanOut().Run(regions, func(region string) (interface{}, error) {
+			b, modified, err := fetchTemplate(cfnStack{Region: region}.getS3URL(channel, name))
+			if err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(b)
+			return &templateCopy{SHA256: hex.EncodeToString(sum[:]), Modified: modified, body: b}, nil
+		})
+
+		copies := []*templateCopy{}
+		for _, r := range results {
+			c := &templateCopy{Status: "error"}
+			switch {
+			case r.Err == nil:
+				c = r.Value.(*templateCopy)
+			case errors.KindOf(r.Err) == errors.KindNotFound:
+				c.Status = "missing"
+			default:
+				c.Error = strings.TrimSpace(r.Err.Error())
+				log.WARN.Printf("cannot get %s in %s: %s\n", name, r.Region, c.Error)
+			}
+			c.Template, c.Region = name, r.Region
+			copies = append(copies, c)
+		}
+
+		ref, err := referenceCopy(copies, reference)
+		if err != nil {
+			return nil, err
+		}
+		if ref != nil {
+			audit.Reference[name] = ref.SHA256
+		}
+
+		for _, c := range copies {
+			switch {
+			case c.SHA256 == "":
+			case c.SHA256 == ref.SHA256:
+				c.Status = "ok"
+			case !c.Modified.IsZero() && !ref.Modified.IsZero() && c.Modified.Before(ref.Modified):
+				c.Status = "stale"
+			default:
+				c.Status = "different"
+			}
+			if c.Status != "ok" {
+				audit.Problems++
+			}
+		}
+		audit.Copies = append(audit.Copies, copies...)
+	}
+
+	return audit, nil
+}
+
+// referenceCopy returns the reference region's copy or the copy served by
+// most regions, the most recently modified of those on a tie. It's nil if
+// no region serves the template.
+func referenceCopy(copies []*templateCopy, region string) (*templateCopy, error) {
+	if region != "" {
+		for _, c := range copies {
+			if c.Region != region {
+				continue
+			}
+			if c.SHA256 == "" {
+				return nil, errors.NewUserErrorF("reference region %s has no %s (%s)", region, c.Template, c.Status)
+			}
+			return c, nil
+		}
+		return nil, errors.NewUserErrorF("reference region %s isn't audited", region)
+	}
+
+	counts := make(map[string]int)
+	for _, c := range copies {
+		if c.SHA256 != "" {
+			counts[c.SHA256]++
+		}
+	}
+
+	var ref *templateCopy
+	for _, c := range copies {
+		if c.SHA256 == "" {
+			continue
+		}
+		if ref == nil || counts[c.SHA256] > counts[ref.SHA256] ||
+			(counts[c.SHA256] == counts[ref.SHA256] && c.Modified.After(ref.Modified)) {
+			ref = c
+		}
+	}
+	return ref, nil
+}
+
+// result renders the audit, with diffs of differing copies against the
+// reference if showDiff is set.
+func (a *templateAudit) result(showDiff bool) *output.Result {
+	res := &output.Result{
+		Data: a,
+		Columns: []output.Column{
+			{Name: "template"},
+			{Name: "region"},
+			{Name: "status"},
+			{Name: "sha256", Color: output.Yellow},
+			{Name: "modified", Color: output.Blue},
+			{Name: "error", Color: output.Red},
+		},
+	}
+
+	refs := make(map[string]*templateCopy)
+	for _, c := range a.Copies {
+		sum, modified := c.SHA256, ""
+		if len(sum) > 12 {
+			sum = sum[:12]
+		}
+		if !c.Modified.IsZero() {
+			modified = c.Modified.UTC().Format(time.RFC3339)
+		}
+		if c.SHA256 != "" && c.SHA256 == a.Reference[c.Template] && refs[c.Template] == nil {
+			refs[c.Template] = c
+		}
+		res.Rows = append(res.Rows, []string{c.Template, c.Region, c.Status, sum, modified, c.Error})
+	}
+
+	res.Text = func(out io.Writer) error {
+		fmt.Fprintf(out, "%d of %d %s templates are stale, different, missing or unavailable\n\n", a.Problems, len(a.Copies), a.Channel)
+
+		t, err := output.New(output.Table, "", "", out)
+		if err != nil {
+			return err
+		}
+		if err := t.Render(&output.Result{Columns: res.Columns, Rows: res.Rows}); err != nil {
+			return err
+		}
+
+		if !showDiff {
+			return nil
+		}
+		for _, c := range a.Copies {
+			ref := refs[c.Template]
+			if c.SHA256 == "" || ref == nil || c.SHA256 == ref.SHA256 {
+				continue
+			}
+			fmt.Fprintln(out)
+			fmt.Fprint(out, util.Diff(ref.Region+"/"+c.Template, c.Region+"/"+c.Template,
+				normalizeTemplate(string(ref.body)), normalizeTemplate(string(c.body)), diffContext))
+		}
+		return nil
+	}
+
+	return res
+}
+
+var cfnTemplateAudit = &cobra.Command{
+	Use:   "audit",
+	Short: "compare the templates served by every region's bucket",
+	Long: `Downloads ` + cfnTemplate + ` and ` + secGrpTemplate + ` of --channel from
+every region's bucket and compares their sha256 to the copy most regions
+serve, or to --reference's. Copies that differ are stale if they were
+modified before the reference, different otherwise. Regions without a copy
+are missing.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		regions, err := svc.LoadRegions().Select(viper.GetString("region-filter"))
+		if err != nil {
+			return err
+		}
+
+		channel := viper.GetString("template-channel")
+		if channel == "" {
+			channel = defaultTemplateChannel
+		}
+
+		audit, err := auditTemplates(regions, []string{cfnTemplate, secGrpTemplate}, channel, viper.GetString("template-audit-reference"))
+		if err != nil {
+			return err
+		}
+
+		if err := render(audit.result(viper.GetBool("template-audit-diff"))); err != nil {
+			return err
+		}
+
+		if audit.Problems > 0 {
+			return errors.NewSystemErrorF("%d of %d %s templates have problems", audit.Problems, len(audit.Copies), audit.Channel)
+		}
+		return nil
+	},
+}
+
+func init() {
+	cfnTemplateCmd.AddCommand(cfnTemplateAudit)
+	flags := cfnTemplateAudit.Flags()
+	flags.String("reference", "", "region whose templates the others should match")
+	viper.BindPFlag("template-audit-reference", flags.Lookup("reference"))
+	flags.Bool("diff", false, "show how templates differ from the reference")
+	viper.BindPFlag("template-audit-diff", flags.Lookup("diff"))
+}
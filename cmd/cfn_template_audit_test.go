@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	testAuditRegions = "us-west-2,us-east-1,eu-west-1"
+	testOldTemplate  = `{"AWSTemplateFormatVersion": "2010-09-09", "Description": "old"}`
+)
+
+// auditTemplateEnv serves testTemplate from us-west-2 and us-east-1 and an
+// older template from eu-west-1, which has no ingress template.
+func auditTemplateEnv(t *testing.T) *testEnv {
+	env := newTestEnv(t)
+	viper.Set("region-filter", testAuditRegions)
+
+	now := time.Now()
+	env.templates = map[string]string{}
+	env.modified = map[string]time.Time{}
+	for _, region := range []string{"us-west-2", "us-east-1", "eu-west-1"} {
+		for _, name := range []string{cfnTemplate, secGrpTemplate} {
+			u := cfnStack{Region: region}.getS3URL(defaultTemplateChannel, name)
+			switch {
+			case region != "eu-west-1":
+				env.templates[u] = testTemplate
+				env.modified[u] = now
+			case name == cfnTemplate:
+				env.templates[u] = testOldTemplate
+				env.modified[u] = now.Add(-24 * time.Hour)
+			}
+		}
+	}
+	return env
+}
+
+func TestCfnTemplateAudit(t *testing.T) {
+	env := auditTemplateEnv(t)
+	viper.Set("template-audit-diff", true)
+
+	err := cfnTemplateAudit.RunE(cfnTemplateAudit, []string{})
+	if err == nil || errors.KindOf(err) != errors.KindSystem || err.Error() != "2 of 6 beta templates have problems" {
+		t.Fatalf("expected a problems error, got %v", err)
+	}
+
+	if len(env.fetched) != 6 {
+		t.Errorf("expected 2 templates from 3 regions, got %v", env.fetched)
+	}
+	assertContains(t, env.out.String(), "2 of 6 beta templates are stale", "stale", "missing",
+		"--- us-west-2/"+cfnTemplate+"\n+++ eu-west-1/"+cfnTemplate+"\n", `+  "Description": "old"`)
+}
+
+func TestCfnTemplateAuditConsistent(t *testing.T) {
+	env := auditTemplateEnv(t)
+	viper.Set("region-filter", "us-west-2,us-east-1")
+
+	if err := cfnTemplateAudit.RunE(cfnTemplateAudit, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "0 of 4 beta templates")
+}
+
+func TestCfnTemplateAuditReference(t *testing.T) {
+	env := auditTemplateEnv(t)
+	env.templates[cfnStack{Region: "eu-west-1"}.getS3URL(defaultTemplateChannel, secGrpTemplate)] = testTemplate
+	viper.Set("template-audit-reference", "eu-west-1")
+	viper.Set("output", "json")
+
+	if err := cfnTemplateAudit.RunE(cfnTemplateAudit, []string{}); err == nil {
+		t.Fatal("expected a problems error")
+	}
+
+	audit := &templateAudit{}
+	if err := json.Unmarshal(env.out.Bytes(), audit); err != nil {
+		t.Fatalf("invalid json output: %s\n%s", err, env.out.String())
+	}
+	if audit.Problems != 2 || len(audit.Copies) != 6 {
+		t.Fatalf("expected 2 problems in 6 copies, got %d in %d", audit.Problems, len(audit.Copies))
+	}
+	for _, c := range audit.Copies {
+		expected := "ok"
+		if c.Template == cfnTemplate && c.Region != "eu-west-1" {
+			expected = "different"
+		}
+		if c.Status != expected {
+			t.Errorf("expected %s in %s to be %s, got %s", c.Template, c.Region, expected, c.Status)
+		}
+	}
+}
+
+func TestCfnTemplateAuditMissingReference(t *testing.T) {
+	auditTemplateEnv(t)
+	viper.Set("template-audit-reference", "eu-west-1")
+
+	err := cfnTemplateAudit.RunE(cfnTemplateAudit, []string{})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	assertContains(t, err.Error(), "eu-west-1 has no "+secGrpTemplate+" (missing)")
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	testTemplateURL       = "https://s3-us-west-2.amazonaws.com/opsee-bastion-cf-us-west-2/beta/bastion-cf.template"
+	testStableTemplateURL = "https://s3-us-west-2.amazonaws.com/opsee-bastion-cf-us-west-2/stable/bastion-cf.template"
+)
+
+func writeTemplate(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "bastion-cf.template")
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCfnUpdateChannel(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-channel", "stable")
+	env.templates = map[string]string{testStableTemplateURL: `{"Description": "stable"}`}
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 || aws.StringValue(updates[0].TemplateBody) != `{"Description": "stable"}` {
+		t.Fatalf("expected an update with the stable template, got %v", updates)
+	}
+}
+
+func TestCfnUpdateMissingTemplate(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-channel", "dev/nobody")
+	env.templates = map[string]string{}
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if errors.KindOf(err) != errors.KindNotFound {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	assertContains(t, err.Error(), "opsee-bastion-cf-us-west-2/dev/nobody/bastion-cf.template", "404")
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected no stack updates")
+	}
+}
+
+func TestCfnUpdateTemplateFile(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-template-file", writeTemplate(t, `{"Description": "local"}`))
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.fetched) != 0 {
+		t.Errorf("expected no template downloads, got %v", env.fetched)
+	}
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 || aws.StringValue(updates[0].TemplateBody) != `{"Description": "local"}` {
+		t.Fatalf("expected an update with the local template, got %v", updates)
+	}
+}
+
+func TestCfnUpdateInvalidTemplate(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-template-file", writeTemplate(t, "Resources: {"))
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if err == nil {
+		t.Fatal("expected an invalid template error")
+	}
+	assertContains(t, err.Error(), "invalid template")
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected no stack updates")
+	}
+}
+
+func TestCfnTemplateGet(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := cfnTemplateGet.RunE(cfnTemplateGet, []string{"us-west-2:stable"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.fetched) != 1 || env.fetched[0] != testStableTemplateURL {
+		t.Errorf("unexpected template urls: %v", env.fetched)
+	}
+	assertContains(t, env.out.String(), testTemplate+"\n")
+
+	env = newTestEnv(t)
+	viper.Set("template-ingress", true)
+	if err := cfnTemplateGet.RunE(cfnTemplateGet, []string{"us-east-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.fetched) != 1 || env.fetched[0] != "https://s3.amazonaws.com/opsee-bastion-cf-us-east-1/beta/bastion-ingress-cf.template" {
+		t.Errorf("unexpected template urls: %v", env.fetched)
+	}
+}
+
+func TestCfnTemplateDiff(t *testing.T) {
+	env := newTestEnv(t)
+	env.templates = map[string]string{
+		testTemplateURL:       `{"AWSTemplateFormatVersion": "2010-09-09", "Description": "beta"}`,
+		testStableTemplateURL: `{"AWSTemplateFormatVersion": "2010-09-09", "Description": "stable"}`,
+	}
+
+	if err := cfnTemplateDiff.RunE(cfnTemplateDiff, []string{"us-west-2:stable", "us-west-2"}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "--- us-west-2:stable\n+++ us-west-2\n", `-  "Description": "stable"`, `+  "Description": "beta"`)
+
+	// the stack runs testTemplate, the same json formatted differently
+	env = newTestEnv(t)
+	path := writeTemplate(t, strings.Replace(testTemplate, "\n", "", -1))
+	if err := cfnTemplateDiff.RunE(cfnTemplateDiff, []string{"stack:" + testEmail, "file:" + path}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "templates are the same")
+}
@@ -0,0 +1,417 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/svc"
+	"github.com/spf13/viper"
+)
+
+func TestCfnUpdate(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-ami-id", "ami-new")
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(updates))
+	}
+
+	in := updates[0]
+	if aws.StringValue(in.StackName) != testStackName {
+		t.Errorf("expected update of %s, got %s", testStackName, aws.StringValue(in.StackName))
+	}
+	if aws.StringValue(in.TemplateBody) != testTemplate {
+		t.Errorf("unexpected template body: %s", aws.StringValue(in.TemplateBody))
+	}
+	if p := stackParam(in.Parameters, "ImageId"); aws.StringValue(p.ParameterValue) != "ami-new" {
+		t.Errorf("expected ImageId ami-new, got %s", aws.StringValue(p.ParameterValue))
+	}
+	if p := stackParam(in.Parameters, "AllowSSH"); aws.StringValue(p.ParameterValue) != "False" {
+		t.Errorf("expected AllowSSH False, got %s", aws.StringValue(p.ParameterValue))
+	}
+	if p := stackParam(in.Parameters, "UserData"); !aws.BoolValue(p.UsePreviousValue) {
+		t.Error("expected previous UserData to be used")
+	}
+	if len(env.fetched) != 1 || env.fetched[0] != "https://s3-us-west-2.amazonaws.com/opsee-bastion-cf-us-west-2/beta/bastion-cf.template" {
+		t.Errorf("unexpected template urls: %v", env.fetched)
+	}
+	assertContains(t, env.out.String(), "requested stack update")
+}
+
+func TestCfnUpdateAllRegions(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-ami-id", "ami-new")
+	// the customer's bastion, and so the stack hint, is in testRegion
+	other := *env.aws.Region(testRegion).Stacks[0]
+	other.StackId = aws.String("arn:aws:cloudformation:us-east-1:123456789012:stack/" + testStackName + "/2")
+	env.aws.Region("us-east-1").Stacks = []*cloudformation.Stack{&other}
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, region := range []string{testRegion, "us-east-1"} {
+		if n := len(env.aws.Region(region).StackUpdates); n != 1 {
+			t.Errorf("expected 1 stack update in %s, got %d", region, n)
+		}
+	}
+}
+
+func TestStackRegionHintsNoCache(t *testing.T) {
+	env := newTestEnv(t)
+	regions := []string{testRegion, "us-east-1"}
+
+	if hints := stackRegionHints(env.services.Users[0], testStackName, regions, env.services); len(hints) != 1 || hints[0] != testRegion {
+		t.Errorf("expected the bastion's region as hint, got %v", hints)
+	}
+	viper.Set("no-cache", true)
+	if hints := stackRegionHints(env.services.Users[0], testStackName, regions, env.services); len(hints) != 0 {
+		t.Errorf("expected no hints with --no-cache, got %v", hints)
+	}
+}
+
+func TestCfnUpdateLatest(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("latest", true)
+	env.aws.Region(testRegion).Images = []*ec2.Image{
+		{
+			ImageId:      aws.String("ami-older"),
+			CreationDate: aws.String("2016-03-01T00:00:00.000Z"),
+			Tags:         []*ec2.Tag{{Key: aws.String("opsee"), Value: aws.String("bastion")}, {Key: aws.String("release"), Value: aws.String("stable")}},
+		},
+		{
+			ImageId:      aws.String("ami-newest"),
+			CreationDate: aws.String("2016-04-01T00:00:00.000Z"),
+			Tags:         []*ec2.Tag{{Key: aws.String("opsee"), Value: aws.String("bastion")}, {Key: aws.String("release"), Value: aws.String("stable")}},
+		},
+		{
+			ImageId:      aws.String("ami-beta"),
+			CreationDate: aws.String("2016-05-01T00:00:00.000Z"),
+			Tags:         []*ec2.Tag{{Key: aws.String("opsee"), Value: aws.String("bastion")}, {Key: aws.String("release"), Value: aws.String("beta")}},
+		},
+	}
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(updates))
+	}
+	if p := stackParam(updates[0].Parameters, "ImageId"); aws.StringValue(p.ParameterValue) != "ami-newest" {
+		t.Errorf("expected ImageId ami-newest, got %s", aws.StringValue(p.ParameterValue))
+	}
+
+	viper.Set("cfnup-image-channel", "beta")
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	updates = env.aws.Region(testRegion).StackUpdates
+	if p := stackParam(updates[len(updates)-1].Parameters, "ImageId"); aws.StringValue(p.ParameterValue) != "ami-beta" {
+		t.Errorf("expected ImageId ami-beta, got %s", aws.StringValue(p.ParameterValue))
+	}
+}
+
+func TestCfnUpdateLatestNoImages(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("latest", true)
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err == nil {
+		t.Fatal("expected error when no images are found")
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Fatal("expected no stack updates")
+	}
+}
+
+func TestCfnUpdateUserdata(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("userdata", true)
+	viper.Set("cfnup-allow-ssh", true)
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	in := env.aws.Region(testRegion).StackUpdates[0]
+	if p := stackParam(in.Parameters, "UserData"); aws.StringValue(p.ParameterValue) != "I2Nsb3VkLWNvbmZpZwp3cml0ZV9maWxlczoK" {
+		t.Errorf("expected cleaned userdata, got %s", aws.StringValue(p.ParameterValue))
+	}
+	if p := stackParam(in.Parameters, "AllowSSH"); aws.StringValue(p.ParameterValue) != "True" {
+		t.Errorf("expected AllowSSH True, got %s", aws.StringValue(p.ParameterValue))
+	}
+}
+
+func TestCfnUpdateWait(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-wait", true)
+	env.aws.Region(testRegion).Stacks[0].StackStatusReason = nil
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := env.out.String()
+	assertContains(t, out, "UPDATE_IN_PROGRESS "+testStackName+" User Initiated")
+	assertContains(t, out, "update complete: UPDATE_COMPLETE\n")
+	// events from before the update
+	assertNotContains(t, out, "Resource creation Initiated")
+}
+
+func TestCfnUpdateWaitRollback(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-wait", true)
+	r := env.aws.Region(testRegion)
+	r.UpdateStatus = cloudformation.StackStatusUpdateRollbackComplete
+	r.UpdateFailure = "Instance failed to stabilize"
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if errors.KindOf(err) != errors.KindAWS {
+		t.Fatalf("expected an aws error, got %v", err)
+	}
+	assertContains(t, err.Error(), "BastionInstance UPDATE_FAILED: Instance failed to stabilize")
+	assertContains(t, env.out.String(), "UPDATE_ROLLBACK_IN_PROGRESS")
+	assertNotContains(t, env.out.String(), "update complete")
+}
+
+func TestCfnUpdateWaitTimeout(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-wait", true)
+	viper.Set("cfnup-timeout", time.Millisecond)
+	env.aws.Region(testRegion).UpdateStatus = cloudformation.StackStatusUpdateInProgress
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if errors.KindOf(err) != errors.KindTimeout {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestStackFailed(t *testing.T) {
+	for _, test := range []struct {
+		status, done string
+		failed       bool
+	}{
+		{"UPDATE_IN_PROGRESS", "UPDATE_COMPLETE", false},
+		{"UPDATE_COMPLETE_CLEANUP_IN_PROGRESS", "UPDATE_COMPLETE", false},
+		{"UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_COMPLETE", true},
+		{"UPDATE_ROLLBACK_COMPLETE", "UPDATE_COMPLETE", true},
+		{"UPDATE_ROLLBACK_IN_PROGRESS", "UPDATE_ROLLBACK_COMPLETE", false},
+		{"UPDATE_ROLLBACK_FAILED", "UPDATE_ROLLBACK_COMPLETE", true},
+		{"DELETE_FAILED", "DELETE_COMPLETE", true},
+	} {
+		if failed := stackFailed(test.status, test.done); failed != test.failed {
+			t.Errorf("stackFailed(%s, %s) = %t", test.status, test.done, failed)
+		}
+	}
+}
+
+func TestCfnUpdatePlan(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-ami-id", "ami-new")
+	viper.Set("cfnup-plan", true)
+	stdin = strings.NewReader("y\n")
+	r := env.aws.Region(testRegion)
+	r.Templates[testStackName] = `{"AWSTemplateFormatVersion": "2010-09-09", "Description": "old"}`
+	r.Changes = []*svc.Change{{
+		Type: aws.String("Resource"),
+		ResourceChange: &svc.ResourceChange{
+			Action:            aws.String("Modify"),
+			LogicalResourceId: aws.String("BastionInstance"),
+			ResourceType:      aws.String("AWS::EC2::Instance"),
+			Replacement:       aws.String("True"),
+			Scope:             []*string{aws.String("Properties")},
+		},
+	}}
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.Executed) != 1 {
+		t.Fatalf("expected 1 executed change set, got %v", r.Executed)
+	}
+	if len(r.StackUpdates) != 0 {
+		t.Error("expected the change set to update the stack, not UpdateStack")
+	}
+	if p := stackParam(r.Stacks[0].Parameters, "ImageId"); aws.StringValue(p.ParameterValue) != "ami-new" {
+		t.Errorf("expected ImageId ami-new, got %s", aws.StringValue(p.ParameterValue))
+	}
+
+	out := env.out.String()
+	assertContains(t, out, "ImageId")
+	assertContains(t, out, "ami-old")
+	assertContains(t, out, "ami-new")
+	assertContains(t, out, `-  "Description": "old"`)
+	assertContains(t, out, "BastionInstance")
+	assertContains(t, out, "execute change set boop-")
+	assertContains(t, out, "executing change set boop-")
+}
+
+func TestCfnUpdatePlanWaitStatusDelay(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-ami-id", "ami-new")
+	viper.Set("cfnup-plan", true)
+	viper.Set("cfnup-wait", true)
+	stdin = strings.NewReader("y\n")
+	r := env.aws.Region(testRegion)
+	r.UpdateStatus = cloudformation.StackStatusUpdateRollbackComplete
+	// the stack's status from before the update isn't the update's result
+	r.StatusDelay = 2
+
+	err := cfnUpdate.RunE(cfnUpdate, []string{testEmail})
+	if errors.KindOf(err) != errors.KindAWS {
+		t.Fatalf("expected an aws error, got %v", err)
+	}
+	assertContains(t, err.Error(), "UPDATE_ROLLBACK_COMPLETE")
+	assertNotContains(t, env.out.String(), "update complete")
+}
+
+func TestCfnUpdatePlanDiscard(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-ami-id", "ami-new")
+	viper.Set("cfnup-plan", true)
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := env.aws.Region(testRegion)
+	if len(r.Executed) != 0 || len(r.ChangeSets) != 0 || len(r.StackUpdates) != 0 {
+		t.Fatalf("expected the change set to be discarded, executed %v, change sets %v", r.Executed, r.ChangeSets)
+	}
+	assertContains(t, env.out.String(), "template: no changes")
+	assertContains(t, env.out.String(), "no resource changes")
+	assertContains(t, env.out.String(), "discarded change set boop-")
+}
+
+func TestCfnUpdatePlanExecute(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-ami-id", "ami-new")
+	viper.Set("cfnup-plan", true)
+	viper.Set("cfnup-execute", true)
+
+	if err := cfnUpdate.RunE(cfnUpdate, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(env.aws.Region(testRegion).Executed) != 1 {
+		t.Fatal("expected the change set to be executed without asking")
+	}
+	assertNotContains(t, env.out.String(), "[y/N]")
+}
+
+func TestCfnUpdatePlanFleet(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("cfnup-plan", true)
+	viper.Set("cfnup-all-active", true)
+
+	if err := cfnUpdate.RunE(cfnUpdate, nil); err == nil {
+		t.Fatal("expected --plan to be refused for many customers")
+	}
+	if calls := env.aws.Region(testRegion).Calls["CreateChangeSet"]; calls != 0 {
+		t.Errorf("expected no change sets, got %d", calls)
+	}
+}
+
+func TestCfnPrint(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := cfnPrint.RunE(cfnPrint, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(),
+		"requesting stack deletion for "+testStackName,
+		"name: "+testStackName,
+		"region: "+testRegion,
+		"ImageId: ami-old",
+		"opsee:customer-id: "+testCustomerID)
+}
+
+func TestCfnPrintNoStack(t *testing.T) {
+	env := newTestEnv(t)
+	env.aws.Region(testRegion).Stacks = nil
+
+	if err := cfnPrint.RunE(cfnPrint, []string{testEmail}); err == nil {
+		t.Fatal("expected error for missing stack")
+	}
+}
+
+func TestCfnEvents(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("list-events-num", 10)
+
+	if err := cfnEvents.RunE(cfnEvents, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(), "resource", "BastionInstance", "Resource creation Initiated", cloudformation.ResourceStatusCreateComplete)
+}
+
+func TestCfnUserdata(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := cfnUserdata.RunE(cfnUserdata, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.out.String() != testUserdata+"\n" {
+		t.Errorf("unexpected userdata: %q", env.out.String())
+	}
+}
+
+func TestCfnPrintYAML(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("output", "yaml")
+
+	if err := cfnPrint.RunE(cfnPrint, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(),
+		"Region: "+testRegion,
+		"StackName: "+testStackName,
+		"ParameterKey: ImageId")
+}
+
+func TestFindStackCache(t *testing.T) {
+	env := newTestEnv(t)
+	env.services.BastionStates[0].Region = ""
+	describeStacksCalls := func() int {
+		calls := 0
+		for _, region := range svc.DefaultRegions {
+			calls += env.aws.Region(region).Calls["DescribeStacks"]
+		}
+		return calls
+	}
+
+	stack, err := findStack(env.services.Users[0], testStackName, env.services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stack.Region != testRegion {
+		t.Fatalf("expected %s, got %s", testRegion, stack.Region)
+	}
+	if n := describeStacksCalls(); n < 2 {
+		t.Fatalf("expected a scan, got %d calls", n)
+	}
+
+	resetCalls(env)
+	if _, err := findStack(env.services.Users[0], testStackName, env.services); err != nil {
+		t.Fatal(err)
+	}
+	if n := describeStacksCalls(); n != 1 {
+		t.Errorf("expected cached lookup with a single call, got %d", n)
+	}
+}
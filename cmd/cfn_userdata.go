@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+// userdataStack returns the stack of the customer in args[i].
+func userdataStack(args []string, i int) (*cfnStack, error) {
+	opseeServices, err := newOpseeServices()
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := util.GetUserFromArgs(args, i, opseeServices)
+	if err != nil {
+		return nil, err
+	}
+
+	stackName := "opsee-stack-" + u.CustomerId
+	stack, err := findStack(u, stackName, opseeServices)
+	if err != nil {
+		return nil, err
+	}
+	if stack.Stack == nil {
+		return nil, errors.NewNotFoundErrorF("stack %s not found", stackName)
+	}
+	return stack, nil
+}
+
+// validateUserdata checks userdata is a cloud-config YAML document.
+func validateUserdata(userdata string) error {
+	if !strings.HasPrefix(userdata, "#cloud-config\n") {
+		return errors.NewUserError("invalid userdata: it doesn't start with #cloud-config")
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(userdata), &v); err != nil {
+		return errors.NewUserErrorF("invalid userdata: %s", err)
+	}
+	return nil
+}
+
+// editUserdata opens $EDITOR on userdata and returns the edited userdata
+// and the file it was edited in.
+func editUserdata(userdata string) (string, string, error) {
+	f, err := ioutil.TempFile("", "boop-userdata-*.yaml")
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(userdata); err != nil {
+		return "", f.Name(), err
+	}
+	if err := f.Close(); err != nil {
+		return "", f.Name(), err
+	}
+
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = "vi"
+	}
+	// through the shell, editors are often set with their flags
+	cmd := exec.Command("sh", "-c", editor+` "$1"`, "sh", f.Name())
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", f.Name(), errors.Wrapf(err, "editor %s failed", editor)
+	}
+
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return "", f.Name(), err
+	}
+	return string(b), f.Name(), nil
+}
+
+// applyUserdata shows how userdata changes the stack's and updates the
+// stack with it, asking first if ask is set. Everything but the userdata
+// keeps its previous value. The command's flags are read from prefix keys.
+func applyUserdata(stack *cfnStack, userdata, prefix string, ask bool, out io.Writer) error {
+	stackName := aws.StringValue(stack.Stack.StackName)
+	current, err := stack.getRawUserdata()
+	if err != nil {
+		return err
+	}
+
+	diff := util.Diff(stackName+" (current)", stackName+" (new)", current, userdata, diffContext)
+	if diff == "" {
+		fmt.Fprintln(out, "userdata unchanged")
+		return nil
+	}
+	fmt.Fprint(out, diff)
+
+	if viper.GetBool(prefix + "-dry-run") {
+		fmt.Fprintln(out, "(but not really bc dry-run)")
+		return nil
+	}
+
+	if ask {
+		ok, err := confirm(out, "update stack "+stackName+"?")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(out, "not updating")
+			return nil
+		}
+	}
+
+	params := []*cloudformation.Parameter{}
+	for _, p := range stack.Stack.Parameters {
+		if aws.StringValue(p.ParameterKey) == "UserData" {
+			continue
+		}
+		params = append(params, &cloudformation.Parameter{
+			ParameterKey:     p.ParameterKey,
+			UsePreviousValue: aws.Bool(true),
+		})
+	}
+	params = append(params, &cloudformation.Parameter{
+		ParameterKey:   aws.String("UserData"),
+		ParameterValue: aws.String(base64.StdEncoding.EncodeToString([]byte(userdata))),
+	})
+
+	cfnClient := awsClients.CloudFormation(stack.Creds, stack.Region)
+	wait := viper.GetBool(prefix + "-wait")
+
+	var events *stackEvents
+	if wait {
+		events, err = newStackEvents(cfnClient, stackName)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = cfnClient.UpdateStack(&cloudformation.UpdateStackInput{
+		StackName:           aws.String(stackName),
+		UsePreviousTemplate: aws.Bool(true),
+		Capabilities: []*string{
+			aws.String("CAPABILITY_IAM"),
+		},
+		Parameters: params,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "requested stack update\n")
+	if wait {
+		return waitForStackUpdate(events, viper.GetDuration(prefix+"-timeout"), out)
+	}
+	return nil
+}
+
+var cfnUserdata = &cobra.Command{
+	Use:   "userdata [customer email|customer UUID]",
+	Short: "show a cloudformation stack's userdata",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		stack, err := userdataStack(args, 0)
+		if err != nil {
+			return err
+		}
+
+		ud, err := stack.getUserdata()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(stdout, ud)
+		return nil
+	},
+}
+
+var cfnUserdataEdit = &cobra.Command{
+	Use:   "edit [customer email|customer UUID]",
+	Short: "edit a cloudformation stack's userdata",
+	Long: `Opens $EDITOR on the stack's decoded userdata. Once saved, the userdata is
+checked to be cloud-config YAML and the changes are shown before asking to
+update the stack. Invalid userdata is left in its file to be fixed and
+applied with userdata set --file.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		stack, err := userdataStack(args, 0)
+		if err != nil {
+			return err
+		}
+
+		// edits start without the userdata getUserdata strips, the diff
+		// shows it going
+		current, err := stack.getUserdata()
+		if err != nil {
+			return err
+		}
+
+		edited, path, err := editUserdata(current)
+		if err != nil {
+			return err
+		}
+		if err := validateUserdata(edited); err != nil {
+			return errors.NewUserErrorF("%s, your changes are in %s", err, path)
+		}
+		os.Remove(path)
+
+		return applyUserdata(stack, edited, "userdata-edit", true, stdout)
+	},
+}
+
+var cfnUserdataSet = &cobra.Command{
+	Use:   "set [customer email|customer UUID]",
+	Short: "set a cloudformation stack's userdata from a file",
+	Long: `Updates the stack's userdata to the cloud-config YAML in --file, - for
+stdin, without asking. The changes are shown first.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		file := viper.GetString("userdata-set-file")
+		var b []byte
+		var err error
+		switch file {
+		case "":
+			return errors.NewUserError("need a --file")
+		case "-":
+			b, err = ioutil.ReadAll(stdin)
+		default:
+			b, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return errors.NewUserErrorF("cannot read userdata: %s", err)
+		}
+
+		userdata := string(b)
+		if err := validateUserdata(userdata); err != nil {
+			return err
+		}
+
+		stack, err := userdataStack(args, 0)
+		if err != nil {
+			return err
+		}
+
+		return applyUserdata(stack, userdata, "userdata-set", false, stdout)
+	},
+}
+
+var cfnUserdataDiff = &cobra.Command{
+	Use:   "diff [customer email|customer UUID] [customer email|customer UUID]",
+	Short: "diff two customers' cloudformation stack userdata",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return errors.NewUserError("need two customers")
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		userdata := make([]string, 2)
+		for i := range args {
+			stack, err := userdataStack(args, i)
+			if err != nil {
+				return err
+			}
+			userdata[i], err = stack.getRawUserdata()
+			if err != nil {
+				return err
+			}
+		}
+
+		diff := util.Diff(args[0], args[1], userdata[0], userdata[1], diffContext)
+		if diff == "" {
+			fmt.Fprintln(stdout, "userdata is the same")
+			return nil
+		}
+		fmt.Fprint(stdout, diff)
+		return nil
+	},
+}
+
+func init() {
+	cfnCommand.AddCommand(cfnUserdata)
+	cfnUserdata.AddCommand(cfnUserdataEdit)
+	cfnUserdata.AddCommand(cfnUserdataSet)
+	cfnUserdata.AddCommand(cfnUserdataDiff)
+
+	for prefix, cmd := range map[string]*cobra.Command{"userdata-edit": cfnUserdataEdit, "userdata-set": cfnUserdataSet} {
+		flags := cmd.Flags()
+		flags.BoolP("wait", "w", false, "wait for update to complete, printing stack events")
+		viper.BindPFlag(prefix+"-wait", flags.Lookup("wait"))
+		flags.Duration("timeout", defaultStackTimeout, "with --wait, max time to wait for the update")
+		viper.BindPFlag(prefix+"-timeout", flags.Lookup("timeout"))
+	}
+
+	flags := cfnUserdataSet.Flags()
+	flags.StringP("file", "f", "", "file with the new userdata, - for stdin")
+	viper.BindPFlag("userdata-set-file", flags.Lookup("file"))
+	flags.BoolP("dry-run", "n", false, "dry run")
+	viper.BindPFlag("userdata-set-dry-run", flags.Lookup("dry-run"))
+}
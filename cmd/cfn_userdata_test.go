@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+const testNewUserdata = "#cloud-config\nwrite_files: []\n"
+
+func TestCfnUserdataEdit(t *testing.T) {
+	env := newTestEnv(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("EDITOR", `sed -i.bak "s/write_files:/write_files: []/"`)
+	stdin = strings.NewReader("y\n")
+
+	if err := cfnUserdataEdit.RunE(cfnUserdataEdit, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(updates))
+	}
+	in := updates[0]
+	if !aws.BoolValue(in.UsePreviousTemplate) || in.TemplateBody != nil {
+		t.Error("expected the previous template to be used")
+	}
+	for _, p := range in.Parameters {
+		key := aws.StringValue(p.ParameterKey)
+		if key == "UserData" {
+			if aws.StringValue(p.ParameterValue) != base64.StdEncoding.EncodeToString([]byte(testNewUserdata)) {
+				t.Errorf("unexpected userdata %s", aws.StringValue(p.ParameterValue))
+			}
+		} else if !aws.BoolValue(p.UsePreviousValue) {
+			t.Errorf("expected %s to use its previous value", key)
+		}
+	}
+	if len(in.Parameters) != 4 {
+		t.Errorf("expected all 4 stack parameters, got %v", in.Parameters)
+	}
+	assertContains(t, env.out.String(), "-write_files:\n", "+write_files: []\n", "-        - name: \"10-cgroupfs.conf\"\n",
+		"update stack "+testStackName+"?", "requested stack update")
+}
+
+func TestCfnUserdataEditInvalid(t *testing.T) {
+	env := newTestEnv(t)
+	t.Setenv("TMPDIR", t.TempDir())
+	t.Setenv("EDITOR", `sed -i.bak "s/write_files:/write_files: [/"`)
+
+	err := cfnUserdataEdit.RunE(cfnUserdataEdit, []string{testEmail})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	assertContains(t, err.Error(), "invalid userdata", "your changes are in")
+
+	path := err.Error()[strings.LastIndex(err.Error(), " ")+1:]
+	b, rerr := ioutil.ReadFile(path)
+	if rerr != nil {
+		t.Fatalf("expected the edit to be kept: %s", rerr)
+	}
+	if string(b) != "#cloud-config\nwrite_files: [\n" {
+		t.Errorf("unexpected kept edit %q", b)
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected no stack updates")
+	}
+}
+
+func TestCfnUserdataSet(t *testing.T) {
+	env := newTestEnv(t)
+	stdin = strings.NewReader(testNewUserdata)
+	viper.Set("userdata-set-file", "-")
+	viper.Set("userdata-set-wait", true)
+
+	if err := cfnUserdataSet.RunE(cfnUserdataSet, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := env.aws.Region(testRegion)
+	if len(r.StackUpdates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(r.StackUpdates))
+	}
+	if p := stackParam(r.Stacks[0].Parameters, "ImageId"); aws.StringValue(p.ParameterValue) != "ami-old" {
+		t.Errorf("expected the image to be kept, got %v", p)
+	}
+	assertContains(t, env.out.String(), "+write_files: []\n", "update complete: "+cloudformation.StackStatusUpdateComplete)
+	assertNotContains(t, env.out.String(), "?")
+
+	// the same userdata again
+	env.out.Reset()
+	stdin = strings.NewReader(testNewUserdata)
+	if err := cfnUserdataSet.RunE(cfnUserdataSet, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.StackUpdates) != 1 {
+		t.Errorf("expected no more updates, got %d", len(r.StackUpdates))
+	}
+	assertContains(t, env.out.String(), "userdata unchanged")
+}
+
+func TestCfnUserdataSetInvalid(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("userdata-set-file", writeTemplate(t, "write_files: []\n"))
+
+	err := cfnUserdataSet.RunE(cfnUserdataSet, []string{testEmail})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected a user error, got %v", err)
+	}
+	assertContains(t, err.Error(), "#cloud-config")
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected no stack updates")
+	}
+}
+
+func TestCfnUserdataDiff(t *testing.T) {
+	env := newTestEnv(t)
+	env.aws.Region("us-east-1").Stacks = []*cloudformation.Stack{
+		{
+			StackId:     aws.String("arn:aws:cloudformation:us-east-1:123456789012:stack/opsee-stack-8b5e3b8e-6ba2-11e5-8603-6ba085b2f5b5/1"),
+			StackName:   aws.String("opsee-stack-8b5e3b8e-6ba2-11e5-8603-6ba085b2f5b5"),
+			StackStatus: aws.String(cloudformation.StackStatusCreateComplete),
+			Parameters: []*cloudformation.Parameter{
+				{ParameterKey: aws.String("UserData"), ParameterValue: aws.String(base64.StdEncoding.EncodeToString([]byte(testNewUserdata)))},
+			},
+		},
+	}
+
+	if err := cfnUserdataDiff.RunE(cfnUserdataDiff, []string{testEmail, "lana@isis.com"}); err != nil {
+		t.Fatal(err)
+	}
+	assertContains(t, env.out.String(), "--- "+testEmail+"\n+++ lana@isis.com\n", "-write_files:\n", "+write_files: []\n",
+		"-        - name: \"10-cgroupfs.conf\"\n")
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+)
+
+const defaultStackTimeout = 30 * time.Minute
+
+// how often a stack's status and events are polled, shortened in tests
+var stackPollInterval = 5 * time.Second
+
+// stackEvents tails a stack's events.
+type stackEvents struct {
+	cfnClient svc.CloudFormation
+	stackName string
+	// id of the newest event seen
+	last string
+	// the stack's status before the operation
+	status string
+}
+
+// newStackEvents starts tailing a stack's events after its newest one, so
+// create it before starting an operation to get only that operation's events.
+func newStackEvents(cfnClient svc.CloudFormation, stackName string) (*stackEvents, error) {
+	stacks, err := cfnClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(stacks.Stacks) != 1 {
+		return nil, errors.NewNotFoundErrorF("stack %s not found", stackName)
+	}
+
+	resp, err := cfnClient.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(stackName),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s := &stackEvents{
+		cfnClient: cfnClient,
+		stackName: stackName,
+		status:    aws.StringValue(stacks.Stacks[0].StackStatus),
+	}
+	if len(resp.StackEvents) > 0 {
+		s.last = aws.StringValue(resp.StackEvents[0].EventId)
+	}
+	return s, nil
+}
+
+// next returns the events since the last call, oldest first.
+func (s *stackEvents) next() ([]*cloudformation.StackEvent, error) {
+	in := &cloudformation.DescribeStackEventsInput{
+		StackName: aws.String(s.stackName),
+	}
+
+	events := []*cloudformation.StackEvent{}
+	for {
+		resp, err := s.cfnClient.DescribeStackEvents(in)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, e := range resp.StackEvents {
+			if aws.StringValue(e.EventId) == s.last {
+				found = true
+				break
+			}
+			events = append(events, e)
+		}
+		if found || resp.NextToken == nil {
+			break
+		}
+		in.NextToken = resp.NextToken
+	}
+
+	if len(events) > 0 {
+		s.last = aws.StringValue(events[0].EventId)
+	}
+
+	// events come newest first
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+func printStackEvent(out io.Writer, e stackEvent) {
+	line := fmt.Sprintf("%s %s %s", output.Yellow.SprintFunc()(aws.TimeValue(e.Timestamp).Format("15:04:05")),
+		stackStatusColor(aws.StringValue(e.ResourceStatus)), output.Blue.SprintFunc()(e.resource()))
+	if reason := aws.StringValue(e.ResourceStatusReason); reason != "" {
+		line += " " + reason
+	}
+	fmt.Fprintln(out, line)
+}
+
+func stackStatusColor(status string) string {
+	switch {
+	case strings.HasSuffix(status, "_FAILED") || strings.Contains(status, "ROLLBACK"):
+		return output.Red.SprintFunc()(status)
+	case strings.HasSuffix(status, "_COMPLETE"):
+		return output.Green.SprintFunc()(status)
+	}
+	return status
+}
+
+// stackFailed tells if status ends an operation waiting for done: failures
+// and other final statuses, and rollbacks as soon as they start unless done
+// is a rollback status.
+func stackFailed(status, done string) bool {
+	switch {
+	case status == done:
+		return false
+	case strings.HasSuffix(status, "_FAILED"), strings.HasSuffix(status, "_COMPLETE"):
+		return true
+	case strings.Contains(status, "ROLLBACK") && !strings.Contains(done, "ROLLBACK"):
+		return true
+	}
+	return false
+}
+
+// waitForStack prints a stack's events until it reaches the done status. It
+// fails as soon as the stack ends up anywhere else, with the reason of the
+// first failed resource. DescribeStacks can lag behind the operation, so the
+// stack's status only counts once it differs from the one before the
+// operation or a new event for the stack itself reports it.
+func waitForStack(events *stackEvents, done string, timeout time.Duration, out io.Writer) (*cloudformation.Stack, error) {
+	if timeout <= 0 {
+		timeout = defaultStackTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	var failure *cloudformation.StackEvent
+	// status of the newest event for the stack itself
+	reported := ""
+	for {
+		// the stack first, so events up to its status are printed
+		resp, err := events.cfnClient.DescribeStacks(&cloudformation.DescribeStacksInput{
+			StackName: aws.String(events.stackName),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Stacks) != 1 {
+			return nil, errors.NewNotFoundErrorF("stack %s not found", events.stackName)
+		}
+		stack := resp.Stacks[0]
+
+		es, err := events.next()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range es {
+			printStackEvent(out, stackEvent{StackEvent: e})
+			if failure == nil && strings.HasSuffix(aws.StringValue(e.ResourceStatus), "_FAILED") {
+				failure = e
+			}
+			if aws.StringValue(e.ResourceType) == "AWS::CloudFormation::Stack" && aws.StringValue(e.LogicalResourceId) == aws.StringValue(stack.StackName) {
+				reported = aws.StringValue(e.ResourceStatus)
+			}
+		}
+
+		status := aws.StringValue(stack.StackStatus)
+		current := status != events.status || status == reported
+		if current && status == done {
+			return stack, nil
+		}
+		if current && stackFailed(status, done) {
+			reason := aws.StringValue(stack.StackStatusReason)
+			if failure != nil {
+				reason = fmt.Sprintf("%s %s: %s", aws.StringValue(failure.LogicalResourceId),
+					aws.StringValue(failure.ResourceStatus), aws.StringValue(failure.ResourceStatusReason))
+			}
+			return stack, errors.Newf(errors.KindAWS, "stack %s is %s: %s", events.stackName, status, reason)
+		}
+
+		if time.Now().After(deadline) {
+			return stack, errors.Newf(errors.KindTimeout, "stack %s still %s after %s", events.stackName, status, timeout)
+		}
+		time.Sleep(stackPollInterval)
+	}
+}
+
+// waitForStackUpdate follows a stack update started after events was
+// created until it completes.
+func waitForStackUpdate(events *stackEvents, timeout time.Duration, out io.Writer) error {
+	stack, err := waitForStack(events, cloudformation.StackStatusUpdateComplete, timeout, out)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "update complete: %s", aws.StringValue(stack.StackStatus))
+	if reason := aws.StringValue(stack.StackStatusReason); reason != "" {
+		fmt.Fprintf(out, ", %s", reason)
+	}
+	fmt.Fprintln(out)
+	return nil
+}
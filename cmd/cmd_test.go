@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/fatih/color"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/svc/fake"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	testCustomerID = "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"
+	testEmail      = "sterling@isis.com"
+	testBastionID  = "d07cac86-df4a-11e5-a446-4b21b841f273"
+	testInstanceID = "i-77a708b4"
+	testRegion     = "us-west-2"
+	testStackName  = "opsee-stack-" + testCustomerID
+	testUserdata   = "#cloud-config\nwrite_files:\n"
+	testTemplate   = `{
+  "AWSTemplateFormatVersion": "2010-09-09",
+  "Parameters": {
+    "AllowSSH": {},
+    "AssociatePublicIpAddress": {},
+    "BastionId": {},
+    "CustomerId": {},
+    "ImageId": {},
+    "InstanceType": {},
+    "SubnetId": {},
+    "UserData": {},
+    "VpcId": {}
+  }
+}`
+)
+
+type testEnv struct {
+	services *fake.Services
+	aws      *fake.AWS
+	out      *bytes.Buffer
+	// urls requested through httpClient
+	fetched []string
+	// url -> body served through httpClient, testTemplate for every url if
+	// nil and 404 for missing urls otherwise
+	templates map[string]string
+	// url -> Last-Modified of served templates
+	modified map[string]time.Time
+	// guards fetched, templates are downloaded concurrently
+	mu sync.Mutex
+}
+
+// newTestEnv resets flags and points the commands at fakes populated with
+// a single customer that has one bastion and one stack in testRegion.
+func newTestEnv(t *testing.T) *testEnv {
+	viper.Reset()
+	viper.Set("cache.dir", t.TempDir())
+	color.NoColor = true
+	launchPollInterval = time.Millisecond
+	changeSetPollInterval = time.Millisecond
+	stackPollInterval = time.Millisecond
+
+	env := &testEnv{
+		services: fake.NewServices(),
+		aws:      fake.NewAWS(),
+		out:      &bytes.Buffer{},
+	}
+
+	env.services.Users = []*schema.User{
+		{Id: 1, CustomerId: testCustomerID, Email: testEmail, Name: "Sterling Archer"},
+		{Id: 2, CustomerId: "8b5e3b8e-6ba2-11e5-8603-6ba085b2f5b5", Email: "lana@isis.com", Name: "Lana Kane"},
+	}
+	env.services.BastionStates = []*schema.BastionState{
+		{
+			Id:         testBastionID,
+			CustomerId: testCustomerID,
+			Status:     "active",
+			LastSeen:   &opsee_types.Timestamp{Seconds: time.Now().Add(-time.Minute).Unix()},
+			Region:     testRegion,
+			VpcId:      "vpc-1234",
+		},
+		{
+			Id:         "45cde7e8-d118-11e5-a310-ef438a026494",
+			CustomerId: "8b5e3b8e-6ba2-11e5-8603-6ba085b2f5b5",
+			Status:     "inactive",
+			LastSeen:   &opsee_types.Timestamp{Seconds: time.Now().Add(-100 * time.Hour).Unix()},
+			Region:     "us-east-1",
+			VpcId:      "vpc-5678",
+		},
+	}
+
+	region := env.aws.Region(testRegion)
+	region.Instances = []*ec2.Instance{
+		{
+			InstanceId: aws.String(testInstanceID),
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			VpcId:      aws.String("vpc-1234"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("opsee:id"), Value: aws.String(testBastionID)},
+			},
+		},
+	}
+	region.Stacks = []*cloudformation.Stack{
+		{
+			StackId:           aws.String("arn:aws:cloudformation:us-west-2:123456789012:stack/" + testStackName + "/1"),
+			StackName:         aws.String(testStackName),
+			StackStatus:       aws.String(cloudformation.StackStatusCreateComplete),
+			StackStatusReason: aws.String(""),
+			Parameters: []*cloudformation.Parameter{
+				{ParameterKey: aws.String("ImageId"), ParameterValue: aws.String("ami-old")},
+				{ParameterKey: aws.String("AllowSSH"), ParameterValue: aws.String("False")},
+				{ParameterKey: aws.String("UserData"), ParameterValue: aws.String(
+					base64.StdEncoding.EncodeToString([]byte(testUserdata + badUserdata)))},
+				{ParameterKey: aws.String("VpcId"), ParameterValue: aws.String("vpc-1234")},
+			},
+			Tags: []*cloudformation.Tag{
+				{Key: aws.String("opsee:customer-id"), Value: aws.String(testCustomerID)},
+			},
+		},
+	}
+	region.Templates[testStackName] = testTemplate
+	region.StackEvents[testStackName] = []*cloudformation.StackEvent{
+		{
+			EventId:            aws.String("create-2"),
+			Timestamp:          aws.Time(time.Now()),
+			ResourceStatus:     aws.String(cloudformation.ResourceStatusCreateComplete),
+			LogicalResourceId:  aws.String(testStackName),
+			PhysicalResourceId: aws.String("stack-1"),
+		},
+		{
+			EventId:              aws.String("create-1"),
+			Timestamp:            aws.Time(time.Now().Add(-time.Minute)),
+			ResourceStatus:       aws.String(cloudformation.ResourceStatusCreateInProgress),
+			ResourceStatusReason: aws.String("Resource creation Initiated"),
+			LogicalResourceId:    aws.String("BastionInstance"),
+			PhysicalResourceId:   aws.String(testInstanceID),
+		},
+	}
+
+	newOpseeServices = func() (svc.Services, error) {
+		return env.services, nil
+	}
+	awsClients = env.aws
+	stdout = env.out
+	stdin = strings.NewReader("")
+	httpClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		env.fetched = append(env.fetched, r.URL.String())
+		body, ok := testTemplate, true
+		if env.templates != nil {
+			body, ok = env.templates[r.URL.String()]
+		}
+		if !ok {
+			return &http.Response{
+				StatusCode: http.StatusNotFound,
+				Status:     "404 Not Found",
+				Body:       ioutil.NopCloser(strings.NewReader("<Error><Code>NoSuchKey</Code></Error>")),
+				Request:    r,
+			}, nil
+		}
+		header := http.Header{}
+		if modified, ok := env.modified[r.URL.String()]; ok {
+			header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     header,
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    r,
+		}, nil
+	})}
+
+	return env
+}
+
+// setFlagList sets a stringList flag of cmd to values until the test ends,
+// viper.Reset doesn't reset flags.
+func setFlagList(t *testing.T, cmd *cobra.Command, name string, values ...string) {
+	l := cmd.Flags().Lookup(name).Value.(*stringList)
+	*l = append(stringList{}, values...)
+	t.Cleanup(func() { *l = nil })
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func assertContains(t *testing.T, output string, expected ...string) {
+	for _, e := range expected {
+		if !strings.Contains(output, e) {
+			t.Errorf("expected output to contain %q, got:\n%s", e, output)
+		}
+	}
+}
+
+func assertNotContains(t *testing.T, output string, unexpected ...string) {
+	for _, e := range unexpected {
+		if strings.Contains(output, e) {
+			t.Errorf("expected output not to contain %q, got:\n%s", e, output)
+		}
+	}
+}
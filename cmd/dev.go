@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/svc/fake"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "boop development commands",
+}
+
+var devFakeBackendCmd = &cobra.Command{
+	Use:   "fake-backend [fixture file]",
+	Short: "serve fake cats, spanx and keelhaul services from a fixture file (use with --profile local)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.NewUserError("missing fixture file argument")
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		fixtures, err := fake.LoadFixtures(args[0])
+		if err != nil {
+			return err
+		}
+
+		backend, err := fake.NewBackend(fixtures)
+		if err != nil {
+			return err
+		}
+
+		addr := viper.GetString("fake-backend-listen")
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "fake backend listening on %s\n", lis.Addr())
+		return backend.Serve(lis)
+	},
+}
+
+func init() {
+	BoopCmd.AddCommand(devCmd)
+
+	devCmd.AddCommand(devFakeBackendCmd)
+	flags := devFakeBackendCmd.Flags()
+	flags.StringP("listen", "l", svc.LocalAddress, "address to listen on")
+	viper.BindPFlag("fake-backend-listen", flags.Lookup("listen"))
+}
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/util"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const defaultFleetConcurrency = 4
+
+const (
+	fleetOK      = "ok"
+	fleetFailed  = "failed"
+	fleetSkipped = "skipped"
+)
+
+// fleetFlags adds the flags selecting many customers to cmd, bound to
+// viper keys starting with prefix.
+func fleetFlags(cmd *cobra.Command, prefix string) {
+	flags := cmd.Flags()
+	flags.String("customers-file", "", "run for the customers in this file, one email or UUID per line")
+	viper.BindPFlag(prefix+"-customers-file", flags.Lookup("customers-file"))
+	flags.Bool("all-active", false, "run for all customers with an active bastion")
+	viper.BindPFlag(prefix+"-all-active", flags.Lookup("all-active"))
+	flags.Int("concurrency", defaultFleetConcurrency, "max number of customers at once")
+	viper.BindPFlag(prefix+"-concurrency", flags.Lookup("concurrency"))
+	flags.String("state-file", "", "record progress here and skip customers it lists as done")
+	viper.BindPFlag(prefix+"-state-file", flags.Lookup("state-file"))
+}
+
+// isFleet reports whether the command was asked to run for many customers.
+func isFleet(prefix string) bool {
+	return viper.GetString(prefix+"-customers-file") != "" || viper.GetBool(prefix+"-all-active")
+}
+
+// fleetCustomer is a customer's outcome in a fleet run.
+type fleetCustomer struct {
+	CustomerId string    `json:"customer_id"`
+	Email      string    `json:"email"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Finished   time.Time `json:"finished"`
+}
+
+// fleetState is the state file, by customer id. It's rewritten after each
+// customer so an interrupted run can be resumed.
+type fleetState struct {
+	path      string
+	mu        sync.Mutex
+	Customers map[string]*fleetCustomer `json:"customers"`
+}
+
+func loadFleetState(path string) (*fleetState, error) {
+	state := &fleetState{path: path, Customers: make(map[string]*fleetCustomer)}
+	if path == "" {
+		return state, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read state file")
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, errors.NewUserErrorF("invalid state file %s: %s", path, err)
+	}
+	if state.Customers == nil {
+		state.Customers = make(map[string]*fleetCustomer)
+	}
+
+	return state, nil
+}
+
+func (s *fleetState) done(customerID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.Customers[customerID]
+	return ok && c.Status == fleetOK
+}
+
+func (s *fleetState) record(c *fleetCustomer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// customers that couldn't be looked up may only have an email
+	s.Customers[firstNonEmpty(c.CustomerId, c.Email)] = c
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(s.path, b)
+}
+
+// fleetUsers returns the users selected with --customers-file or
+// --all-active, and the selected customers that couldn't be looked up as
+// failed, so one bad line doesn't stop the others.
+func fleetUsers(prefix string, opseeServices svc.Services) ([]*schema.User, []*fleetCustomer, error) {
+	ids := []string{}
+
+	if path := viper.GetString(prefix + "-customers-file"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, nil, errors.NewUserErrorF("cannot read customers file: %s", err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			ids = append(ids, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, nil, errors.NewUserErrorF("cannot read customers file: %s", err)
+		}
+	}
+
+	if viper.GetBool(prefix + "-all-active") {
+		states, err := opseeServices.GetBastionStates([]string{}, &service.Filter{
+			Key:   "status",
+			Value: "active",
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, b := range states {
+			ids = append(ids, b.CustomerId)
+		}
+	}
+
+	users := []*schema.User{}
+	unresolved := []*fleetCustomer{}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		u, err := util.GetUserFromArgs([]string{id}, 0, opseeServices)
+		if err != nil {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			c := &fleetCustomer{Status: fleetFailed, Error: strings.TrimSpace(err.Error()), Finished: time.Now()}
+			if strings.Contains(id, "@") {
+				c.Email = id
+			} else {
+				c.CustomerId = id
+			}
+			unresolved = append(unresolved, c)
+			continue
+		}
+		if seen[u.CustomerId] {
+			continue
+		}
+		seen[u.CustomerId] = true
+		users = append(users, u)
+	}
+
+	if len(users) == 0 && len(unresolved) == 0 {
+		return nil, nil, errors.NewUserError("no customers selected")
+	}
+
+	return users, unresolved, nil
+}
+
+// runFleet calls fn for each selected customer, --concurrency at a time.
+// Each customer's output is printed in one piece, prefixed with its email,
+// when it's done. A summary of all customers is rendered at the end and the
+// error lists the customers that failed.
+func runFleet(prefix string, opseeServices svc.Services, fn func(u *schema.User, out io.Writer) error) error {
+	users, unresolved, err := fleetUsers(prefix, opseeServices)
+	if err != nil {
+		return err
+	}
+
+	state, err := loadFleetState(viper.GetString(prefix + "-state-file"))
+	if err != nil {
+		return err
+	}
+
+	for _, c := range unresolved {
+		if err := state.record(c); err != nil {
+			log.ERROR.Printf("cannot write state file: %s\n", err)
+		}
+		fmt.Fprintf(stdout, "[%s] error: %s\n", firstNonEmpty(c.Email, c.CustomerId), c.Error)
+	}
+
+	concurrency := viper.GetInt(prefix + "-concurrency")
+	if concurrency <= 0 {
+		concurrency = defaultFleetConcurrency
+	}
+
+	results := make([]*fleetCustomer, len(users))
+	outMu := &sync.Mutex{}
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+
+	for i, u := range users {
+		if state.done(u.CustomerId) {
+			log.INFO.Printf("skipping %s, done in a previous run\n", u.Email)
+			results[i] = &fleetCustomer{CustomerId: u.CustomerId, Email: u.Email, Status: fleetSkipped}
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, u *schema.User) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			buf := &bytes.Buffer{}
+			err := fn(u, buf)
+
+			result := &fleetCustomer{CustomerId: u.CustomerId, Email: u.Email, Status: fleetOK, Finished: time.Now()}
+			if err != nil {
+				result.Status = fleetFailed
+				result.Error = strings.TrimSpace(err.Error())
+			}
+			results[i] = result
+			if err := state.record(result); err != nil {
+				log.ERROR.Printf("cannot write state file: %s\n", err)
+			}
+
+			outMu.Lock()
+			defer outMu.Unlock()
+			for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+				if line != "" {
+					fmt.Fprintf(stdout, "[%s] %s\n", u.Email, line)
+				}
+			}
+			if err != nil {
+				fmt.Fprintf(stdout, "[%s] error: %s\n", u.Email, result.Error)
+			}
+		}(i, u)
+	}
+	wg.Wait()
+	results = append(results, unresolved...)
+
+	if err := render(fleetSummary(results)); err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, r := range results {
+		if r.Status == fleetFailed {
+			failed = append(failed, firstNonEmpty(r.Email, r.CustomerId))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.NewSystemErrorF("%d of %d customers failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func fleetSummary(results []*fleetCustomer) *output.Result {
+	res := &output.Result{
+		Data: results,
+		Columns: []output.Column{
+			{Name: "customer_id"},
+			{Name: "email", Color: output.Yellow},
+			{Name: "status"},
+			{Name: "error", Color: output.Red},
+		},
+	}
+	for _, r := range results {
+		res.Rows = append(res.Rows, []string{r.CustomerId, r.Email, r.Status, r.Error})
+	}
+	return res
+}
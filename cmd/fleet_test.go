@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/opsee/spanx/policies"
+	"github.com/spf13/viper"
+)
+
+const testOtherCustomerID = "8b5e3b8e-6ba2-11e5-8603-6ba085b2f5b5"
+
+func writeCustomersFile(t *testing.T, lines string) string {
+	path := filepath.Join(t.TempDir(), "customers")
+	if err := ioutil.WriteFile(path, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFleetRoleUpdatePolicy(t *testing.T) {
+	env := newTestEnv(t)
+	role := "opsee-role-" + testCustomerID
+	policy := "opsee-policy-" + testCustomerID
+	env.aws.RolePolicies[role] = map[string]string{policy: "{}"}
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	viper.Set("role-customers-file", writeCustomersFile(t, "# rollout\n"+testEmail+"\n\n"+testOtherCustomerID+"\n"+testEmail+"\n"))
+	viper.Set("role-state-file", stateFile)
+
+	err := updatePolicyCmd.RunE(updatePolicyCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error for the customer without a role policy")
+	}
+	assertContains(t, err.Error(), "1 of 2 customers failed: lana@isis.com")
+
+	if env.aws.RolePolicies[role][policy] != policies.GetPolicy() {
+		t.Error("expected role policy to be replaced with the current opsee policy")
+	}
+	assertContains(t, env.out.String(), "["+testEmail+"] policy updated: "+policy, "[lana@isis.com] error:",
+		testCustomerID, "ok", testOtherCustomerID, "failed")
+
+	state, err := loadFleetState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.done(testCustomerID) || state.done(testOtherCustomerID) {
+		t.Errorf("unexpected state: %v", state.Customers)
+	}
+
+	// resuming only retries the failed customer
+	env.aws.RolePolicies["opsee-role-"+testOtherCustomerID] = map[string]string{"opsee-policy-" + testOtherCustomerID: "{}"}
+	env.aws.RolePolicies[role][policy] = "{}"
+	env.out.Reset()
+
+	if err := updatePolicyCmd.RunE(updatePolicyCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+	if env.aws.RolePolicies[role][policy] != "{}" {
+		t.Error("expected the finished customer to be skipped")
+	}
+	assertContains(t, env.out.String(), "skipped", "[lana@isis.com] policy updated")
+	assertNotContains(t, env.out.String(), "["+testEmail+"]")
+}
+
+func TestFleetAllActiveRestart(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("restart-all-active", true)
+	viper.Set("output", "csv")
+
+	if err := bastionRestartCmd.RunE(bastionRestartCmd, []string{}); err != nil {
+		t.Fatal(err)
+	}
+
+	rebooted := env.aws.Region(testRegion).Rebooted
+	if len(rebooted) != 1 || rebooted[0] != testInstanceID {
+		t.Fatalf("expected %s to be rebooted, got %v", testInstanceID, rebooted)
+	}
+	assertContains(t, env.out.String(), "["+testEmail+"] instance restart requested for: "+testInstanceID,
+		testCustomerID+","+testEmail+",ok,")
+	assertNotContains(t, env.out.String(), testOtherCustomerID)
+}
+
+func TestFleetUnknownCustomer(t *testing.T) {
+	env := newTestEnv(t)
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	viper.Set("restart-customers-file", writeCustomersFile(t, "cyril@isis.com\n"+testEmail+"\ncyril@isis.com\n"))
+	viper.Set("restart-state-file", stateFile)
+
+	err := bastionRestartCmd.RunE(bastionRestartCmd, []string{})
+	if err == nil {
+		t.Fatal("expected error for unknown customer")
+	}
+	assertContains(t, err.Error(), "1 of 2 customers failed: cyril@isis.com")
+
+	// the other customers still run
+	if rebooted := env.aws.Region(testRegion).Rebooted; len(rebooted) != 1 {
+		t.Errorf("expected %s to be rebooted, got %v", testInstanceID, rebooted)
+	}
+	assertContains(t, env.out.String(), "[cyril@isis.com] error:", "["+testEmail+"] instance restart requested")
+
+	state, err := loadFleetState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := state.Customers["cyril@isis.com"]; c == nil || c.Status != fleetFailed {
+		t.Errorf("expected the unknown customer to be recorded as failed, got %v", state.Customers)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/opsee/basic/schema"
 	"github.com/opsee/boop/errors"
@@ -12,6 +11,7 @@ import (
 	"github.com/opsee/boop/util"
 	"github.com/opsee/spanx/policies"
 	"github.com/spf13/cobra"
+	"io"
 )
 
 type opseePolicy struct {
@@ -28,49 +28,63 @@ var updatePolicyCmd = &cobra.Command{
 	Use:   "updatePolicy [customer email|customer UUID]",
 	Short: "update customer role policy",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
-
-		user, err := util.GetUserFromArgs(args, 0, opseeServices)
+		opseeServices, err := newOpseeServices()
 		if err != nil {
 			return err
 		}
 
-		userCreds, err := opseeServices.GetRoleCreds(user)
-		if err != nil {
-			return errors.NewSystemErrorF("cannot obtain AWS creds for user: %s", user.Id)
-		}
-		staticCreds := credentials.NewStaticCredentials(
-			*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
-
-		iamClient := iam.New(session.New(&aws.Config{
-			Credentials: staticCreds,
-			MaxRetries:  aws.Int(5),
-			Region:      aws.String("us-west-1"),
-		}))
-
-		pol, err := findOpseeRolePolicy(iamClient, user)
-		if err != nil {
-			return err
-		}
-		if pol == nil {
-			return errors.NewSystemErrorF("no role policy for user: %s", user.Email)
+		if isFleet("role") {
+			return runFleet("role", opseeServices, func(user *schema.User, out io.Writer) error {
+				return updateRolePolicy(user, opseeServices, out)
+			})
 		}
 
-		err = pol.updateOpseeRolePolicy(iamClient)
+		user, err := util.GetUserFromArgs(args, 0, opseeServices)
 		if err != nil {
 			return err
 		}
-		fmt.Printf("policy updated: %s\n", pol.Name)
 
-		return nil
+		return updateRolePolicy(user, opseeServices, stdout)
 	},
 }
 
+// updateRolePolicy replaces the customer's opsee role policy with the
+// current one.
+func updateRolePolicy(user *schema.User, opseeServices svc.Services, out io.Writer) error {
+	userCreds, err := opseeServices.GetRoleCreds(user)
+	if err != nil {
+		return errors.Wrapf(err, "cannot obtain AWS creds for user %d", user.Id)
+	}
+	staticCreds := credentials.NewStaticCredentials(
+		*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
+
+	iamClient := awsClients.IAM(staticCreds, "us-west-1")
+
+	pol, err := findOpseeRolePolicy(iamClient, user)
+	if err != nil {
+		return err
+	}
+	if pol == nil {
+		return errors.NewNotFoundErrorF("no role policy for user: %s", user.Email)
+	}
+
+	err = pol.updateOpseeRolePolicy(iamClient)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "policy updated: %s\n", pol.Name)
+
+	return nil
+}
+
 var roleCredsCommand = &cobra.Command{
 	Use:   "creds [customer email|customer UUID]",
 	Short: "print opsee-role cred vars for customer",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
 
 		user, err := util.GetUserFromArgs(args, 0, opseeServices)
 		if err != nil {
@@ -82,15 +96,15 @@ var roleCredsCommand = &cobra.Command{
 			return err
 		}
 
-		fmt.Printf("export AWS_ACCESS_KEY_ID=%s\n", *userCreds.AccessKeyID)
-		fmt.Printf("export AWS_SECRET_ACCESS_KEY=%s\n", *userCreds.SecretAccessKey)
-		fmt.Printf("export AWS_SECURITY_TOKEN=%s\n", *userCreds.SessionToken)
+		fmt.Fprintf(stdout, "export AWS_ACCESS_KEY_ID=%s\n", *userCreds.AccessKeyID)
+		fmt.Fprintf(stdout, "export AWS_SECRET_ACCESS_KEY=%s\n", *userCreds.SecretAccessKey)
+		fmt.Fprintf(stdout, "export AWS_SECURITY_TOKEN=%s\n", *userCreds.SessionToken)
 
 		return nil
 	},
 }
 
-func (p opseePolicy) updateOpseeRolePolicy(client *iam.IAM) error {
+func (p opseePolicy) updateOpseeRolePolicy(client svc.IAM) error {
 	_, err := client.PutRolePolicy(&iam.PutRolePolicyInput{
 		RoleName:       aws.String(p.Role),
 		PolicyName:     aws.String(p.Name),
@@ -100,7 +114,7 @@ func (p opseePolicy) updateOpseeRolePolicy(client *iam.IAM) error {
 	return err
 }
 
-func findOpseeRolePolicy(iamClient *iam.IAM, user *schema.User) (*opseePolicy, error) {
+func findOpseeRolePolicy(iamClient svc.IAM, user *schema.User) (*opseePolicy, error) {
 	pol := &opseePolicy{}
 
 	resp, err := iamClient.GetRolePolicy(&iam.GetRolePolicyInput{
@@ -121,5 +135,6 @@ func findOpseeRolePolicy(iamClient *iam.IAM, user *schema.User) (*opseePolicy, e
 func init() {
 	BoopCmd.AddCommand(roleCmd)
 	roleCmd.AddCommand(updatePolicyCmd)
+	fleetFlags(updatePolicyCmd, "role")
 	roleCmd.AddCommand(roleCredsCommand)
 }
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/opsee/spanx/policies"
+)
+
+func TestRoleUpdatePolicy(t *testing.T) {
+	env := newTestEnv(t)
+	role := "opsee-role-" + testCustomerID
+	policy := "opsee-policy-" + testCustomerID
+	env.aws.RolePolicies[role] = map[string]string{policy: "{}"}
+
+	if err := updatePolicyCmd.RunE(updatePolicyCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	if env.aws.RolePolicies[role][policy] != policies.GetPolicy() {
+		t.Error("expected role policy to be replaced with the current opsee policy")
+	}
+	assertContains(t, env.out.String(), "policy updated: "+policy)
+}
+
+func TestRoleUpdatePolicyMissingPolicy(t *testing.T) {
+	env := newTestEnv(t)
+
+	if err := updatePolicyCmd.RunE(updatePolicyCmd, []string{testEmail}); err == nil {
+		t.Fatal("expected error for missing role policy")
+	}
+	if len(env.aws.RolePolicies) != 0 {
+		t.Error("expected no policies to be written")
+	}
+}
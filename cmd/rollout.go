@@ -0,0 +1,423 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/rollout"
+	"github.com/opsee/boop/svc"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var rolloutCmd = &cobra.Command{
+	Use:   "rollout",
+	Short: "update bastion stacks of many customers in waves",
+}
+
+var rolloutPlanCmd = &cobra.Command{
+	Use:   "plan [name]",
+	Short: "plan a rollout of an image to the selected customers",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.NewUserError("missing rollout name")
+		}
+
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		path := rolloutPath(args[0])
+		if _, err := os.Stat(path); err == nil && !viper.GetBool("rollout-force") {
+			return errors.NewUserErrorF("rollout %s already exists, use --force to replace it", args[0])
+		}
+
+		waves, err := rollout.ParseWaves(viper.GetString("rollout-waves"))
+		if err != nil {
+			return err
+		}
+
+		target := rollout.Target{
+			ImageId:         viper.GetString("rollout-ami-id"),
+			TemplateChannel: viper.GetString("rollout-channel"),
+		}
+		if target.ImageId == "" {
+			target.Channel = viper.GetString("rollout-image-channel")
+			if target.Channel == "" {
+				target.Channel = defaultImageChannel
+			}
+		}
+		if target.TemplateChannel == "" {
+			target.TemplateChannel = defaultTemplateChannel
+		}
+
+		canary := 1
+		if viper.IsSet("rollout-canary") {
+			canary = viper.GetInt("rollout-canary")
+		}
+
+		users, unresolved, err := fleetUsers("rollout", opseeServices)
+		if err != nil {
+			return err
+		}
+		for _, c := range unresolved {
+			fmt.Fprintf(stdout, "leaving out %s: %s\n", firstNonEmpty(c.Email, c.CustomerId), c.Error)
+		}
+		customers := []*rollout.Customer{}
+		for _, u := range users {
+			customers = append(customers, &rollout.Customer{CustomerId: u.CustomerId, Email: u.Email})
+		}
+
+		plan, err := rollout.New(args[0], customers, canary, waves, target)
+		if err != nil {
+			return err
+		}
+		if err := plan.Save(path); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(stdout, "planned rollout %s of %s to %d customers in %d waves\n", plan.Name, plan.Target, len(customers), len(plan.Waves))
+		return render(rolloutStatus(plan))
+	},
+}
+
+var rolloutRunCmd = &cobra.Command{
+	Use:   "run [name]",
+	Short: "run or continue a rollout",
+	Long: `Updates the stacks of a rollout's customers wave by wave, waiting for each
+update to finish. The next wave only starts once every customer of the
+current one is done. A stack rolling back (UPDATE_ROLLBACK_*) pauses the
+rollout until "boop rollout resume". Progress is saved after each customer,
+so an interrupted rollout continues where it stopped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.NewUserError("missing rollout name")
+		}
+
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
+
+		if viper.GetBool("verbose") {
+			log.SetStdoutThreshold(log.LevelInfo)
+		}
+
+		plan, err := rollout.Load(rolloutPath(args[0]))
+		if err != nil {
+			return err
+		}
+		if plan.Paused {
+			return errors.NewUserErrorF("rollout %s is paused: %s\nrun boop rollout resume %s to continue", plan.Name, plan.PauseReason, plan.Name)
+		}
+
+		for _, wave := range plan.Waves {
+			remaining := wave.Remaining()
+			if len(remaining) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(stdout, "wave %s: updating %d customers to %s\n", wave.Name, len(remaining), plan.Target)
+			started := runWave(plan, remaining, opseeServices)
+
+			rolledBack, failed := []string{}, []string{}
+			for _, c := range started {
+				switch c.Status {
+				case rollout.RolledBack:
+					rolledBack = append(rolledBack, c.Email)
+				case rollout.Failed:
+					failed = append(failed, c.Email)
+				}
+			}
+
+			if len(rolledBack) > 0 {
+				reason := fmt.Sprintf("wave %s: stacks rolled back for %s", wave.Name, strings.Join(rolledBack, ", "))
+				if err := plan.Pause(reason); err != nil {
+					return err
+				}
+				return errors.Newf(errors.KindAWS, "rollout %s paused, %s", plan.Name, reason)
+			}
+			if len(failed) > 0 {
+				return errors.NewSystemErrorF("wave %s: %d of %d customers failed: %s\nrun again to retry", wave.Name, len(failed), len(started), strings.Join(failed, ", "))
+			}
+
+			fmt.Fprintf(stdout, "wave %s done\n", wave.Name)
+			if viper.GetBool("rollout-one-wave") && !plan.Done() {
+				return nil
+			}
+		}
+
+		fmt.Fprintf(stdout, "rollout %s complete\n", plan.Name)
+		return nil
+	},
+}
+
+var rolloutStatusCmd = &cobra.Command{
+	Use:   "status [name]",
+	Short: "show a rollout's progress",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.NewUserError("missing rollout name")
+		}
+
+		plan, err := rollout.Load(rolloutPath(args[0]))
+		if err != nil {
+			return err
+		}
+
+		return render(rolloutStatus(plan))
+	},
+}
+
+var rolloutResumeCmd = &cobra.Command{
+	Use:   "resume [name]",
+	Short: "unpause a rollout, continue it with rollout run",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 {
+			return errors.NewUserError("missing rollout name")
+		}
+
+		plan, err := rollout.Load(rolloutPath(args[0]))
+		if err != nil {
+			return err
+		}
+		if !plan.Paused {
+			fmt.Fprintf(stdout, "rollout %s isn't paused\n", plan.Name)
+			return nil
+		}
+
+		if err := plan.Resume(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "rollout %s resumed\n", plan.Name)
+		return nil
+	},
+}
+
+// rolloutPath returns the file of the named rollout in the rollout.dir from
+// the config, ~/.boop/rollouts by default.
+func rolloutPath(name string) string {
+	dir := viper.GetString("rollout.dir")
+	if dir == "" {
+		dir = rollout.DefaultDir()
+	}
+	return rollout.Path(dir, name)
+}
+
+// runWave updates the customers' stacks, --concurrency at a time, and
+// records the outcome in the plan. Once a stack rolls back no more customers
+// are started, the ones already running finish. It returns the customers it
+// started.
+func runWave(plan *rollout.Plan, customers []*rollout.Customer, opseeServices svc.Services) []*rollout.Customer {
+	concurrency := viper.GetInt("rollout-concurrency")
+	if concurrency <= 0 {
+		concurrency = defaultFleetConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	outMu := &sync.Mutex{}
+	rolledBack := false
+	started := []*rollout.Customer{}
+
+	for _, c := range customers {
+		sem <- struct{}{}
+		outMu.Lock()
+		stop := rolledBack
+		outMu.Unlock()
+		if stop {
+			<-sem
+			fmt.Fprintln(stdout, "a stack rolled back, not starting more customers")
+			break
+		}
+
+		started = append(started, c)
+		wg.Add(1)
+		go func(c *rollout.Customer) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			buf := &bytes.Buffer{}
+			rolloutCustomer(plan, c, opseeServices, buf)
+
+			outMu.Lock()
+			defer outMu.Unlock()
+			if c.Status == rollout.RolledBack {
+				rolledBack = true
+			}
+			for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+				if line != "" {
+					fmt.Fprintf(stdout, "[%s] %s\n", c.Email, line)
+				}
+			}
+			fmt.Fprintf(stdout, "[%s] %s\n", c.Email, c.Status)
+		}(c)
+	}
+	wg.Wait()
+
+	return started
+}
+
+// rolloutCustomer updates a customer's stack to the plan's image and waits
+// for it, then records the stack's status.
+func rolloutCustomer(plan *rollout.Plan, c *rollout.Customer, opseeServices svc.Services, out io.Writer) {
+	save := func(f func(c *rollout.Customer)) {
+		if err := plan.Update(c, f); err != nil {
+			log.ERROR.Printf("cannot save rollout %s: %s\n", plan.Name, err)
+		}
+	}
+
+	u, err := opseeServices.GetUser("", c.CustomerId)
+	if err != nil {
+		save(func(c *rollout.Customer) {
+			c.Status = rollout.Failed
+			c.Error = strings.TrimSpace(err.Error())
+		})
+		return
+	}
+
+	image := func(region string) (string, error) {
+		image, err := plan.Image(region, latestImage)
+		if err != nil {
+			return "", err
+		}
+		save(func(c *rollout.Customer) {
+			c.Region = region
+			c.ImageId = image
+		})
+		return image, nil
+	}
+
+	save(func(c *rollout.Customer) {
+		c.Region = ""
+		c.Error = ""
+	})
+	err = updateStack(u, opseeServices, rolloutTemplate(plan), image, previousParams(), true, out)
+	// the stack is already at the target
+	if err != nil && strings.Contains(err.Error(), "No updates are to be performed") {
+		err = nil
+	}
+
+	stackStatus := ""
+	if c.Region != "" {
+		stack, serr := findStack(u, "opsee-stack-"+u.CustomerId, opseeServices)
+		if serr != nil {
+			log.WARN.Printf("cannot get stack status for %s: %s\n", u.Email, serr)
+		} else if stack.Stack != nil {
+			stackStatus = aws.StringValue(stack.Stack.StackStatus)
+		}
+	}
+
+	save(func(c *rollout.Customer) {
+		c.StackStatus = stackStatus
+		switch {
+		case strings.HasPrefix(stackStatus, "UPDATE_ROLLBACK_"):
+			c.Status = rollout.RolledBack
+			if err != nil {
+				c.Error = strings.TrimSpace(err.Error())
+			}
+		case err != nil:
+			c.Status = rollout.Failed
+			c.Error = strings.TrimSpace(err.Error())
+		case c.Region == "":
+			c.Status = rollout.Failed
+			c.Error = "stack not found"
+		default:
+			c.Status = rollout.Done
+		}
+	})
+}
+
+// rolloutTemplate returns the bastion template of the plan's channel for a
+// region, the same one for every customer in the region.
+func rolloutTemplate(plan *rollout.Plan) func(region string) ([]byte, error) {
+	return func(region string) ([]byte, error) {
+		return plan.Template(region, func(region, channel string) ([]byte, error) {
+			// plans from before templates were pinned
+			if channel == "" {
+				channel = defaultTemplateChannel
+			}
+			return cfnStack{Region: region}.getCFNTemplate(templateSource{Channel: channel})
+		})
+	}
+}
+
+func rolloutStatus(plan *rollout.Plan) *output.Result {
+	res := &output.Result{
+		Data: plan,
+		Columns: []output.Column{
+			{Name: "wave"},
+			{Name: "customer_id"},
+			{Name: "email", Color: output.Yellow},
+			{Name: "status"},
+			{Name: "region"},
+			{Name: "image"},
+			{Name: "stack status"},
+			{Name: "error", Color: output.Red},
+		},
+	}
+
+	for _, w := range plan.Waves {
+		for _, c := range w.Customers {
+			res.Rows = append(res.Rows, []string{w.Name, c.CustomerId, c.Email, c.Status, c.Region, c.ImageId, c.StackStatus, c.Error})
+		}
+	}
+
+	res.Text = func(out io.Writer) error {
+		if plan.Paused {
+			fmt.Fprintf(out, "paused: %s\n\n", plan.PauseReason)
+		}
+		t, err := output.New(output.Table, "", "", out)
+		if err != nil {
+			return err
+		}
+		return t.Render(&output.Result{Columns: res.Columns, Rows: res.Rows})
+	}
+
+	return res
+}
+
+func init() {
+	BoopCmd.AddCommand(rolloutCmd)
+
+	rolloutCmd.AddCommand(rolloutPlanCmd)
+	flags := rolloutPlanCmd.Flags()
+	flags.String("customers-file", "", "roll out to the customers in this file, one email or UUID per line")
+	viper.BindPFlag("rollout-customers-file", flags.Lookup("customers-file"))
+	flags.Bool("all-active", false, "roll out to all customers with an active bastion")
+	viper.BindPFlag("rollout-all-active", flags.Lookup("all-active"))
+	flags.StringP("ami-id", "i", "", "image to roll out (default: the latest image of --image-channel in each region)")
+	viper.BindPFlag("rollout-ami-id", flags.Lookup("ami-id"))
+	flags.String("image-channel", defaultImageChannel, "image release channel")
+	viper.BindPFlag("rollout-image-channel", flags.Lookup("image-channel"))
+	flags.String("channel", defaultTemplateChannel, "template channel of the regions' buckets")
+	viper.BindPFlag("rollout-channel", flags.Lookup("channel"))
+	flags.Int("canary", 1, "number of customers in the first wave")
+	viper.BindPFlag("rollout-canary", flags.Lookup("canary"))
+	flags.String("waves", "10,50,100", "cumulative percentages of customers updated after the canary")
+	viper.BindPFlag("rollout-waves", flags.Lookup("waves"))
+	flags.Bool("force", false, "replace an existing rollout of the same name")
+	viper.BindPFlag("rollout-force", flags.Lookup("force"))
+
+	rolloutCmd.AddCommand(rolloutRunCmd)
+	flags = rolloutRunCmd.Flags()
+	flags.Int("concurrency", defaultFleetConcurrency, "max number of customers updated at once")
+	viper.BindPFlag("rollout-concurrency", flags.Lookup("concurrency"))
+	flags.Bool("one-wave", false, "stop after the next wave")
+	viper.BindPFlag("rollout-one-wave", flags.Lookup("one-wave"))
+
+	rolloutCmd.AddCommand(rolloutStatusCmd)
+	rolloutCmd.AddCommand(rolloutResumeCmd)
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/rollout"
+	"github.com/spf13/viper"
+)
+
+func planRollout(t *testing.T, customers string) {
+	viper.Set("rollout.dir", t.TempDir())
+	viper.Set("rollout-customers-file", writeCustomersFile(t, customers))
+	viper.Set("rollout-ami-id", "ami-new")
+
+	if err := rolloutPlanCmd.RunE(rolloutPlanCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRolloutTemplateChannel(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("rollout-channel", "stable")
+	planRollout(t, testEmail+"\n")
+	assertContains(t, env.out.String(), "ami-new with the stable template")
+
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.fetched) != 1 || env.fetched[0] != testStableTemplateURL {
+		t.Errorf("expected the stable template, got %v", env.fetched)
+	}
+}
+
+func TestRolloutPlanUnknownCustomer(t *testing.T) {
+	env := newTestEnv(t)
+	planRollout(t, "cyril@isis.com\n"+testEmail+"\n")
+
+	plan, err := rollout.Load(rolloutPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Waves) != 1 || len(plan.Waves[0].Customers) != 1 || plan.Waves[0].Customers[0].Email != testEmail {
+		t.Errorf("expected only %s in the plan, got %+v", testEmail, plan.Waves)
+	}
+	assertContains(t, env.out.String(), "leaving out cyril@isis.com:", "to 1 customers")
+}
+
+func TestRollout(t *testing.T) {
+	env := newTestEnv(t)
+	planRollout(t, testEmail+"\n")
+	assertContains(t, env.out.String(), "planned rollout test of ami-new with the beta template to 1 customers in 1 waves", "canary", testEmail, "pending")
+
+	if err := rolloutPlanCmd.RunE(rolloutPlanCmd, []string{"test"}); err == nil {
+		t.Fatal("expected error for existing rollout")
+	}
+
+	env.out.Reset()
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(updates))
+	}
+	if p := stackParam(updates[0].Parameters, "ImageId"); aws.StringValue(p.ParameterValue) != "ami-new" {
+		t.Errorf("expected ImageId ami-new, got %s", aws.StringValue(p.ParameterValue))
+	}
+	assertContains(t, env.out.String(), "wave canary done", "rollout test complete", "["+testEmail+"] done")
+
+	plan, err := rollout.Load(rolloutPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := plan.Waves[0].Customers[0]
+	if c.Status != rollout.Done || c.Region != testRegion || c.ImageId != "ami-new" || c.StackStatus != cloudformation.StackStatusUpdateComplete {
+		t.Errorf("unexpected customer progress %+v", c)
+	}
+
+	// nothing left to do
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 1 {
+		t.Error("expected no more stack updates")
+	}
+}
+
+func TestRolloutKeepsParams(t *testing.T) {
+	env := newTestEnv(t)
+	planRollout(t, testEmail+"\n")
+	// cfn update's flags don't apply to rollouts
+	viper.Set("cfnup-allow-ssh", true)
+	viper.Set("userdata", true)
+	setFlagList(t, cfnUpdate, "param", "InstanceType=t2.large")
+
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	updates := env.aws.Region(testRegion).StackUpdates
+	if len(updates) != 1 {
+		t.Fatalf("expected 1 stack update, got %d", len(updates))
+	}
+	for _, key := range []string{"AllowSSH", "UserData"} {
+		if p := stackParam(updates[0].Parameters, key); !aws.BoolValue(p.UsePreviousValue) || p.ParameterValue != nil {
+			t.Errorf("expected %s to use its previous value, got %v", key, p)
+		}
+	}
+	// the stack has no InstanceType, so the template's default applies
+	if p := stackParam(updates[0].Parameters, "InstanceType"); p != nil {
+		t.Errorf("expected no InstanceType, got %v", p)
+	}
+}
+
+func TestRolloutPausesOnRollback(t *testing.T) {
+	env := newTestEnv(t)
+	planRollout(t, testEmail+"\n")
+	env.aws.Region(testRegion).UpdateStatus = cloudformation.StackStatusUpdateRollbackComplete
+
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err == nil {
+		t.Fatal("expected error for rolled back stack")
+	}
+
+	plan, err := rollout.Load(rolloutPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !plan.Paused || plan.Waves[0].Customers[0].Status != rollout.RolledBack {
+		t.Fatalf("expected a paused rollout, got %+v", plan)
+	}
+
+	err = rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"})
+	if !errors.IsUserError(err) {
+		t.Fatalf("expected user error for paused rollout, got %v", err)
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 1 {
+		t.Error("expected no stack updates while paused")
+	}
+
+	env.aws.Region(testRegion).UpdateStatus = ""
+	if err := rolloutResumeCmd.RunE(rolloutResumeCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 2 {
+		t.Error("expected the rolled back stack to be updated again")
+	}
+}
+
+func TestRolloutStopsWaveOnRollback(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("rollout-canary", 0)
+	viper.Set("rollout-waves", "100")
+	viper.Set("rollout-concurrency", 1)
+	planRollout(t, testEmail+"\nlana@isis.com\n")
+	env.aws.Region(testRegion).UpdateStatus = cloudformation.StackStatusUpdateRollbackComplete
+
+	err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"})
+	if errors.KindOf(err) != errors.KindAWS {
+		t.Fatalf("expected the rollout to pause, got %v", err)
+	}
+	assertContains(t, err.Error(), "stacks rolled back for "+testEmail)
+	assertNotContains(t, err.Error(), "lana@isis.com")
+
+	plan, err := rollout.Load(rolloutPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := plan.Waves[0].Customers[1]; c.Status != rollout.Pending {
+		t.Errorf("expected lana not to be started, got %+v", c)
+	}
+	assertContains(t, env.out.String(), "not starting more customers")
+}
+
+func TestRolloutPinsTemplate(t *testing.T) {
+	env := newTestEnv(t)
+	planRollout(t, testEmail+"\n")
+	env.aws.Region(testRegion).UpdateStatus = cloudformation.StackStatusUpdateRollbackComplete
+
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err == nil {
+		t.Fatal("expected error for rolled back stack")
+	}
+	if err := rolloutResumeCmd.RunE(rolloutResumeCmd, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the channel's template changes before the retry
+	env.templates = map[string]string{
+		cfnStack{Region: testRegion}.getS3URL(defaultTemplateChannel, cfnTemplate): testOldTemplate,
+	}
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err == nil {
+		t.Fatal("expected error for a changed template")
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 1 {
+		t.Error("expected no update with the changed template")
+	}
+
+	plan, err := rollout.Load(rolloutPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := plan.Waves[0].Customers[0]; c.Status != rollout.Failed {
+		t.Errorf("expected the customer to fail, got %+v", c)
+	} else {
+		assertContains(t, c.Error, "template in "+testRegion+" changed since the rollout started")
+	}
+}
+
+func TestRolloutStopsAfterFailedWave(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("rollout-waves", "50")
+	// lana has no stack
+	planRollout(t, "lana@isis.com\n"+testEmail+"\n")
+
+	if err := rolloutRunCmd.RunE(rolloutRunCmd, []string{"test"}); err == nil {
+		t.Fatal("expected error for failed canary")
+	}
+	if len(env.aws.Region(testRegion).StackUpdates) != 0 {
+		t.Error("expected the next wave not to start")
+	}
+
+	plan, err := rollout.Load(rolloutPath("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := plan.Waves[0].Customers[0]; c.Status != rollout.Failed || c.Error != "stack not found" {
+		t.Errorf("expected failed canary, got %+v", c)
+	}
+	if plan.Paused {
+		t.Error("expected failures other than rollbacks not to pause the rollout")
+	}
+}
@@ -2,15 +2,14 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/opsee/boop/errors"
-	"github.com/opsee/boop/svc"
+	"github.com/opsee/boop/output"
 	"github.com/opsee/boop/util"
-	"github.com/opsee/keelhaul/scanner"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"io"
+	"strconv"
 )
 
 const (
@@ -23,7 +22,10 @@ var scanCmd = &cobra.Command{
 	Use:   "scan [customer email|UUID]",
 	Short: "scan a customer's env",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opseeServices := &svc.OpseeServices{}
+		opseeServices, err := newOpseeServices()
+		if err != nil {
+			return err
+		}
 
 		u, err := util.GetUserFromArgs(args, 0, opseeServices)
 		if err != nil {
@@ -37,31 +39,43 @@ var scanCmd = &cobra.Command{
 
 		userCreds, err := opseeServices.GetRoleCreds(u)
 		if err != nil {
-			return errors.NewSystemErrorF("cannot obtain AWS creds for user: %s", u.Id)
+			return errors.Wrapf(err, "cannot obtain AWS creds for user %d", u.Id)
 		}
 
 		staticCreds := credentials.NewStaticCredentials(
 			*userCreds.AccessKeyID, *userCreds.SecretAccessKey, *userCreds.SessionToken)
 
-		session := session.New(aws.NewConfig().
-			WithCredentials(staticCreds).
-			WithRegion(region).WithMaxRetries(5))
-
-		regionScan, err := scanner.ScanRegion(region, session)
+		regionScan, err := awsClients.ScanRegion(staticCreds, region)
 		if err != nil {
 			return err
 		}
 
-		for _, v := range regionScan.Vpcs {
-			fmt.Printf("%s (%d instances, default=%t)\n", v.VpcId, v.InstanceCount, v.IsDefault)
-			for _, s := range regionScan.Subnets {
-				if s.VpcId == v.VpcId {
-					fmt.Printf("  %s (%s, %d instances, %s)\n", s.SubnetId, s.AvailabilityZone, s.InstanceCount, s.Routing)
+		res := &output.Result{
+			Data: regionScan,
+			Columns: []output.Column{
+				{Name: "vpc_id"},
+				{Name: "subnet_id"},
+				{Name: "availability_zone"},
+				{Name: "instance_count"},
+				{Name: "routing"},
+			},
+			Text: func(w io.Writer) error {
+				for _, v := range regionScan.Vpcs {
+					fmt.Fprintf(w, "%s (%d instances, default=%t)\n", v.VpcId, v.InstanceCount, v.IsDefault)
+					for _, s := range regionScan.Subnets {
+						if s.VpcId == v.VpcId {
+							fmt.Fprintf(w, "  %s (%s, %d instances, %s)\n", s.SubnetId, s.AvailabilityZone, s.InstanceCount, s.Routing)
+						}
+					}
 				}
-			}
+				return nil
+			},
+		}
+		for _, s := range regionScan.Subnets {
+			res.Rows = append(res.Rows, []string{s.VpcId, s.SubnetId, s.AvailabilityZone, strconv.Itoa(int(s.InstanceCount)), s.Routing})
 		}
 
-		return nil
+		return render(res)
 	},
 }
 
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+func TestScan(t *testing.T) {
+	env := newTestEnv(t)
+	viper.Set("scan-region", testRegion)
+	env.aws.Region(testRegion).Scan = &schema.Region{
+		Region: testRegion,
+		Vpcs: []*schema.Vpc{
+			{VpcId: "vpc-1234", InstanceCount: 3, IsDefault: true},
+		},
+		Subnets: []*schema.Subnet{
+			{SubnetId: "subnet-1", VpcId: "vpc-1234", AvailabilityZone: "us-west-2a", InstanceCount: 2, Routing: schema.RoutingStatePublic},
+			{SubnetId: "subnet-2", VpcId: "vpc-other", AvailabilityZone: "us-west-2b", InstanceCount: 1, Routing: schema.RoutingStatePrivate},
+		},
+	}
+
+	if err := scanCmd.RunE(scanCmd, []string{testEmail}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, env.out.String(),
+		"vpc-1234 (3 instances, default=true)",
+		"subnet-1 (us-west-2a, 2 instances, public)")
+	assertNotContains(t, env.out.String(), "subnet-2")
+}
+
+func TestScanMissingRegion(t *testing.T) {
+	newTestEnv(t)
+
+	err := scanCmd.RunE(scanCmd, []string{testEmail})
+	if err == nil || !errors.IsUserError(err) {
+		t.Fatalf("expected user error for missing region, got %v", err)
+	}
+}
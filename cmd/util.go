@@ -1,8 +1,19 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/boop/cache"
 	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/output"
+	"github.com/opsee/boop/svc"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"io"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -42,3 +53,137 @@ func parseUserID(id string) (email string, uuid string, err error) {
 
 	return "", "", errors.NewUserError("no email or UUID found in string")
 }
+
+// newOpseeServices returns opsee services for the profile selected with --profile
+var newOpseeServices = func() (svc.Services, error) {
+	profile, err := svc.LoadProfile(viper.GetString("profile"))
+	if err != nil {
+		return nil, err
+	}
+
+	return svc.NewOpseeServices(profile), nil
+}
+
+// newLocationCache returns the bastion and stack location cache, disabled
+// with --no-cache
+var newLocationCache = func() *cache.Cache {
+	dir := viper.GetString("cache.dir")
+	if dir == "" {
+		dir = cache.DefaultDir()
+	}
+
+	c := cache.New(dir, viper.GetDuration("cache.ttl"))
+	c.Disabled = viper.GetBool("no-cache")
+	return c
+}
+
+// scanRegions returns the regions to scan, selected with --regions from the
+// config's regions or, with --refresh-regions, the (cached) regions from
+// DescribeRegions.
+func scanRegions(creds *credentials.Credentials) ([]string, error) {
+	registry := svc.LoadRegions()
+
+	if viper.GetBool("refresh-regions") {
+		locations := newLocationCache()
+		if regions, ok := locations.Regions(); ok {
+			registry.Regions = regions
+		} else if err := registry.Refresh(awsClients.EC2(creds, "us-east-1")); err != nil {
+			log.WARN.Printf("using configured regions: %s\n", err)
+		} else {
+			locations.PutRegions(registry.Regions)
+		}
+	}
+
+	return registry.Select(viper.GetString("region-filter"))
+}
+
+// newFanOut returns a region fan-out configured with --region-workers and
+// --region-timeout
+func newFanOut() svc.FanOut {
+	return svc.FanOut{
+		Workers: viper.GetInt("region-workers"),
+		Timeout: viper.GetDuration("region-timeout"),
+	}
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList is a repeatable string flag. Unlike a StringSlice, it keeps
+// every value as given, commas, quotes and empty values included.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func (l *stringList) Type() string {
+	return "string"
+}
+
+// flagList returns the values of a stringList flag.
+func flagList(flags *pflag.FlagSet, name string) []string {
+	if f := flags.Lookup(name); f != nil {
+		if l, ok := f.Value.(*stringList); ok {
+			return *l
+		}
+	}
+	return nil
+}
+
+// render prints a command's result in the format selected with --output,
+// filtered with --query
+func render(res *output.Result) error {
+	r, err := output.New(viper.GetString("output"), viper.GetString("template"), viper.GetString("query"), stdout)
+	if err != nil {
+		return err
+	}
+
+	return r.Render(res)
+}
+
+// confirm asks a yes/no question and reads the answer from stdin. Anything
+// but y or yes, including no answer, is a no.
+func confirm(out io.Writer, question string) (bool, error) {
+	answer, err := ask(out, question+" [y/N] ")
+	if err != nil {
+		return false, err
+	}
+
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// confirmTyped asks the user to type expected, for things hard to undo.
+func confirmTyped(out io.Writer, expected string) (bool, error) {
+	answer, err := ask(out, fmt.Sprintf("type %s to confirm: ", expected))
+	if err != nil {
+		return false, err
+	}
+	return answer == expected, nil
+}
+
+// ask prints prompt and returns the line read from stdin.
+func ask(out io.Writer, prompt string) (string, error) {
+	fmt.Fprint(out, prompt)
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err == io.EOF {
+		// no newline from the user to end the prompt's line
+		fmt.Fprintln(out)
+	} else if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(line), nil
+}
@@ -3,47 +3,251 @@ package errors
 import (
 	"fmt"
 	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Kind classifies errors so callers (and scripts, via exit codes) can
+// tell failures apart.
+type Kind int
+
+const (
+	// anything not covered below
+	KindSystem Kind = iota
+	// bad arguments or flags
+	KindUsage
+	// a user, bastion, stack or other resource doesn't exist
+	KindNotFound
+	// missing or rejected credentials
+	KindPermission
+	// an opsee service couldn't be reached
+	KindUnavailable
+	// an AWS API call failed
+	KindAWS
+	// an operation ran out of time
+	KindTimeout
 )
 
+// Exit codes by kind, mostly following sysexits.h.
+const (
+	ExitSystem      = 1
+	ExitUsage       = 64 // EX_USAGE
+	ExitNotFound    = 66 // EX_NOINPUT
+	ExitUnavailable = 69 // EX_UNAVAILABLE
+	ExitTimeout     = 75 // EX_TEMPFAIL
+	ExitAWS         = 76 // EX_PROTOCOL
+	ExitPermission  = 77 // EX_NOPERM
+)
+
+var kindNames = map[Kind]string{
+	KindSystem:      "system",
+	KindUsage:       "usage",
+	KindNotFound:    "not-found",
+	KindPermission:  "permission",
+	KindUnavailable: "unavailable",
+	KindAWS:         "aws",
+	KindTimeout:     "timeout",
+}
+
+var kindExitCodes = map[Kind]int{
+	KindSystem:      ExitSystem,
+	KindUsage:       ExitUsage,
+	KindNotFound:    ExitNotFound,
+	KindPermission:  ExitPermission,
+	KindUnavailable: ExitUnavailable,
+	KindAWS:         ExitAWS,
+	KindTimeout:     ExitTimeout,
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+func (k Kind) ExitCode() int {
+	return kindExitCodes[k]
+}
+
 type BoopError struct {
-	s           string
-	userError   bool
-	systemError bool
+	s     string
+	kind  Kind
+	cause error
 }
 
 func (e BoopError) Error() string {
 	return e.s
 }
 
-func (e BoopError) isUserError() bool {
-	return e.userError
+func (e BoopError) Kind() Kind {
+	return e.kind
+}
+
+// Unwrap returns the underlying error, if any.
+func (e BoopError) Unwrap() error {
+	return e.cause
+}
+
+func New(kind Kind, a ...interface{}) BoopError {
+	return BoopError{s: fmt.Sprintln(a...), kind: kind}
+}
+
+func Newf(kind Kind, format string, a ...interface{}) BoopError {
+	return BoopError{s: fmt.Sprintf(format, a...), kind: kind}
 }
 
 func NewUserError(a ...interface{}) BoopError {
-	return BoopError{s: fmt.Sprintln(a...), userError: true}
+	return New(KindUsage, a...)
 }
 
 func NewUserErrorF(format string, a ...interface{}) BoopError {
-	return BoopError{s: fmt.Sprintf(format, a...), userError: true}
+	return Newf(KindUsage, format, a...)
 }
 
 func NewSystemError(a ...interface{}) BoopError {
-	return BoopError{s: fmt.Sprintln(a...), userError: false}
+	return New(KindSystem, a...)
 }
 
 func NewSystemErrorF(format string, a ...interface{}) BoopError {
-	return BoopError{s: fmt.Sprintf(format, a...), userError: false}
+	return Newf(KindSystem, format, a...)
+}
+
+func NewNotFoundErrorF(format string, a ...interface{}) BoopError {
+	return Newf(KindNotFound, format, a...)
 }
 
-// catch some of the obvious user errors from Cobra.
+// NewUnavailableError is returned when an opsee service can't be reached.
+func NewUnavailableError(service, addr string, err error) BoopError {
+	return BoopError{
+		s:     fmt.Sprintf("%s unreachable at %s: %s (are you on the VPN?)", service, addr, err),
+		kind:  KindUnavailable,
+		cause: err,
+	}
+}
+
+// NewServiceError wraps an error returned by an opsee service call.
+func NewServiceError(service, method string, err error) BoopError {
+	return BoopError{
+		s:     fmt.Sprintf("%s %s failed: %s", service, method, grpc.ErrorDesc(err)),
+		kind:  KindOf(err),
+		cause: err,
+	}
+}
+
+// Wrapf prefixes an error's message, keeping its kind. A nil err stays nil.
+func Wrapf(err error, format string, a ...interface{}) error {
+	if err == nil {
+		return nil
+	}
+	return BoopError{
+		s:     fmt.Sprintf(format, a...) + ": " + err.Error(),
+		kind:  KindOf(err),
+		cause: err,
+	}
+}
+
+// KindOf classifies any error: BoopErrors carry their kind, grpc and AWS
+// errors are classified by code.
+func KindOf(err error) Kind {
+	if err == nil {
+		return KindSystem
+	}
+
+	if bErr, ok := err.(BoopError); ok {
+		return bErr.kind
+	}
+
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsKind(awsErr.Code(), awsErr.Message())
+	}
+
+	if code := grpc.Code(err); code != codes.Unknown {
+		return grpcKind(code)
+	}
+
+	if userErrorRegexp.MatchString(err.Error()) {
+		return KindUsage
+	}
+
+	return KindSystem
+}
+
+// ExitCode returns the process exit code for err.
+func ExitCode(err error) int {
+	return KindOf(err).ExitCode()
+}
+
+func grpcKind(code codes.Code) Kind {
+	switch code {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange, codes.AlreadyExists:
+		return KindUsage
+	case codes.NotFound:
+		return KindNotFound
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return KindPermission
+	case codes.Unavailable:
+		return KindUnavailable
+	case codes.DeadlineExceeded:
+		return KindTimeout
+	}
+	return KindSystem
+}
+
+var awsPermissionCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"AuthFailure":                 true,
+	"ExpiredToken":                true,
+	"InvalidClientTokenId":        true,
+	"SignatureDoesNotMatch":       true,
+	"UnauthorizedOperation":       true,
+	"UnrecognizedClientException": true,
+}
+
+func awsKind(code, message string) Kind {
+	switch {
+	case awsPermissionCodes[code]:
+		return KindPermission
+	case code == "NoSuchEntity" || strings.HasSuffix(code, "NotFound"):
+		return KindNotFound
+	case code == "ValidationError" && strings.Contains(message, "does not exist"):
+		return KindNotFound
+	case code == "RequestTimeout" || code == "RequestTimeoutException":
+		return KindTimeout
+	}
+	return KindAWS
+}
+
+// catch the usage errors cobra and pflag return as plain errors.
 // We don't want to show the usage message for every error.
-// The below may be to generic. Time will show.
-var userErrorRegexp = regexp.MustCompile("argument|flag|shorthand")
+var userErrorRegexp = regexp.MustCompile("^(unknown (command|flag|shorthand flag)|flag needs an argument|bad flag syntax|invalid argument)")
 
 func IsUserError(err error) bool {
-	if cErr, ok := err.(BoopError); ok && cErr.isUserError() {
-		return true
+	return KindOf(err) == KindUsage
+}
+
+// ShowUsage reports whether err is a usage error boop or cobra found, which
+// the command's usage helps with. Usage errors from opsee services exit the
+// same way but are the service's rejection, not a bad command line.
+func ShowUsage(err error) bool {
+	for {
+		bErr, ok := err.(BoopError)
+		if !ok {
+			break
+		}
+		if bErr.cause == nil {
+			return bErr.kind == KindUsage
+		}
+		err = bErr.cause
 	}
 
+	if err == nil || grpc.Code(err) != codes.Unknown {
+		return false
+	}
 	return userErrorRegexp.MatchString(err.Error())
 }
+
+func IsUnavailableError(err error) bool {
+	return KindOf(err) == KindUnavailable
+}
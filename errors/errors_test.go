@@ -0,0 +1,87 @@
+package errors
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestKindOf(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		kind Kind
+		exit int
+	}{
+		{NewUserError("missing user argument"), KindUsage, ExitUsage},
+		{NewSystemErrorF("boom"), KindSystem, ExitSystem},
+		{NewNotFoundErrorF("cannot find bastion: %s", "x"), KindNotFound, ExitNotFound},
+		{NewUnavailableError("cats", "cats.in.opsee.com:443", fmt.Errorf("timeout")), KindUnavailable, ExitUnavailable},
+		{fmt.Errorf("unknown flag: --nope"), KindUsage, ExitUsage},
+		{fmt.Errorf("something about an argument"), KindSystem, ExitSystem},
+		{grpc.Errorf(codes.NotFound, "user not found"), KindNotFound, ExitNotFound},
+		{grpc.Errorf(codes.PermissionDenied, "nope"), KindPermission, ExitPermission},
+		{grpc.Errorf(codes.Unauthenticated, "nope"), KindPermission, ExitPermission},
+		{grpc.Errorf(codes.Unavailable, "down"), KindUnavailable, ExitUnavailable},
+		{grpc.Errorf(codes.DeadlineExceeded, "slow"), KindTimeout, ExitTimeout},
+		{grpc.Errorf(codes.InvalidArgument, "bad"), KindUsage, ExitUsage},
+		{grpc.Errorf(codes.Internal, "oops"), KindSystem, ExitSystem},
+		{awserr.New("AccessDenied", "denied", nil), KindPermission, ExitPermission},
+		{awserr.New("UnauthorizedOperation", "denied", nil), KindPermission, ExitPermission},
+		{awserr.New("NoSuchEntity", "no policy", nil), KindNotFound, ExitNotFound},
+		{awserr.New("InvalidInstanceID.NotFound", "no instance", nil), KindNotFound, ExitNotFound},
+		{awserr.New("ValidationError", "Stack with id x does not exist", nil), KindNotFound, ExitNotFound},
+		{awserr.New("ValidationError", "No updates are to be performed.", nil), KindAWS, ExitAWS},
+		{awserr.New("RequestTimeout", "slow", nil), KindTimeout, ExitTimeout},
+		{awserr.New("Throttling", "Rate exceeded", nil), KindAWS, ExitAWS},
+		{NewServiceError("cats", "GetUser", grpc.Errorf(codes.NotFound, "user not found")), KindNotFound, ExitNotFound},
+		{Wrapf(awserr.New("AccessDenied", "denied", nil), "cannot update policy"), KindPermission, ExitPermission},
+		{Wrapf(NewUnavailableError("spanx", "spanx", fmt.Errorf("timeout")), "cannot obtain creds"), KindUnavailable, ExitUnavailable},
+	} {
+		if k := KindOf(tc.err); k != tc.kind {
+			t.Errorf("%q: expected kind %s, got %s", tc.err, tc.kind, k)
+		}
+		if c := ExitCode(tc.err); c != tc.exit {
+			t.Errorf("%q: expected exit code %d, got %d", tc.err, tc.exit, c)
+		}
+	}
+}
+
+func TestServiceErrorMessage(t *testing.T) {
+	err := NewServiceError("cats", "GetUser", grpc.Errorf(codes.NotFound, "user not found"))
+	if err.Error() != "cats GetUser failed: user not found" {
+		t.Errorf("unexpected message: %s", err)
+	}
+}
+
+func TestShowUsage(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		show bool
+	}{
+		{NewUserError("missing user argument"), true},
+		{Wrapf(NewUserError("invalid UUID"), "customer x"), true},
+		{fmt.Errorf("unknown flag: --nope"), true},
+		{grpc.Errorf(codes.FailedPrecondition, "no subnet"), false},
+		{NewServiceError("keelhaul", "LaunchStack", grpc.Errorf(codes.FailedPrecondition, "no subnet")), false},
+		{Wrapf(NewServiceError("cats", "GetUser", grpc.Errorf(codes.InvalidArgument, "bad email")), "customer x"), false},
+		{NewNotFoundErrorF("no stack"), false},
+	} {
+		if show := ShowUsage(tc.err); show != tc.show {
+			t.Errorf("%q: expected ShowUsage %v, got %v", tc.err, tc.show, show)
+		}
+	}
+
+	// the service's rejection still exits as a usage error
+	if ExitCode(NewServiceError("keelhaul", "LaunchStack", grpc.Errorf(codes.FailedPrecondition, "no subnet"))) != ExitUsage {
+		t.Error("expected a usage exit code")
+	}
+}
+
+func TestWrapfNil(t *testing.T) {
+	if err := Wrapf(nil, "cannot do it"); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+// functions available in --template
+var templateFuncs = template.FuncMap{
+	// since returns the time elapsed since a timestamp, e.g.
+	// {{if gt (since .LastSeen) (duration "1h")}}{{.Id}}{{end}}
+	"since": func(t interface{}) (time.Duration, error) {
+		tm, err := toTime(t)
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(tm), nil
+	},
+	"time":     toTime,
+	"duration": time.ParseDuration,
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// toTime converts the timestamps found in command results: go times,
+// opsee timestamps and their json form (unix milliseconds).
+func toTime(t interface{}) (time.Time, error) {
+	switch v := t.(type) {
+	case time.Time:
+		return v, nil
+	case *time.Time:
+		if v != nil {
+			return *v, nil
+		}
+	case *opsee_types.Timestamp:
+		if v != nil {
+			return time.Unix(v.Seconds, int64(v.Nanos)), nil
+		}
+	case string:
+		return time.Parse(time.RFC3339, v)
+	case float64:
+		// opsee timestamps are milliseconds in json
+		return time.Unix(0, int64(v)*int64(time.Millisecond)), nil
+	}
+
+	return time.Time{}, fmt.Errorf("not a timestamp: %v", t)
+}
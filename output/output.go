@@ -0,0 +1,325 @@
+// Package output renders command results as a table, json, yaml, csv or
+// with a go template, optionally filtered with a JMESPath query.
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/fatih/color"
+	"github.com/jmespath/go-jmespath"
+	"github.com/opsee/boop/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	Table    = "table"
+	JSON     = "json"
+	YAML     = "yaml"
+	CSV      = "csv"
+	Template = "template"
+)
+
+var Formats = []string{Table, JSON, YAML, CSV, Template}
+
+var (
+	Yellow = color.New(color.FgYellow)
+	Blue   = color.New(color.FgBlue)
+	Red    = color.New(color.FgRed)
+	Green  = color.New(color.FgGreen)
+	header = color.New(color.FgWhite)
+)
+
+// Column is a table column, cells are colored with Color in table output.
+type Column struct {
+	Name  string
+	Color *color.Color
+}
+
+// Result is what a command prints. Data is rendered as json, yaml or with
+// a template. Columns and Rows are used for table and csv output.
+type Result struct {
+	Data    interface{}
+	Columns []Column
+	Rows    [][]string
+	// Text, if set, replaces the tabular output in table format
+	Text func(w io.Writer) error
+	// omit the table header
+	NoHeader bool
+}
+
+// Renderer writes results in a single format.
+type Renderer struct {
+	Format   string
+	Template string
+	// JMESPath expression applied to the json form of the data
+	Query string
+	Out   io.Writer
+}
+
+// New returns a renderer for format. A template without a format selects
+// the template format.
+func New(format, tmpl, query string, out io.Writer) (*Renderer, error) {
+	if format == "" {
+		format = Table
+	}
+	if format == Table && tmpl != "" {
+		format = Template
+	}
+
+	valid := false
+	for _, f := range Formats {
+		valid = valid || f == format
+	}
+	if !valid {
+		return nil, errors.NewUserErrorF("unknown output format %q, use one of: %s", format, strings.Join(Formats, ", "))
+	}
+
+	if format == Template && tmpl == "" {
+		return nil, errors.NewUserError("--output template requires --template")
+	}
+	if format != Template && tmpl != "" {
+		return nil, errors.NewUserErrorF("--template can't be used with --output %s", format)
+	}
+
+	if query != "" {
+		if _, err := jmespath.Search(query, nil); err != nil {
+			return nil, errors.NewUserErrorF("invalid query %q: %s", query, err)
+		}
+	}
+
+	return &Renderer{Format: format, Template: tmpl, Query: query, Out: out}, nil
+}
+
+func (r *Renderer) Render(res *Result) error {
+	if r.Query != "" {
+		return r.renderQuery(res.Data)
+	}
+
+	switch r.Format {
+	case JSON:
+		return r.json(res.Data)
+	case YAML:
+		return r.yaml(res.Data)
+	case CSV:
+		return r.csv(res)
+	case Template:
+		return r.template(res.Data)
+	}
+
+	return r.table(res)
+}
+
+// normalize converts data to plain maps and slices via json so field names
+// are the same in every format.
+func normalize(data interface{}) (interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(b, &generic); err != nil {
+		return nil, err
+	}
+	return generic, nil
+}
+
+// renderQuery renders the result of the query. Tables and csv can't show
+// arbitrary data, so scalars and lists are printed one per line and
+// anything else as json.
+func (r *Renderer) renderQuery(data interface{}) error {
+	generic, err := normalize(data)
+	if err != nil {
+		return err
+	}
+
+	result, err := jmespath.Search(r.Query, generic)
+	if err != nil {
+		return errors.NewUserErrorF("query failed: %s", err)
+	}
+
+	switch r.Format {
+	case JSON:
+		return r.json(result)
+	case YAML:
+		return r.yaml(result)
+	case Template:
+		return r.template(result)
+	}
+
+	sep := "\t"
+	if r.Format == CSV {
+		sep = ","
+	}
+
+	items, ok := result.([]interface{})
+	if !ok {
+		items = []interface{}{result}
+	}
+
+	for _, item := range items {
+		line, err := r.plain(item, sep)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(r.Out, line)
+	}
+	return nil
+}
+
+func (r *Renderer) plain(item interface{}, sep string) (string, error) {
+	switch v := item.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		cells := make([]string, len(v))
+		for i, c := range v {
+			cell, err := r.plain(c, sep)
+			if err != nil {
+				return "", err
+			}
+			cells[i] = cell
+		}
+		return strings.Join(cells, sep), nil
+	}
+
+	b, err := json.Marshal(item)
+	return string(b), err
+}
+
+func (r *Renderer) json(data interface{}) error {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(r.Out, string(b))
+	return err
+}
+
+func (r *Renderer) yaml(data interface{}) error {
+	generic, err := normalize(data)
+	if err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(integers(generic))
+	if err != nil {
+		return err
+	}
+	_, err = r.Out.Write(b)
+	return err
+}
+
+// integers turns whole numbers back into ints after normalize, so yaml
+// doesn't print ids and timestamps in exponent form.
+func integers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1<<53 {
+			return int64(val)
+		}
+	case []interface{}:
+		for i := range val {
+			val[i] = integers(val[i])
+		}
+	case map[string]interface{}:
+		for k := range val {
+			val[k] = integers(val[k])
+		}
+	}
+	return v
+}
+
+func (r *Renderer) csv(res *Result) error {
+	w := csv.NewWriter(r.Out)
+
+	if !res.NoHeader {
+		names := make([]string, len(res.Columns))
+		for i, c := range res.Columns {
+			names[i] = c.Name
+		}
+		if err := w.Write(names); err != nil {
+			return err
+		}
+	}
+
+	if err := w.WriteAll(res.Rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// template executes the template once per item if data is a slice,
+// otherwise once for data. Each output is printed on its own line, items
+// for which the template prints nothing are skipped.
+func (r *Renderer) template(data interface{}) error {
+	t, err := template.New("output").Funcs(templateFuncs).Parse(r.Template)
+	if err != nil {
+		return errors.NewUserErrorF("invalid template: %s", err)
+	}
+
+	items := []interface{}{data}
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice {
+		items = make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = v.Index(i).Interface()
+		}
+	}
+
+	for _, item := range items {
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, item); err != nil {
+			return errors.NewUserErrorF("template failed: %s", err)
+		}
+
+		line := strings.TrimRight(buf.String(), "\n")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fmt.Fprintln(r.Out, line)
+	}
+	return nil
+}
+
+func (r *Renderer) table(res *Result) error {
+	if res.Text != nil {
+		return res.Text(r.Out)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(r.Out, 1, 0, 2, ' ', 0)
+
+	if len(res.Rows) > 0 && !res.NoHeader {
+		names := make([]string, len(res.Columns))
+		for i, c := range res.Columns {
+			names[i] = header.SprintFunc()(c.Name)
+		}
+		fmt.Fprintln(w, strings.Join(names, "\t"))
+	}
+
+	for _, row := range res.Rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = cell
+			if i < len(res.Columns) && res.Columns[i].Color != nil {
+				cells[i] = res.Columns[i].Color.SprintFunc()(cell)
+			}
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+
+	return w.Flush()
+}
@@ -0,0 +1,148 @@
+package output
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+func init() {
+	color.NoColor = true
+}
+
+func testResult() *Result {
+	states := []*schema.BastionState{
+		{Id: "b1", CustomerId: "c1", Status: "active", Region: "us-west-2"},
+		{Id: "b2", CustomerId: "c2", Status: "inactive", Region: "us-east-1",
+			LastSeen: &opsee_types.Timestamp{Seconds: 1456790400}},
+	}
+
+	res := &Result{
+		Data:    states,
+		Columns: []Column{{Name: "id", Color: Yellow}, {Name: "status"}},
+	}
+	for _, s := range states {
+		res.Rows = append(res.Rows, []string{s.Id, s.Status})
+	}
+	return res
+}
+
+func render(t *testing.T, format, tmpl string, res *Result) string {
+	return renderQuery(t, format, tmpl, "", res)
+}
+
+func renderQuery(t *testing.T, format, tmpl, query string, res *Result) string {
+	out := &bytes.Buffer{}
+	r, err := New(format, tmpl, query, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Render(res); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestRenderFormats(t *testing.T) {
+	tests := []struct {
+		format, tmpl, expected string
+	}{
+		{Table, "", "id  status\nb1  active\nb2  inactive\n"},
+		{CSV, "", "id,status\nb1,active\nb2,inactive\n"},
+		{Template, "{{.Id}} {{.Region}}", "b1 us-west-2\nb2 us-east-1\n"},
+		{Template, "{{if eq .Status \"active\"}}{{.Id}}{{end}}", "b1\n"},
+		{YAML, "", "- customer_id: c1\n  id: b1\n  region: us-west-2\n  status: active\n- customer_id: c2\n  id: b2\n  last_seen: 1456790400000\n  region: us-east-1\n  status: inactive\n"},
+		{JSON, "", `[
+  {
+    "id": "b1",
+    "customer_id": "c1",
+    "status": "active",
+    "region": "us-west-2"
+  },
+  {
+    "id": "b2",
+    "customer_id": "c2",
+    "status": "inactive",
+    "last_seen": 1456790400000,
+    "region": "us-east-1"
+  }
+]
+`},
+	}
+
+	for _, test := range tests {
+		if out := render(t, test.format, test.tmpl, testResult()); out != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.format, test.expected, out)
+		}
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	res := testResult()
+	res.Text = func(w io.Writer) error {
+		_, err := io.WriteString(w, "custom\n")
+		return err
+	}
+
+	if out := render(t, Table, "", res); out != "custom\n" {
+		t.Errorf("expected custom table output, got %q", out)
+	}
+	if out := render(t, CSV, "", res); out != "id,status\nb1,active\nb2,inactive\n" {
+		t.Errorf("expected csv rows, got %q", out)
+	}
+}
+
+func TestRenderQuery(t *testing.T) {
+	tests := []struct {
+		format, tmpl, query, expected string
+	}{
+		{Table, "", "[?status=='active'].id", "b1\n"},
+		{Table, "", "[].[id, region]", "b1\tus-west-2\nb2\tus-east-1\n"},
+		{CSV, "", "[].[id, region]", "b1,us-west-2\nb2,us-east-1\n"},
+		{JSON, "", "[].id", "[\n  \"b1\",\n  \"b2\"\n]\n"},
+		{YAML, "", "[0].{id: id, status: status}", "id: b1\nstatus: active\n"},
+		{Table, "", "[0]", `{"customer_id":"c1","id":"b1","region":"us-west-2","status":"active"}` + "\n"},
+		{Template, "{{.id}}", "[?region=='us-east-1']", "b2\n"},
+	}
+
+	for _, test := range tests {
+		if out := renderQuery(t, test.format, test.tmpl, test.query, testResult()); out != test.expected {
+			t.Errorf("%s %s: expected %q, got %q", test.format, test.query, test.expected, out)
+		}
+	}
+}
+
+func TestTemplateSince(t *testing.T) {
+	tmpl := `{{if .LastSeen}}{{if gt (since .LastSeen) (duration "1h")}}{{.Id}}{{end}}{{end}}`
+	if out := render(t, "", tmpl, testResult()); out != "b2\n" {
+		t.Errorf("expected only b2 to be stale, got %q", out)
+	}
+
+	// the json form of the timestamp works too
+	tmpl = `{{if .last_seen}}{{if gt (since .last_seen).Hours 1.0}}{{.id}}{{end}}{{end}}`
+	if out := renderQuery(t, "", tmpl, "[]", testResult()); out != "b2\n" {
+		t.Errorf("expected only b2 to be stale, got %q", out)
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	tests := []struct {
+		format, tmpl, query string
+	}{
+		{"xml", "", ""},
+		{Template, "", ""},
+		{JSON, "{{.Id}}", ""},
+		{Table, "", "[?id=="},
+	}
+
+	for _, test := range tests {
+		if _, err := New(test.format, test.tmpl, test.query, &bytes.Buffer{}); !errors.IsUserError(err) {
+			t.Errorf("%v: expected user error, got %v", test, err)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+// Package rollout plans bastion stack upgrades over many customers in waves
+// and keeps track of their progress on disk, so an interrupted rollout can
+// continue where it stopped.
+package rollout
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsee/boop/errors"
+	"github.com/opsee/boop/util"
+)
+
+// customer statuses
+const (
+	Pending    = "pending"
+	Done       = "done"
+	Failed     = "failed"
+	RolledBack = "rolled-back"
+)
+
+// DefaultWaves are the cumulative percentages of customers updated after
+// the canary wave.
+var DefaultWaves = []int{10, 50, 100}
+
+// Customer is a customer's stack in a rollout.
+type Customer struct {
+	CustomerId  string    `json:"customer_id"`
+	Email       string    `json:"email"`
+	Status      string    `json:"status"`
+	Region      string    `json:"region,omitempty"`
+	ImageId     string    `json:"image_id,omitempty"`
+	StackStatus string    `json:"stack_status,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Updated     time.Time `json:"updated,omitempty"`
+}
+
+// Wave is a group of customers updated together.
+type Wave struct {
+	Name      string      `json:"name"`
+	Customers []*Customer `json:"customers"`
+}
+
+// Remaining returns the wave's customers that aren't done.
+func (w *Wave) Remaining() []*Customer {
+	remaining := []*Customer{}
+	for _, c := range w.Customers {
+		if c.Status != Done {
+			remaining = append(remaining, c)
+		}
+	}
+	return remaining
+}
+
+// Target is what the stacks are updated to.
+type Target struct {
+	// an explicit image, used in every region
+	ImageId string `json:"image_id,omitempty"`
+	// without ImageId, the latest image of this channel in each region
+	Channel string `json:"channel,omitempty"`
+	// the template channel, the bucket's default if empty
+	TemplateChannel string `json:"template_channel,omitempty"`
+}
+
+func (t Target) String() string {
+	image := t.ImageId
+	if image == "" {
+		image = "latest " + t.Channel + " image"
+	}
+	if t.TemplateChannel != "" {
+		return image + " with the " + t.TemplateChannel + " template"
+	}
+	return image
+}
+
+// Plan is a rollout. Every change made through its methods is saved to its
+// file right away.
+type Plan struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Target  Target    `json:"target"`
+	// image by region, for a channel target resolved the first time a
+	// region comes up so all waves get the same image
+	Images map[string]string `json:"images"`
+	// sha256 of the template by region, the first one fetched there, so a
+	// template changing in its channel mid-rollout isn't rolled out
+	Templates   map[string]string `json:"templates"`
+	Waves       []*Wave           `json:"waves"`
+	Paused      bool              `json:"paused"`
+	PauseReason string            `json:"pause_reason,omitempty"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultDir is ~/.boop/rollouts
+func DefaultDir() string {
+	return filepath.Join(os.Getenv("HOME"), ".boop", "rollouts")
+}
+
+// Path returns the file of the named plan in dir.
+func Path(dir, name string) string {
+	return filepath.Join(dir, filepath.Base(name)+".json")
+}
+
+// ParseWaves parses a comma separated list of cumulative percentages, e.g.
+// "10,50,100". 100 is added if the list doesn't end with it.
+func ParseWaves(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return append([]int{}, DefaultWaves...), nil
+	}
+
+	waves := []int{}
+	last := 0
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSuffix(strings.TrimSpace(f), "%")
+		p, err := strconv.Atoi(f)
+		if err != nil || p <= last || p > 100 {
+			return nil, errors.NewUserErrorF("invalid waves %q: need increasing percentages up to 100", s)
+		}
+		waves = append(waves, p)
+		last = p
+	}
+	if last != 100 {
+		waves = append(waves, 100)
+	}
+
+	return waves, nil
+}
+
+// New splits customers into a canary wave of the first canary customers and
+// waves reaching the given cumulative percentages of all customers. Waves
+// that would be empty are left out.
+func New(name string, customers []*Customer, canary int, percents []int, target Target) (*Plan, error) {
+	if len(customers) == 0 {
+		return nil, errors.NewUserError("no customers to roll out to")
+	}
+	if target.ImageId == "" && target.Channel == "" {
+		return nil, errors.NewUserError("no image or channel to roll out")
+	}
+
+	p := &Plan{
+		Name:      name,
+		Created:   time.Now(),
+		Target:    target,
+		Images:    make(map[string]string),
+		Templates: make(map[string]string),
+		Waves:     []*Wave{},
+	}
+	for _, c := range customers {
+		c.Status = Pending
+	}
+
+	next := 0
+	if canary > 0 {
+		if canary > len(customers) {
+			canary = len(customers)
+		}
+		p.Waves = append(p.Waves, &Wave{Name: "canary", Customers: customers[:canary]})
+		next = canary
+	}
+
+	for _, percent := range percents {
+		end := (len(customers)*percent + 99) / 100
+		if end <= next {
+			continue
+		}
+		p.Waves = append(p.Waves, &Wave{Name: fmt.Sprintf("%d%%", percent), Customers: customers[next:end]})
+		next = end
+	}
+	if next < len(customers) {
+		p.Waves = append(p.Waves, &Wave{Name: "100%", Customers: customers[next:]})
+	}
+
+	return p, nil
+}
+
+// Load reads a plan saved with Save.
+func Load(path string) (*Plan, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, errors.NewNotFoundErrorF("no rollout plan at %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Plan{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, errors.NewSystemErrorF("invalid rollout plan %s: %s", path, err)
+	}
+	if p.Images == nil {
+		p.Images = make(map[string]string)
+	}
+	if p.Templates == nil {
+		p.Templates = make(map[string]string)
+	}
+	p.path = path
+
+	return p, nil
+}
+
+// Save writes the plan to path, which later changes are saved to as well.
+func (p *Plan) Save(path string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.path = path
+	return p.save()
+}
+
+// Update calls f, which changes customer c, and saves the plan.
+func (p *Plan) Update(c *Customer, f func(c *Customer)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	f(c)
+	c.Updated = time.Now()
+	return p.save()
+}
+
+// Image returns the image for region, resolving it with latest and
+// remembering it if the plan targets a channel.
+func (p *Plan) Image(region string, latest func(region, channel string) (string, error)) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Target.ImageId != "" {
+		return p.Target.ImageId, nil
+	}
+	if image, ok := p.Images[region]; ok {
+		return image, nil
+	}
+
+	image, err := latest(region, p.Target.Channel)
+	if err != nil {
+		return "", err
+	}
+	p.Images[region] = image
+	return image, p.save()
+}
+
+// Template returns the template for region from fetch and checks it's the
+// one the rollout used there before, remembering its sha256 the first time.
+// The plan isn't locked during fetch, so customers in other regions don't
+// wait for it.
+func (p *Plan) Template(region string, fetch func(region, channel string) ([]byte, error)) ([]byte, error) {
+	p.mu.Lock()
+	channel := p.Target.TemplateChannel
+	p.mu.Unlock()
+
+	b, err := fetch(region, channel)
+	if err != nil {
+		return nil, err
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256(b))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pinned, ok := p.Templates[region]
+	if !ok {
+		p.Templates[region] = sum
+		return b, p.save()
+	}
+	if sum != pinned {
+		return nil, errors.NewSystemErrorF("template in %s changed since the rollout started (sha256 %s, was %s)", region, sum, pinned)
+	}
+	return b, nil
+}
+
+// Pause stops the rollout until Resume is called.
+func (p *Plan) Pause(reason string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Paused = true
+	p.PauseReason = reason
+	return p.save()
+}
+
+func (p *Plan) Resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Paused = false
+	p.PauseReason = ""
+	return p.save()
+}
+
+// Done reports whether all customers are done.
+func (p *Plan) Done() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, w := range p.Waves {
+		if len(w.Remaining()) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes the plan and renames it into place, so an interrupted boop
+// never leaves a partial plan behind. Callers hold mu.
+func (p *Plan) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return util.WriteFileAtomic(p.path, b)
+}
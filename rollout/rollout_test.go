@@ -0,0 +1,216 @@
+package rollout
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func customers(n int) []*Customer {
+	cs := []*Customer{}
+	for i := 0; i < n; i++ {
+		cs = append(cs, &Customer{CustomerId: fmt.Sprintf("c%d", i), Email: fmt.Sprintf("c%d@isis.com", i)})
+	}
+	return cs
+}
+
+func waveSizes(p *Plan) map[string]int {
+	sizes := make(map[string]int)
+	for _, w := range p.Waves {
+		sizes[w.Name] = len(w.Customers)
+	}
+	return sizes
+}
+
+func TestParseWaves(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		expected []int
+		invalid  bool
+	}{
+		{"", DefaultWaves, false},
+		{"10,50,100", []int{10, 50, 100}, false},
+		{"25%, 50%", []int{25, 50, 100}, false},
+		{"50,10", nil, true},
+		{"10,x", nil, true},
+		{"150", nil, true},
+	} {
+		waves, err := ParseWaves(test.in)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("expected error for %q", test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", test.in, err)
+		}
+		if !reflect.DeepEqual(waves, test.expected) {
+			t.Errorf("expected %v for %q, got %v", test.expected, test.in, waves)
+		}
+	}
+}
+
+func TestNewWaves(t *testing.T) {
+	p, err := New("test", customers(20), 1, []int{10, 50, 100}, Target{Channel: "stable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{"canary": 1, "10%": 1, "50%": 8, "100%": 10}
+	if sizes := waveSizes(p); !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected waves %v, got %v", expected, sizes)
+	}
+	if p.Waves[0].Customers[0].CustomerId != "c0" || p.Waves[0].Customers[0].Status != Pending {
+		t.Errorf("unexpected canary %+v", p.Waves[0].Customers[0])
+	}
+
+	// waves already covered by the canary are left out
+	p, err = New("test", customers(3), 2, []int{10, 50, 100}, Target{ImageId: "ami-new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = map[string]int{"canary": 2, "100%": 1}
+	if sizes := waveSizes(p); !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected waves %v, got %v", expected, sizes)
+	}
+
+	if _, err := New("test", nil, 1, DefaultWaves, Target{ImageId: "ami-new"}); err == nil {
+		t.Error("expected error for no customers")
+	}
+	if _, err := New("test", customers(1), 1, DefaultWaves, Target{}); err == nil {
+		t.Error("expected error for no target")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := Path(t.TempDir(), "test")
+	p, err := New("test", customers(2), 1, DefaultWaves, Target{Channel: "stable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	resolved := 0
+	latest := func(region, channel string) (string, error) {
+		resolved++
+		return "ami-" + region + "-" + channel, nil
+	}
+	for i := 0; i < 2; i++ {
+		image, err := p.Image("us-west-2", latest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if image != "ami-us-west-2-stable" {
+			t.Errorf("unexpected image %s", image)
+		}
+	}
+	if resolved != 1 {
+		t.Errorf("expected the image to be resolved once, got %d", resolved)
+	}
+
+	c := p.Waves[0].Customers[0]
+	if err := p.Update(c, func(c *Customer) { c.Status = Done }); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Pause("because"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Paused || loaded.PauseReason != "because" {
+		t.Errorf("expected a paused plan, got %+v", loaded)
+	}
+	if loaded.Images["us-west-2"] != "ami-us-west-2-stable" {
+		t.Errorf("expected the resolved image to be saved, got %v", loaded.Images)
+	}
+	if len(loaded.Waves[0].Remaining()) != 0 || len(loaded.Waves[1].Remaining()) != 1 || loaded.Done() {
+		t.Errorf("unexpected progress: %+v", loaded.Waves)
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected error for missing plan")
+	}
+}
+
+func TestTemplatePinned(t *testing.T) {
+	p, err := New("test", customers(2), 1, DefaultWaves, Target{ImageId: "ami-new", TemplateChannel: "beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := "v1"
+	fetch := func(region, channel string) ([]byte, error) {
+		return []byte(region + " " + channel + " " + template), nil
+	}
+	for i := 0; i < 2; i++ {
+		b, err := p.Template("us-west-2", fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "us-west-2 beta v1" {
+			t.Errorf("unexpected template %q", b)
+		}
+	}
+	if len(p.Templates) != 1 {
+		t.Errorf("expected the us-west-2 template to be pinned, got %v", p.Templates)
+	}
+
+	template = "v2"
+	if _, err := p.Template("us-west-2", fetch); err == nil {
+		t.Error("expected error for a changed template")
+	}
+	// other regions pin their own
+	if _, err := p.Template("us-east-1", fetch); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTemplateFetchUnlocked(t *testing.T) {
+	p, err := New("test", customers(2), 1, DefaultWaves, Target{ImageId: "ami-new", TemplateChannel: "beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the us-west-2 fetch only finishes once the us-east-1 one started
+	started := make(chan struct{})
+	fetch := func(region, channel string) ([]byte, error) {
+		if region == "us-west-2" {
+			<-started
+		} else {
+			close(started)
+		}
+		return []byte(region), nil
+	}
+
+	done := make(chan error, 2)
+	template := func(region string) {
+		_, err := p.Template(region, fetch)
+		done <- err
+	}
+	go template("us-west-2")
+	// let the us-west-2 fetch start first
+	time.Sleep(10 * time.Millisecond)
+	go template("us-east-1")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("us-west-2 template fetch blocked the plan")
+		}
+	}
+	if len(p.Templates) != 2 {
+		t.Errorf("expected both templates to be pinned, got %v", p.Templates)
+	}
+}
@@ -0,0 +1,113 @@
+package svc
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/keelhaul/scanner"
+)
+
+// EC2 is the part of the EC2 API used by boop.
+type EC2 interface {
+	DescribeInstances(*ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+	DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error)
+	RebootInstances(*ec2.RebootInstancesInput) (*ec2.RebootInstancesOutput, error)
+	TerminateInstances(*ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error)
+	DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error)
+	DescribeNetworkInterfaces(*ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error)
+}
+
+// CloudFormation is the part of the CloudFormation API used by boop.
+type CloudFormation interface {
+	DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error)
+	DescribeStackEvents(*cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error)
+	UpdateStack(*cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error)
+	DeleteStack(*cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error)
+	ListStackResources(*cloudformation.ListStackResourcesInput) (*cloudformation.ListStackResourcesOutput, error)
+	CancelUpdateStack(*cloudformation.CancelUpdateStackInput) (*cloudformation.CancelUpdateStackOutput, error)
+	ContinueUpdateRollback(*ContinueUpdateRollbackInput) (*cloudformation.ContinueUpdateRollbackOutput, error)
+	GetTemplate(*cloudformation.GetTemplateInput) (*cloudformation.GetTemplateOutput, error)
+	ValidateTemplate(*cloudformation.ValidateTemplateInput) (*cloudformation.ValidateTemplateOutput, error)
+	CreateChangeSet(*CreateChangeSetInput) (*CreateChangeSetOutput, error)
+	DescribeChangeSet(*DescribeChangeSetInput) (*DescribeChangeSetOutput, error)
+	ExecuteChangeSet(*ExecuteChangeSetInput) (*ExecuteChangeSetOutput, error)
+	DeleteChangeSet(*DeleteChangeSetInput) (*DeleteChangeSetOutput, error)
+}
+
+// IAM is the part of the IAM API used by boop.
+type IAM interface {
+	GetRolePolicy(*iam.GetRolePolicyInput) (*iam.GetRolePolicyOutput, error)
+	PutRolePolicy(*iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error)
+}
+
+// AWS creates per region clients for a set of credentials.
+type AWS interface {
+	EC2(creds *credentials.Credentials, region string) EC2
+	CloudFormation(creds *credentials.Credentials, region string) CloudFormation
+	IAM(creds *credentials.Credentials, region string) IAM
+	ScanRegion(creds *credentials.Credentials, region string) (*schema.Region, error)
+}
+
+// awsClients shares one session per access key and region between the
+// clients it creates. Callers build new credentials for every lookup, so
+// sessions can't be told apart by the credentials themselves.
+type awsClients struct {
+	sessions map[sessionKey]*session.Session
+	mu       sync.Mutex
+}
+
+type sessionKey struct {
+	accessKeyID string
+	region      string
+}
+
+// NewAWS returns an AWS backed by the real AWS APIs.
+func NewAWS() AWS {
+	return &awsClients{sessions: make(map[sessionKey]*session.Session)}
+}
+
+func (a *awsClients) session(creds *credentials.Credentials, region string) *session.Session {
+	config := aws.NewConfig().WithCredentials(creds).WithRegion(region)
+
+	key := sessionKey{region: region}
+	if creds != nil {
+		v, err := creds.Get()
+		if err != nil {
+			// the session's requests fail with the same error
+			return session.New(config)
+		}
+		key.accessKeyID = v.AccessKeyID
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	sess, ok := a.sessions[key]
+	if !ok {
+		sess = session.New(config)
+		a.sessions[key] = sess
+	}
+	return sess
+}
+
+func (a *awsClients) EC2(creds *credentials.Credentials, region string) EC2 {
+	return ec2.New(a.session(creds, region), aws.NewConfig().WithMaxRetries(3))
+}
+
+func (a *awsClients) CloudFormation(creds *credentials.Credentials, region string) CloudFormation {
+	return &cfnClient{cloudformation.New(a.session(creds, region), aws.NewConfig().WithMaxRetries(10))}
+}
+
+func (a *awsClients) IAM(creds *credentials.Credentials, region string) IAM {
+	return iam.New(a.session(creds, region), aws.NewConfig().WithMaxRetries(5))
+}
+
+func (a *awsClients) ScanRegion(creds *credentials.Credentials, region string) (*schema.Region, error) {
+	return scanner.ScanRegion(region, a.session(creds, region).Copy(aws.NewConfig().WithMaxRetries(5)))
+}
@@ -0,0 +1,31 @@
+package svc
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestAWSSessionsByAccessKey(t *testing.T) {
+	a := NewAWS().(*awsClients)
+
+	sess := a.session(credentials.NewStaticCredentials("AKID1", "secret", ""), "us-west-2")
+	if a.session(credentials.NewStaticCredentials("AKID1", "secret", ""), "us-west-2") != sess {
+		t.Error("expected new credentials with the same access key to share a session")
+	}
+	if a.session(credentials.NewStaticCredentials("AKID1", "secret", ""), "us-east-1") == sess {
+		t.Error("expected a session per region")
+	}
+	if a.session(credentials.NewStaticCredentials("AKID2", "secret", ""), "us-west-2") == sess {
+		t.Error("expected a session per access key")
+	}
+	if len(a.sessions) != 3 {
+		t.Errorf("expected 3 sessions, got %d", len(a.sessions))
+	}
+
+	// credentials that can't be resolved aren't cached
+	a.session(credentials.NewStaticCredentials("", "", ""), "us-west-2")
+	if len(a.sessions) != 3 {
+		t.Errorf("expected 3 sessions, got %d", len(a.sessions))
+	}
+}
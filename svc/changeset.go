@@ -0,0 +1,139 @@
+package svc
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// The vendored aws-sdk-go predates CloudFormation change sets. The shapes
+// below follow the CloudFormation API reference and are sent through the
+// SDK's query protocol handlers like any generated operation.
+
+type CreateChangeSetInput struct {
+	_ struct{} `type:"structure"`
+
+	ChangeSetName       *string                     `type:"string" required:"true"`
+	StackName           *string                     `type:"string" required:"true"`
+	Description         *string                     `type:"string"`
+	TemplateBody        *string                     `type:"string"`
+	TemplateURL         *string                     `type:"string"`
+	UsePreviousTemplate *bool                       `type:"boolean"`
+	Parameters          []*cloudformation.Parameter `type:"list"`
+	Capabilities        []*string                   `type:"list"`
+}
+
+type CreateChangeSetOutput struct {
+	_ struct{} `type:"structure"`
+
+	Id *string `type:"string"`
+}
+
+type DescribeChangeSetInput struct {
+	_ struct{} `type:"structure"`
+
+	ChangeSetName *string `type:"string" required:"true"`
+	StackName     *string `type:"string"`
+	NextToken     *string `type:"string"`
+}
+
+type DescribeChangeSetOutput struct {
+	_ struct{} `type:"structure"`
+
+	ChangeSetId     *string                     `type:"string"`
+	ChangeSetName   *string                     `type:"string"`
+	StackName       *string                     `type:"string"`
+	Status          *string                     `type:"string"`
+	StatusReason    *string                     `type:"string"`
+	ExecutionStatus *string                     `type:"string"`
+	CreationTime    *time.Time                  `type:"timestamp" timestampFormat:"iso8601"`
+	Parameters      []*cloudformation.Parameter `type:"list"`
+	Changes         []*Change                   `type:"list"`
+	NextToken       *string                     `type:"string"`
+}
+
+// Change is a change to one resource in a change set.
+type Change struct {
+	_ struct{} `type:"structure"`
+
+	Type           *string         `type:"string"`
+	ResourceChange *ResourceChange `type:"structure"`
+}
+
+type ResourceChange struct {
+	_ struct{} `type:"structure"`
+
+	// Add, Modify or Remove
+	Action             *string `type:"string"`
+	LogicalResourceId  *string `type:"string"`
+	PhysicalResourceId *string `type:"string"`
+	ResourceType       *string `type:"string"`
+	// True, False or Conditional
+	Replacement *string   `type:"string"`
+	Scope       []*string `type:"list"`
+}
+
+type ExecuteChangeSetInput struct {
+	_ struct{} `type:"structure"`
+
+	ChangeSetName *string `type:"string" required:"true"`
+	StackName     *string `type:"string"`
+}
+
+type ExecuteChangeSetOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+type DeleteChangeSetInput struct {
+	_ struct{} `type:"structure"`
+
+	ChangeSetName *string `type:"string" required:"true"`
+	StackName     *string `type:"string"`
+}
+
+type DeleteChangeSetOutput struct {
+	_ struct{} `type:"structure"`
+}
+
+// change set statuses
+const (
+	ChangeSetStatusCreatePending    = "CREATE_PENDING"
+	ChangeSetStatusCreateInProgress = "CREATE_IN_PROGRESS"
+	ChangeSetStatusCreateComplete   = "CREATE_COMPLETE"
+	ChangeSetStatusFailed           = "FAILED"
+)
+
+// cfnClient adds change sets to the SDK's CloudFormation client.
+type cfnClient struct {
+	*cloudformation.CloudFormation
+}
+
+func (c *cfnClient) send(name string, in, out interface{}) error {
+	op := &request.Operation{
+		Name:       name,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	return c.NewRequest(op, in, out).Send()
+}
+
+func (c *cfnClient) CreateChangeSet(in *CreateChangeSetInput) (*CreateChangeSetOutput, error) {
+	out := &CreateChangeSetOutput{}
+	return out, c.send("CreateChangeSet", in, out)
+}
+
+func (c *cfnClient) DescribeChangeSet(in *DescribeChangeSetInput) (*DescribeChangeSetOutput, error) {
+	out := &DescribeChangeSetOutput{}
+	return out, c.send("DescribeChangeSet", in, out)
+}
+
+func (c *cfnClient) ExecuteChangeSet(in *ExecuteChangeSetInput) (*ExecuteChangeSetOutput, error) {
+	out := &ExecuteChangeSetOutput{}
+	return out, c.send("ExecuteChangeSet", in, out)
+}
+
+func (c *cfnClient) DeleteChangeSet(in *DeleteChangeSetInput) (*DeleteChangeSetOutput, error) {
+	out := &DeleteChangeSetOutput{}
+	return out, c.send("DeleteChangeSet", in, out)
+}
@@ -0,0 +1,123 @@
+package svc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+const describeChangeSetResponse = `<DescribeChangeSetResponse xmlns="http://cloudformation.amazonaws.com/doc/2010-05-15/">
+  <DescribeChangeSetResult>
+    <ChangeSetName>boop-1</ChangeSetName>
+    <StackName>opsee-stack</StackName>
+    <Status>CREATE_COMPLETE</Status>
+    <ExecutionStatus>AVAILABLE</ExecutionStatus>
+    <CreationTime>2016-03-01T00:00:00.000Z</CreationTime>
+    <Parameters>
+      <member>
+        <ParameterKey>ImageId</ParameterKey>
+        <ParameterValue>ami-new</ParameterValue>
+      </member>
+    </Parameters>
+    <Changes>
+      <member>
+        <Type>Resource</Type>
+        <ResourceChange>
+          <Action>Modify</Action>
+          <LogicalResourceId>BastionInstance</LogicalResourceId>
+          <ResourceType>AWS::EC2::Instance</ResourceType>
+          <Replacement>True</Replacement>
+          <Scope>
+            <member>Properties</member>
+          </Scope>
+        </ResourceChange>
+      </member>
+    </Changes>
+  </DescribeChangeSetResult>
+  <ResponseMetadata><RequestId>1</RequestId></ResponseMetadata>
+</DescribeChangeSetResponse>`
+
+func TestChangeSetRequests(t *testing.T) {
+	forms := []url.Values{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		forms = append(forms, r.PostForm)
+
+		switch r.PostForm.Get("Action") {
+		case "CreateChangeSet":
+			fmt.Fprint(w, `<CreateChangeSetResponse><CreateChangeSetResult><Id>arn:changeSet/boop-1</Id></CreateChangeSetResult></CreateChangeSetResponse>`)
+		case "DescribeChangeSet":
+			fmt.Fprint(w, describeChangeSetResponse)
+		default:
+			fmt.Fprintf(w, `<%sResponse></%sResponse>`, r.PostForm.Get("Action"), r.PostForm.Get("Action"))
+		}
+	}))
+	defer server.Close()
+
+	sess := session.New(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("AKIAFAKE", "secret", "")).
+		WithRegion("us-west-2").
+		WithEndpoint(server.URL).
+		WithMaxRetries(0))
+	client := &cfnClient{cloudformation.New(sess)}
+
+	created, err := client.CreateChangeSet(&CreateChangeSetInput{
+		ChangeSetName: aws.String("boop-1"),
+		StackName:     aws.String("opsee-stack"),
+		TemplateBody:  aws.String("{}"),
+		Capabilities:  []*string{aws.String("CAPABILITY_IAM")},
+		Parameters: []*cloudformation.Parameter{
+			{ParameterKey: aws.String("ImageId"), ParameterValue: aws.String("ami-new")},
+			{ParameterKey: aws.String("VpcId"), UsePreviousValue: aws.Bool(true)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(created.Id) != "arn:changeSet/boop-1" {
+		t.Errorf("unexpected change set id %s", aws.StringValue(created.Id))
+	}
+
+	form := forms[0]
+	for key, expected := range map[string]string{
+		"Action":                               "CreateChangeSet",
+		"ChangeSetName":                        "boop-1",
+		"Capabilities.member.1":                "CAPABILITY_IAM",
+		"Parameters.member.1.ParameterKey":     "ImageId",
+		"Parameters.member.1.ParameterValue":   "ami-new",
+		"Parameters.member.2.UsePreviousValue": "true",
+	} {
+		if form.Get(key) != expected {
+			t.Errorf("expected %s=%s, got %q", key, expected, form.Get(key))
+		}
+	}
+
+	desc, err := client.DescribeChangeSet(&DescribeChangeSetInput{ChangeSetName: aws.String("boop-1"), StackName: aws.String("opsee-stack")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(desc.Status) != ChangeSetStatusCreateComplete || len(desc.Parameters) != 1 || len(desc.Changes) != 1 {
+		t.Fatalf("unexpected change set %+v", desc)
+	}
+	rc := desc.Changes[0].ResourceChange
+	if aws.StringValue(rc.LogicalResourceId) != "BastionInstance" || aws.StringValue(rc.Replacement) != "True" || len(rc.Scope) != 1 {
+		t.Errorf("unexpected resource change %+v", rc)
+	}
+
+	if _, err := client.ExecuteChangeSet(&ExecuteChangeSetInput{ChangeSetName: aws.String("boop-1"), StackName: aws.String("opsee-stack")}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.DeleteChangeSet(&DeleteChangeSetInput{ChangeSetName: aws.String("boop-1"), StackName: aws.String("opsee-stack")}); err != nil {
+		t.Fatal(err)
+	}
+	if forms[2].Get("Action") != "ExecuteChangeSet" || forms[3].Get("Action") != "DeleteChangeSet" {
+		t.Errorf("unexpected actions %s, %s", forms[2].Get("Action"), forms[3].Get("Action"))
+	}
+}
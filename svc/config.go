@@ -0,0 +1,173 @@
+package svc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+	grpc_credentials "google.golang.org/grpc/credentials"
+)
+
+const DefaultProfile = "production"
+
+// all services of the local profile are served from a single address
+const LocalAddress = "127.0.0.1:9099"
+
+// ServiceConfig describes how to reach a single opsee service.
+type ServiceConfig struct {
+	Address string
+	// dial timeout
+	Timeout time.Duration
+	// per-request timeout, no timeout if zero
+	RequestTimeout time.Duration
+	CAFile         string
+	CertFile       string
+	KeyFile        string
+	// skip server certificate verification
+	Insecure bool
+	// don't use TLS at all
+	Plaintext bool
+}
+
+// Profile is a named set of service endpoints, e.g. production or local.
+type Profile struct {
+	Name     string
+	Cats     ServiceConfig
+	Spanx    ServiceConfig
+	Keelhaul ServiceConfig
+}
+
+// built in profiles, may be overridden or extended in the config file under profiles.<name>
+var Profiles = map[string]Profile{
+	"production": {
+		Name:     "production",
+		Cats:     ServiceConfig{Address: "cats.in.opsee.com:443", Timeout: tcpTimeout},
+		Spanx:    ServiceConfig{Address: "spanx.in.opsee.com:8443", Timeout: tcpTimeout},
+		Keelhaul: ServiceConfig{Address: "keelhaul.in.opsee.com:443", Timeout: tcpTimeout},
+	},
+	"local": {
+		Name:     "local",
+		Cats:     ServiceConfig{Address: LocalAddress, Timeout: tcpTimeout, Plaintext: true},
+		Spanx:    ServiceConfig{Address: LocalAddress, Timeout: tcpTimeout, Plaintext: true},
+		Keelhaul: ServiceConfig{Address: LocalAddress, Timeout: tcpTimeout, Plaintext: true},
+	},
+}
+
+// LoadProfile returns the named profile. Built in profiles are used as
+// defaults for any setting not found in the config file.
+func LoadProfile(name string) (*Profile, error) {
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	profile, builtin := Profiles[name]
+	key := "profiles." + name
+	if !builtin && !viper.IsSet(key) {
+		return nil, errors.NewUserErrorF("unknown profile: %s", name)
+	}
+
+	profile.Name = name
+	profile.Cats = loadServiceConfig(key+".cats", profile.Cats)
+	profile.Spanx = loadServiceConfig(key+".spanx", profile.Spanx)
+	profile.Keelhaul = loadServiceConfig(key+".keelhaul", profile.Keelhaul)
+
+	for _, s := range []struct {
+		name string
+		cfg  ServiceConfig
+	}{
+		{"cats", profile.Cats},
+		{"spanx", profile.Spanx},
+		{"keelhaul", profile.Keelhaul},
+	} {
+		if s.cfg.Address == "" {
+			return nil, errors.NewUserErrorF("profile %s has no address for %s (set %s.%s.address)", name, s.name, key, s.name)
+		}
+	}
+
+	return &profile, nil
+}
+
+func loadServiceConfig(key string, cfg ServiceConfig) ServiceConfig {
+	if viper.IsSet(key + ".address") {
+		cfg.Address = viper.GetString(key + ".address")
+	}
+	if viper.IsSet(key + ".timeout") {
+		cfg.Timeout = viper.GetDuration(key + ".timeout")
+	}
+	if viper.IsSet(key + ".request_timeout") {
+		cfg.RequestTimeout = viper.GetDuration(key + ".request_timeout")
+	}
+	if viper.IsSet(key + ".ca") {
+		cfg.CAFile = viper.GetString(key + ".ca")
+	}
+	if viper.IsSet(key + ".cert") {
+		cfg.CertFile = viper.GetString(key + ".cert")
+	}
+	if viper.IsSet(key + ".key") {
+		cfg.KeyFile = viper.GetString(key + ".key")
+	}
+	if viper.IsSet(key + ".insecure") {
+		cfg.Insecure = viper.GetBool(key + ".insecure")
+	}
+	if viper.IsSet(key + ".plaintext") {
+		cfg.Plaintext = viper.GetBool(key + ".plaintext")
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = tcpTimeout
+	}
+
+	return cfg
+}
+
+func (c ServiceConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (c ServiceConfig) dial() (*grpc.ClientConn, error) {
+	opts := []grpc.DialOption{
+		grpc.WithTimeout(c.Timeout),
+		grpc.WithBlock(),
+	}
+
+	if c.Plaintext {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		tlsConfig, err := c.tlsConfig()
+		if err != nil {
+			return nil, errors.NewUserErrorF("invalid TLS settings for %s: %s", c.Address, err)
+		}
+		opts = append(opts, grpc.WithTransportCredentials(grpc_credentials.NewTLS(tlsConfig)))
+	}
+
+	return grpc.Dial(c.Address, opts...)
+}
@@ -0,0 +1,860 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/svc"
+)
+
+// AWS is an in-memory svc.AWS. Calls against regions that haven't been
+// populated behave like empty regions.
+type AWS struct {
+	Regions map[string]*Region
+	// returned by DescribeRegions, svc.DefaultRegions if empty
+	RegionNames []string
+	// role name -> policy name -> policy document
+	RolePolicies map[string]map[string]string
+
+	// numbers stack event ids
+	events int
+	mu     sync.Mutex
+}
+
+// Region holds the fake resources of one region and records mutating calls.
+type Region struct {
+	Instances         []*ec2.Instance
+	Images            []*ec2.Image
+	NetworkInterfaces []*ec2.NetworkInterface
+	Stacks            []*cloudformation.Stack
+	// stack name -> resources
+	StackResources map[string][]*cloudformation.StackResourceSummary
+	// logical id -> reason DeleteStack fails to delete the resource
+	DeleteFailures map[string]string
+	// stack name -> events, newest first
+	StackEvents map[string][]*cloudformation.StackEvent
+	// events per DescribeStackEvents page, all if 0
+	EventPageSize int
+	Scan          *schema.Region
+	// returned from every call in the region when set
+	Err error
+	// status stacks end up in after UpdateStack, UPDATE_COMPLETE if empty
+	UpdateStatus string
+	// reason of the resource failure behind a rolled back update
+	UpdateFailure string
+	// DescribeStacks calls that still return a stack's status from before an
+	// update, deletion or rollback, while its events show up right away
+	StatusDelay int
+	// stack id -> stack as it was before its last operation
+	stale map[string]*staleStack
+	// stack name -> template body returned by GetTemplate
+	Templates map[string]string
+	// changes reported for every change set
+	Changes []*svc.Change
+	// change sets by name
+	ChangeSets map[string]*ChangeSet
+
+	// number of calls by API method
+	Calls map[string]int
+
+	Rebooted     []string
+	Terminated   []string
+	StackUpdates []*cloudformation.UpdateStackInput
+	// resources skipped by ContinueUpdateRollback
+	SkippedResources []string
+	// executed change sets
+	Executed []string
+}
+
+// staleStack is returned by DescribeStacks for the remaining calls of
+// StatusDelay.
+type staleStack struct {
+	stack *cloudformation.Stack
+	calls int
+}
+
+// ChangeSet is a fake change set, executing it updates the stack like
+// UpdateStack.
+type ChangeSet struct {
+	Input  *svc.CreateChangeSetInput
+	Status string
+}
+
+func NewAWS() *AWS {
+	return &AWS{
+		Regions:      make(map[string]*Region),
+		RolePolicies: make(map[string]map[string]string),
+	}
+}
+
+// Region returns the named region, creating it if necessary.
+func (a *AWS) Region(name string) *Region {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.region(name)
+}
+
+func (a *AWS) region(name string) *Region {
+	r, ok := a.Regions[name]
+	if !ok {
+		r = &Region{StackEvents: make(map[string][]*cloudformation.StackEvent)}
+		a.Regions[name] = r
+	}
+	if r.StackEvents == nil {
+		r.StackEvents = make(map[string][]*cloudformation.StackEvent)
+	}
+	if r.StackResources == nil {
+		r.StackResources = make(map[string][]*cloudformation.StackResourceSummary)
+	}
+	if r.Templates == nil {
+		r.Templates = make(map[string]string)
+	}
+	if r.ChangeSets == nil {
+		r.ChangeSets = make(map[string]*ChangeSet)
+	}
+	if r.Calls == nil {
+		r.Calls = make(map[string]int)
+	}
+	if r.stale == nil {
+		r.stale = make(map[string]*staleStack)
+	}
+	return r
+}
+
+// call returns the named region after counting a call to method.
+func (a *AWS) call(name, method string) *Region {
+	r := a.region(name)
+	r.Calls[method]++
+	return r
+}
+
+func (a *AWS) EC2(creds *credentials.Credentials, region string) svc.EC2 {
+	return &ec2Client{aws: a, region: region}
+}
+
+func (a *AWS) CloudFormation(creds *credentials.Credentials, region string) svc.CloudFormation {
+	return &cfnClient{aws: a, region: region}
+}
+
+func (a *AWS) IAM(creds *credentials.Credentials, region string) svc.IAM {
+	return &iamClient{aws: a}
+}
+
+func (a *AWS) ScanRegion(creds *credentials.Credentials, region string) (*schema.Region, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	r := a.call(region, "ScanRegion")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.Scan == nil {
+		return &schema.Region{Region: region}, nil
+	}
+	return r.Scan, nil
+}
+
+type ec2Client struct {
+	aws    *AWS
+	region string
+}
+
+func (c *ec2Client) DescribeInstances(in *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	out := &ec2.DescribeInstancesOutput{}
+	r := c.aws.call(c.region, "DescribeInstances")
+	if r.Err != nil {
+		return out, r.Err
+	}
+
+	res := &ec2.Reservation{}
+	for _, i := range r.Instances {
+		if len(in.InstanceIds) > 0 && !contains(aws.StringValueSlice(in.InstanceIds), aws.StringValue(i.InstanceId)) {
+			continue
+		}
+		if !matchesEC2Filters(i.Tags, in.Filters, map[string]string{
+			"instance-id":         aws.StringValue(i.InstanceId),
+			"instance-state-name": aws.StringValue(i.State.Name),
+			"vpc-id":              aws.StringValue(i.VpcId),
+		}) {
+			continue
+		}
+		res.Instances = append(res.Instances, i)
+	}
+	if len(res.Instances) > 0 {
+		out.Reservations = []*ec2.Reservation{res}
+	}
+
+	return out, nil
+}
+
+func (c *ec2Client) DescribeImages(in *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	out := &ec2.DescribeImagesOutput{}
+	r := c.aws.call(c.region, "DescribeImages")
+	if r.Err != nil {
+		return out, r.Err
+	}
+
+	for _, i := range r.Images {
+		if matchesEC2Filters(i.Tags, in.Filters, map[string]string{"image-id": aws.StringValue(i.ImageId)}) {
+			out.Images = append(out.Images, i)
+		}
+	}
+
+	return out, nil
+}
+
+func (c *ec2Client) DescribeNetworkInterfaces(in *ec2.DescribeNetworkInterfacesInput) (*ec2.DescribeNetworkInterfacesOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	out := &ec2.DescribeNetworkInterfacesOutput{}
+	r := c.aws.call(c.region, "DescribeNetworkInterfaces")
+	if r.Err != nil {
+		return out, r.Err
+	}
+
+	for _, ni := range r.NetworkInterfaces {
+		groups := []string{}
+		for _, g := range ni.Groups {
+			groups = append(groups, aws.StringValue(g.GroupId))
+		}
+		inGroup := true
+		for _, f := range in.Filters {
+			if aws.StringValue(f.Name) == "group-id" {
+				inGroup = false
+				for _, g := range aws.StringValueSlice(f.Values) {
+					if contains(groups, g) {
+						inGroup = true
+					}
+				}
+			}
+		}
+		if inGroup && matchesEC2Filters(ni.TagSet, in.Filters, map[string]string{
+			"network-interface-id": aws.StringValue(ni.NetworkInterfaceId),
+			"vpc-id":               aws.StringValue(ni.VpcId),
+		}) {
+			out.NetworkInterfaces = append(out.NetworkInterfaces, ni)
+		}
+	}
+
+	return out, nil
+}
+
+func (c *ec2Client) RebootInstances(in *ec2.RebootInstancesInput) (*ec2.RebootInstancesOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "RebootInstances")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if err := r.checkInstances(in.InstanceIds); err != nil {
+		return nil, err
+	}
+	r.Rebooted = append(r.Rebooted, aws.StringValueSlice(in.InstanceIds)...)
+
+	return &ec2.RebootInstancesOutput{}, nil
+}
+
+func (c *ec2Client) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "TerminateInstances")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if err := r.checkInstances(in.InstanceIds); err != nil {
+		return nil, err
+	}
+
+	ids := aws.StringValueSlice(in.InstanceIds)
+	for _, i := range r.Instances {
+		if contains(ids, aws.StringValue(i.InstanceId)) {
+			i.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameShuttingDown)}
+		}
+	}
+	r.Terminated = append(r.Terminated, ids...)
+
+	return &ec2.TerminateInstancesOutput{}, nil
+}
+
+func (c *ec2Client) DescribeRegions(in *ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "DescribeRegions")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	names := c.aws.RegionNames
+	if len(names) == 0 {
+		names = svc.DefaultRegions
+	}
+
+	out := &ec2.DescribeRegionsOutput{}
+	for _, name := range names {
+		out.Regions = append(out.Regions, &ec2.Region{
+			RegionName: aws.String(name),
+			Endpoint:   aws.String("ec2." + name + ".amazonaws.com"),
+		})
+	}
+
+	return out, nil
+}
+
+func (r *Region) checkInstances(ids []*string) error {
+	for _, id := range ids {
+		found := false
+		for _, i := range r.Instances {
+			if aws.StringValue(i.InstanceId) == aws.StringValue(id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return awserr.New("InvalidInstanceID.NotFound",
+				fmt.Sprintf("The instance ID '%s' does not exist", aws.StringValue(id)), nil)
+		}
+	}
+	return nil
+}
+
+func matchesEC2Filters(tags []*ec2.Tag, filters []*ec2.Filter, attrs map[string]string) bool {
+	for _, f := range filters {
+		name := aws.StringValue(f.Name)
+		values := aws.StringValueSlice(f.Values)
+
+		switch {
+		case name == "tag-key":
+			found := false
+			for _, t := range tags {
+				if contains(values, aws.StringValue(t.Key)) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		case strings.HasPrefix(name, "tag:"):
+			found := false
+			for _, t := range tags {
+				if aws.StringValue(t.Key) == strings.TrimPrefix(name, "tag:") && contains(values, aws.StringValue(t.Value)) {
+					found = true
+				}
+			}
+			if !found {
+				return false
+			}
+		default:
+			if v, ok := attrs[name]; ok && !contains(values, v) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+type cfnClient struct {
+	aws    *AWS
+	region string
+}
+
+// findStack finds a stack by name or id. Deleted stacks are only found by id.
+func (c *cfnClient) findStack(r *Region, name string) (*cloudformation.Stack, error) {
+	for _, s := range r.Stacks {
+		if aws.StringValue(s.StackId) == name {
+			return s, nil
+		}
+		if aws.StringValue(s.StackName) == name && aws.StringValue(s.StackStatus) != cloudformation.StackStatusDeleteComplete {
+			return s, nil
+		}
+	}
+	return nil, awserr.New("ValidationError", fmt.Sprintf("Stack with id %s does not exist", name), nil)
+}
+
+func (c *cfnClient) DescribeStacks(in *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	out := &cloudformation.DescribeStacksOutput{}
+	r := c.aws.call(c.region, "DescribeStacks")
+	if r.Err != nil {
+		return out, r.Err
+	}
+
+	if in.StackName == nil {
+		for _, s := range r.Stacks {
+			if aws.StringValue(s.StackStatus) != cloudformation.StackStatusDeleteComplete {
+				out.Stacks = append(out.Stacks, s)
+			}
+		}
+		return out, nil
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return out, err
+	}
+	if old, ok := r.stale[aws.StringValue(s.StackId)]; ok && old.calls > 0 {
+		old.calls--
+		s = old.stack
+	}
+	out.Stacks = []*cloudformation.Stack{s}
+
+	return out, nil
+}
+
+// delay keeps the stack's current status for StatusDelay DescribeStacks
+// calls, call it before changing it.
+func (c *cfnClient) delay(r *Region, s *cloudformation.Stack) {
+	if r.StatusDelay > 0 {
+		old := *s
+		r.stale[aws.StringValue(s.StackId)] = &staleStack{stack: &old, calls: r.StatusDelay}
+	}
+}
+
+func (c *cfnClient) DescribeStackEvents(in *cloudformation.DescribeStackEventsInput) (*cloudformation.DescribeStackEventsOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	out := &cloudformation.DescribeStackEventsOutput{}
+	r := c.aws.call(c.region, "DescribeStackEvents")
+	if r.Err != nil {
+		return out, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return out, err
+	}
+	events := r.StackEvents[aws.StringValue(s.StackName)]
+	if r.EventPageSize > 0 {
+		start, _ := strconv.Atoi(aws.StringValue(in.NextToken))
+		end := start + r.EventPageSize
+		if end < len(events) {
+			out.NextToken = aws.String(strconv.Itoa(end))
+		} else {
+			end = len(events)
+		}
+		events = events[start:end]
+	}
+	out.StackEvents = events
+
+	return out, nil
+}
+
+func (c *cfnClient) UpdateStack(in *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "UpdateStack")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return nil, err
+	}
+
+	c.update(r, s, in.TemplateBody, in.Parameters)
+	r.StackUpdates = append(r.StackUpdates, in)
+
+	return &cloudformation.UpdateStackOutput{StackId: s.StackId}, nil
+}
+
+func (c *cfnClient) update(r *Region, s *cloudformation.Stack, template *string, params []*cloudformation.Parameter) {
+	c.delay(r, s)
+	s.Parameters = resolveParams(s, params)
+	if template != nil {
+		r.Templates[aws.StringValue(s.StackName)] = aws.StringValue(template)
+	}
+
+	status := cloudformation.StackStatusUpdateComplete
+	if r.UpdateStatus != "" {
+		status = r.UpdateStatus
+	}
+	s.StackStatus = aws.String(status)
+
+	c.event(r, s, aws.StringValue(s.StackName), cloudformation.StackStatusUpdateInProgress, "User Initiated")
+	if strings.HasPrefix(status, "UPDATE_ROLLBACK_") {
+		reason := r.UpdateFailure
+		if reason == "" {
+			reason = "Resource update failed"
+		}
+		c.event(r, s, "BastionInstance", cloudformation.ResourceStatusUpdateFailed, reason)
+		c.event(r, s, aws.StringValue(s.StackName), cloudformation.StackStatusUpdateRollbackInProgress, "")
+	}
+	if status != cloudformation.StackStatusUpdateInProgress && status != cloudformation.StackStatusUpdateRollbackInProgress {
+		c.event(r, s, aws.StringValue(s.StackName), status, "")
+	}
+}
+
+// event adds a stack event, newest first like DescribeStackEvents.
+func (c *cfnClient) event(r *Region, s *cloudformation.Stack, resource, status, reason string) {
+	c.aws.events++
+	e := &cloudformation.StackEvent{
+		EventId:           aws.String(fmt.Sprintf("event-%d", c.aws.events)),
+		StackId:           s.StackId,
+		StackName:         s.StackName,
+		Timestamp:         aws.Time(time.Now()),
+		LogicalResourceId: aws.String(resource),
+		ResourceType:      aws.String("AWS::EC2::Instance"),
+		ResourceStatus:    aws.String(status),
+	}
+	if resource == aws.StringValue(s.StackName) {
+		e.ResourceType = aws.String("AWS::CloudFormation::Stack")
+	}
+	if reason != "" {
+		e.ResourceStatusReason = aws.String(reason)
+	}
+	name := aws.StringValue(s.StackName)
+	r.StackEvents[name] = append([]*cloudformation.StackEvent{e}, r.StackEvents[name]...)
+}
+
+// resolveParams replaces UsePreviousValue parameters with the stack's values.
+func resolveParams(s *cloudformation.Stack, in []*cloudformation.Parameter) []*cloudformation.Parameter {
+	params := []*cloudformation.Parameter{}
+	for _, p := range in {
+		if aws.BoolValue(p.UsePreviousValue) {
+			for _, old := range s.Parameters {
+				if aws.StringValue(old.ParameterKey) == aws.StringValue(p.ParameterKey) {
+					params = append(params, old)
+				}
+			}
+			continue
+		}
+		params = append(params, &cloudformation.Parameter{
+			ParameterKey:   p.ParameterKey,
+			ParameterValue: p.ParameterValue,
+		})
+	}
+	return params
+}
+
+// DeleteStack deletes a stack's resources right away, except those in
+// DeleteFailures.
+func (c *cfnClient) DeleteStack(in *cloudformation.DeleteStackInput) (*cloudformation.DeleteStackOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "DeleteStack")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(s.StackName)
+
+	c.delay(r, s)
+	c.event(r, s, name, cloudformation.StackStatusDeleteInProgress, "User Initiated")
+	failed := []string{}
+	for _, res := range r.StackResources[name] {
+		id := aws.StringValue(res.LogicalResourceId)
+		if reason, ok := r.DeleteFailures[id]; ok {
+			res.ResourceStatus = aws.String(cloudformation.ResourceStatusDeleteFailed)
+			res.ResourceStatusReason = aws.String(reason)
+			failed = append(failed, id)
+		} else {
+			res.ResourceStatus = aws.String(cloudformation.ResourceStatusDeleteComplete)
+		}
+		c.event(r, s, id, aws.StringValue(res.ResourceStatus), aws.StringValue(res.ResourceStatusReason))
+	}
+
+	if len(failed) > 0 {
+		s.StackStatus = aws.String(cloudformation.StackStatusDeleteFailed)
+		s.StackStatusReason = aws.String(fmt.Sprintf("The following resource(s) failed to delete: [%s]. ", strings.Join(failed, ", ")))
+	} else {
+		s.StackStatus = aws.String(cloudformation.StackStatusDeleteComplete)
+	}
+	c.event(r, s, name, aws.StringValue(s.StackStatus), aws.StringValue(s.StackStatusReason))
+
+	return &cloudformation.DeleteStackOutput{}, nil
+}
+
+func (c *cfnClient) ListStackResources(in *cloudformation.ListStackResourcesInput) (*cloudformation.ListStackResourcesOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "ListStackResources")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudformation.ListStackResourcesOutput{StackResourceSummaries: r.StackResources[aws.StringValue(s.StackName)]}, nil
+}
+
+func (c *cfnClient) CancelUpdateStack(in *cloudformation.CancelUpdateStackInput) (*cloudformation.CancelUpdateStackOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "CancelUpdateStack")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(s.StackStatus) != cloudformation.StackStatusUpdateInProgress {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("CancelUpdateStack cannot be called from current stack status %s", aws.StringValue(s.StackStatus)), nil)
+	}
+
+	c.delay(r, s)
+	c.event(r, s, aws.StringValue(s.StackName), cloudformation.StackStatusUpdateRollbackInProgress, "User Initiated")
+	c.rolledBack(r, s)
+	return &cloudformation.CancelUpdateStackOutput{}, nil
+}
+
+func (c *cfnClient) ContinueUpdateRollback(in *svc.ContinueUpdateRollbackInput) (*cloudformation.ContinueUpdateRollbackOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "ContinueUpdateRollback")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return nil, err
+	}
+	if aws.StringValue(s.StackStatus) != cloudformation.StackStatusUpdateRollbackFailed {
+		return nil, awserr.New("ValidationError", fmt.Sprintf("Stack %s is in %s state and can not continue rollback", aws.StringValue(s.StackName), aws.StringValue(s.StackStatus)), nil)
+	}
+
+	r.SkippedResources = append(r.SkippedResources, aws.StringValueSlice(in.ResourcesToSkip)...)
+	c.delay(r, s)
+	c.event(r, s, aws.StringValue(s.StackName), cloudformation.StackStatusUpdateRollbackInProgress, "")
+	c.rolledBack(r, s)
+	return &cloudformation.ContinueUpdateRollbackOutput{}, nil
+}
+
+func (c *cfnClient) rolledBack(r *Region, s *cloudformation.Stack) {
+	s.StackStatus = aws.String(cloudformation.StackStatusUpdateRollbackComplete)
+	c.event(r, s, aws.StringValue(s.StackName), cloudformation.StackStatusUpdateRollbackComplete, "")
+}
+
+func (c *cfnClient) GetTemplate(in *cloudformation.GetTemplateInput) (*cloudformation.GetTemplateOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "GetTemplate")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	s, err := c.findStack(r, aws.StringValue(in.StackName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(r.Templates[aws.StringValue(s.StackName)])}, nil
+}
+
+// ValidateTemplate only checks that templates are json objects.
+func (c *cfnClient) ValidateTemplate(in *cloudformation.ValidateTemplateInput) (*cloudformation.ValidateTemplateOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "ValidateTemplate")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	var template struct {
+		Description string
+		Parameters  map[string]struct {
+			Default     *string
+			Description string
+			NoEcho      bool
+		}
+	}
+	if err := json.Unmarshal([]byte(aws.StringValue(in.TemplateBody)), &template); err != nil {
+		return nil, awserr.New("ValidationError", "Template format error: JSON not well-formed. "+err.Error(), nil)
+	}
+
+	out := &cloudformation.ValidateTemplateOutput{Description: aws.String(template.Description)}
+	keys := []string{}
+	for k := range template.Parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := template.Parameters[k]
+		out.Parameters = append(out.Parameters, &cloudformation.TemplateParameter{
+			ParameterKey: aws.String(k),
+			DefaultValue: p.Default,
+			Description:  aws.String(p.Description),
+			NoEcho:       aws.Bool(p.NoEcho),
+		})
+	}
+	return out, nil
+}
+
+func (c *cfnClient) CreateChangeSet(in *svc.CreateChangeSetInput) (*svc.CreateChangeSetOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "CreateChangeSet")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	if _, err := c.findStack(r, aws.StringValue(in.StackName)); err != nil {
+		return nil, err
+	}
+	name := aws.StringValue(in.ChangeSetName)
+	if _, ok := r.ChangeSets[name]; ok {
+		return nil, awserr.New("AlreadyExistsException", fmt.Sprintf("ChangeSet %s already exists", name), nil)
+	}
+	r.ChangeSets[name] = &ChangeSet{Input: in, Status: svc.ChangeSetStatusCreateComplete}
+
+	return &svc.CreateChangeSetOutput{Id: aws.String("arn:aws:cloudformation:" + c.region + ":123456789012:changeSet/" + name)}, nil
+}
+
+func (c *cfnClient) changeSet(r *Region, name string) (*ChangeSet, error) {
+	cs, ok := r.ChangeSets[name]
+	if !ok {
+		return nil, awserr.New("ChangeSetNotFound", fmt.Sprintf("ChangeSet [%s] does not exist", name), nil)
+	}
+	return cs, nil
+}
+
+func (c *cfnClient) DescribeChangeSet(in *svc.DescribeChangeSetInput) (*svc.DescribeChangeSetOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "DescribeChangeSet")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	cs, err := c.changeSet(r, aws.StringValue(in.ChangeSetName))
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.findStack(r, aws.StringValue(cs.Input.StackName))
+	if err != nil {
+		return nil, err
+	}
+
+	return &svc.DescribeChangeSetOutput{
+		ChangeSetName:   cs.Input.ChangeSetName,
+		StackName:       cs.Input.StackName,
+		Status:          aws.String(cs.Status),
+		ExecutionStatus: aws.String("AVAILABLE"),
+		Parameters:      resolveParams(s, cs.Input.Parameters),
+		Changes:         r.Changes,
+	}, nil
+}
+
+func (c *cfnClient) ExecuteChangeSet(in *svc.ExecuteChangeSetInput) (*svc.ExecuteChangeSetOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "ExecuteChangeSet")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	name := aws.StringValue(in.ChangeSetName)
+	cs, err := c.changeSet(r, name)
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.findStack(r, aws.StringValue(cs.Input.StackName))
+	if err != nil {
+		return nil, err
+	}
+
+	c.update(r, s, cs.Input.TemplateBody, cs.Input.Parameters)
+	delete(r.ChangeSets, name)
+	r.Executed = append(r.Executed, name)
+
+	return &svc.ExecuteChangeSetOutput{}, nil
+}
+
+func (c *cfnClient) DeleteChangeSet(in *svc.DeleteChangeSetInput) (*svc.DeleteChangeSetOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	r := c.aws.call(c.region, "DeleteChangeSet")
+	if r.Err != nil {
+		return nil, r.Err
+	}
+
+	name := aws.StringValue(in.ChangeSetName)
+	if _, err := c.changeSet(r, name); err != nil {
+		return nil, err
+	}
+	delete(r.ChangeSets, name)
+
+	return &svc.DeleteChangeSetOutput{}, nil
+}
+
+type iamClient struct {
+	aws *AWS
+}
+
+func (c *iamClient) GetRolePolicy(in *iam.GetRolePolicyInput) (*iam.GetRolePolicyOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	role := aws.StringValue(in.RoleName)
+	name := aws.StringValue(in.PolicyName)
+	doc, ok := c.aws.RolePolicies[role][name]
+	if !ok {
+		return nil, awserr.New("NoSuchEntity",
+			fmt.Sprintf("The role policy with name %s cannot be found.", name), nil)
+	}
+
+	return &iam.GetRolePolicyOutput{
+		RoleName:       in.RoleName,
+		PolicyName:     in.PolicyName,
+		PolicyDocument: aws.String(doc),
+	}, nil
+}
+
+func (c *iamClient) PutRolePolicy(in *iam.PutRolePolicyInput) (*iam.PutRolePolicyOutput, error) {
+	c.aws.mu.Lock()
+	defer c.aws.mu.Unlock()
+
+	role := aws.StringValue(in.RoleName)
+	if c.aws.RolePolicies[role] == nil {
+		c.aws.RolePolicies[role] = make(map[string]string)
+	}
+	c.aws.RolePolicies[role][aws.StringValue(in.PolicyName)] = aws.StringValue(in.PolicyDocument)
+
+	return &iam.PutRolePolicyOutput{}, nil
+}
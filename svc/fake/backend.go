@@ -0,0 +1,413 @@
+package fake
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	log "github.com/mborsuk/jwalterweatherman"
+	"github.com/opsee/basic/schema"
+	opsee_aws_credentials "github.com/opsee/basic/schema/aws/credentials"
+	"github.com/opsee/basic/service"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"gopkg.in/yaml.v2"
+)
+
+// Fixtures is the contents of a fake backend fixture file (yaml or json).
+//
+//	customers:
+//	  - id: 5963d7bc-6ba2-11e5-8603-6ba085b2f5b5
+//	    name: isis
+//	    users:
+//	      - id: 1
+//	        email: sterling@isis.com
+//	        name: Sterling Archer
+//	    bastion_states:
+//	      - id: d07cac86-df4a-11e5-a446-4b21b841f273
+//	        status: active
+//	        last_seen: 30s  # duration ago or RFC3339 timestamp
+//	        region: us-west-2
+//	        vpc_id: vpc-1234
+//	    credentials:
+//	      access_key_id: AKIA...
+//	      secret_access_key: ...
+//	      session_token: ...
+//	    regions:
+//	      - region: us-west-2
+//	        vpcs:
+//	          - vpc_id: vpc-1234
+//	        subnets:
+//	          - subnet_id: subnet-1234
+//	            vpc_id: vpc-1234
+//	            routing: public
+type Fixtures struct {
+	Customers []*CustomerFixture `yaml:"customers"`
+}
+
+type CustomerFixture struct {
+	Id            string              `yaml:"id"`
+	Name          string              `yaml:"name"`
+	Users         []*UserFixture      `yaml:"users"`
+	BastionStates []*BastionFixture   `yaml:"bastion_states"`
+	Credentials   *CredentialsFixture `yaml:"credentials"`
+	Regions       []*RegionFixture    `yaml:"regions"`
+}
+
+type UserFixture struct {
+	Id     int32  `yaml:"id"`
+	Email  string `yaml:"email"`
+	Name   string `yaml:"name"`
+	Admin  bool   `yaml:"admin"`
+	Status string `yaml:"status"`
+}
+
+type BastionFixture struct {
+	Id       string `yaml:"id"`
+	Status   string `yaml:"status"`
+	LastSeen string `yaml:"last_seen"`
+	Region   string `yaml:"region"`
+	VpcId    string `yaml:"vpc_id"`
+}
+
+type CredentialsFixture struct {
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+}
+
+type RegionFixture struct {
+	Region string `yaml:"region"`
+	Vpcs   []struct {
+		VpcId         string `yaml:"vpc_id"`
+		CidrBlock     string `yaml:"cidr_block"`
+		InstanceCount int32  `yaml:"instance_count"`
+		IsDefault     bool   `yaml:"is_default"`
+	} `yaml:"vpcs"`
+	Subnets []struct {
+		SubnetId         string `yaml:"subnet_id"`
+		VpcId            string `yaml:"vpc_id"`
+		AvailabilityZone string `yaml:"availability_zone"`
+		CidrBlock        string `yaml:"cidr_block"`
+		InstanceCount    int32  `yaml:"instance_count"`
+		Routing          string `yaml:"routing"`
+	} `yaml:"subnets"`
+}
+
+// LoadFixtures reads a fixture file.
+func LoadFixtures(path string) (*Fixtures, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := &Fixtures{}
+	if err := yaml.Unmarshal(data, fixtures); err != nil {
+		return nil, fmt.Errorf("invalid fixture file %s: %s", path, err)
+	}
+
+	return fixtures, nil
+}
+
+// Backend implements the cats, spanx and keelhaul grpc services on top of
+// fixtures. Launched stacks show up as active bastions.
+type Backend struct {
+	users    []*schema.User
+	bastions []*schema.BastionState
+	creds    map[string]*opsee_aws_credentials.Value
+	regions  map[string][]*schema.Region
+
+	mu sync.Mutex
+}
+
+func NewBackend(fixtures *Fixtures) (*Backend, error) {
+	b := &Backend{
+		creds:   make(map[string]*opsee_aws_credentials.Value),
+		regions: make(map[string][]*schema.Region),
+	}
+
+	for _, c := range fixtures.Customers {
+		if c.Id == "" {
+			return nil, fmt.Errorf("customer without id: %s", c.Name)
+		}
+
+		for _, u := range c.Users {
+			b.users = append(b.users, &schema.User{
+				Id:         u.Id,
+				CustomerId: c.Id,
+				Email:      u.Email,
+				Name:       u.Name,
+				Admin:      u.Admin,
+				Status:     u.Status,
+				Verified:   true,
+				Active:     true,
+			})
+		}
+
+		for _, bf := range c.BastionStates {
+			lastSeen, err := parseLastSeen(bf.LastSeen)
+			if err != nil {
+				return nil, fmt.Errorf("bastion %s: %s", bf.Id, err)
+			}
+
+			b.bastions = append(b.bastions, &schema.BastionState{
+				Id:         bf.Id,
+				CustomerId: c.Id,
+				Status:     bf.Status,
+				LastSeen:   &opsee_types.Timestamp{Seconds: lastSeen.Unix()},
+				Region:     bf.Region,
+				VpcId:      bf.VpcId,
+			})
+		}
+
+		if c.Credentials != nil {
+			b.creds[c.Id] = &opsee_aws_credentials.Value{
+				AccessKeyID:     aws.String(c.Credentials.AccessKeyID),
+				SecretAccessKey: aws.String(c.Credentials.SecretAccessKey),
+				SessionToken:    aws.String(c.Credentials.SessionToken),
+				ProviderName:    aws.String("FakeBackend"),
+			}
+		}
+
+		for _, rf := range c.Regions {
+			region := &schema.Region{Region: rf.Region, CustomerId: c.Id}
+			for _, v := range rf.Vpcs {
+				region.Vpcs = append(region.Vpcs, &schema.Vpc{
+					VpcId:         v.VpcId,
+					CidrBlock:     v.CidrBlock,
+					InstanceCount: v.InstanceCount,
+					IsDefault:     v.IsDefault,
+					State:         "available",
+				})
+			}
+			for _, s := range rf.Subnets {
+				region.Subnets = append(region.Subnets, &schema.Subnet{
+					SubnetId:         s.SubnetId,
+					VpcId:            s.VpcId,
+					AvailabilityZone: s.AvailabilityZone,
+					CidrBlock:        s.CidrBlock,
+					InstanceCount:    s.InstanceCount,
+					Routing:          s.Routing,
+					State:            "available",
+				})
+			}
+			b.regions[c.Id] = append(b.regions[c.Id], region)
+		}
+	}
+
+	return b, nil
+}
+
+func parseLastSeen(s string) (time.Time, error) {
+	if s == "" {
+		return time.Now(), nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Serve registers the backend with a new grpc server and serves on lis
+// until it is closed.
+func (b *Backend) Serve(lis net.Listener) error {
+	server := grpc.NewServer()
+	service.RegisterCatsServer(server, b)
+	service.RegisterSpanxServer(server, b)
+	service.RegisterKeelhaulServer(server, b)
+
+	return server.Serve(lis)
+}
+
+func unimplemented(method string) error {
+	log.WARN.Printf("fake backend: %s not implemented\n", method)
+	return grpc.Errorf(codes.Unimplemented, "%s not implemented by fake backend", method)
+}
+
+func (b *Backend) findUser(user *schema.User) (*schema.User, error) {
+	if user == nil {
+		return nil, grpc.Errorf(codes.InvalidArgument, "missing user")
+	}
+
+	for _, u := range b.users {
+		if u.CustomerId == user.CustomerId && (user.Id == 0 || u.Id == user.Id) {
+			return u, nil
+		}
+	}
+
+	return nil, grpc.Errorf(codes.NotFound, "user not found: %d", user.Id)
+}
+
+// cats
+
+func (b *Backend) GetUser(ctx context.Context, req *service.GetUserRequest) (*service.GetUserResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log.INFO.Printf("cats GetUser: email=%s customer_id=%s id=%d\n", req.Email, req.CustomerId, req.Id)
+	for _, u := range b.users {
+		if (req.Email != "" && u.Email == req.Email) ||
+			(req.CustomerId != "" && u.CustomerId == req.CustomerId) ||
+			(req.Id != 0 && u.Id == req.Id) {
+			return &service.GetUserResponse{User: u}, nil
+		}
+	}
+
+	return nil, grpc.Errorf(codes.NotFound, "user not found")
+}
+
+func (b *Backend) GetCheckCount(context.Context, *service.GetCheckCountRequest) (*service.GetCheckCountResponse, error) {
+	return nil, unimplemented("GetCheckCount")
+}
+
+func (b *Backend) UpdateUser(context.Context, *service.UpdateUserRequest) (*service.UserTokenResponse, error) {
+	return nil, unimplemented("UpdateUser")
+}
+
+func (b *Backend) ListUsers(context.Context, *service.ListUsersRequest) (*service.ListUsersResponse, error) {
+	return nil, unimplemented("ListUsers")
+}
+
+func (b *Backend) InviteUser(context.Context, *service.InviteUserRequest) (*service.InviteUserResponse, error) {
+	return nil, unimplemented("InviteUser")
+}
+
+func (b *Backend) DeleteUser(context.Context, *service.DeleteUserRequest) (*service.DeleteUserResponse, error) {
+	return nil, unimplemented("DeleteUser")
+}
+
+func (b *Backend) GetTeam(context.Context, *service.GetTeamRequest) (*service.GetTeamResponse, error) {
+	return nil, unimplemented("GetTeam")
+}
+
+func (b *Backend) CreateTeam(context.Context, *service.CreateTeamRequest) (*service.CreateTeamResponse, error) {
+	return nil, unimplemented("CreateTeam")
+}
+
+func (b *Backend) UpdateTeam(context.Context, *service.UpdateTeamRequest) (*service.UpdateTeamResponse, error) {
+	return nil, unimplemented("UpdateTeam")
+}
+
+func (b *Backend) DeleteTeam(context.Context, *service.DeleteTeamRequest) (*service.DeleteTeamResponse, error) {
+	return nil, unimplemented("DeleteTeam")
+}
+
+func (b *Backend) GetCheckResults(context.Context, *service.GetCheckResultsRequest) (*service.GetCheckResultsResponse, error) {
+	return nil, unimplemented("GetCheckResults")
+}
+
+func (b *Backend) GetCheckStateTransitions(context.Context, *service.GetCheckStateTransitionsRequest) (*service.GetCheckStateTransitionsResponse, error) {
+	return nil, unimplemented("GetCheckStateTransitions")
+}
+
+func (b *Backend) GetChecks(context.Context, *service.GetChecksRequest) (*service.GetChecksResponse, error) {
+	return nil, unimplemented("GetChecks")
+}
+
+// spanx
+
+func (b *Backend) GetCredentials(ctx context.Context, req *service.GetCredentialsRequest) (*service.GetCredentialsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	user, err := b.findUser(req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	log.INFO.Printf("spanx GetCredentials: customer_id=%s\n", user.CustomerId)
+	creds, ok := b.creds[user.CustomerId]
+	if !ok {
+		return nil, grpc.Errorf(codes.NotFound, "no credentials for customer: %s", user.CustomerId)
+	}
+
+	return &service.GetCredentialsResponse{
+		Credentials: creds,
+		Expires:     &opsee_types.Timestamp{Seconds: time.Now().Add(time.Hour).Unix()},
+	}, nil
+}
+
+func (b *Backend) EnhancedCombatMode(context.Context, *service.EnhancedCombatModeRequest) (*service.EnhancedCombatModeResponse, error) {
+	return nil, unimplemented("EnhancedCombatMode")
+}
+
+func (b *Backend) GetRoleStack(context.Context, *service.GetRoleStackRequest) (*service.GetRoleStackResponse, error) {
+	return nil, unimplemented("GetRoleStack")
+}
+
+// keelhaul
+
+func (b *Backend) ListBastionStates(ctx context.Context, req *service.ListBastionStatesRequest) (*service.ListBastionStatesResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log.INFO.Printf("keelhaul ListBastionStates: customer_ids=%v\n", req.CustomerIds)
+	states := []*schema.BastionState{}
+	for _, bs := range b.bastions {
+		if len(req.CustomerIds) > 0 && !contains(req.CustomerIds, bs.CustomerId) {
+			continue
+		}
+		if !matches(bs, req.Filters) {
+			continue
+		}
+		states = append(states, bs)
+	}
+
+	return &service.ListBastionStatesResponse{BastionStates: states}, nil
+}
+
+func (b *Backend) ScanVpcs(ctx context.Context, req *service.ScanVpcsRequest) (*service.ScanVpcsResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	user, err := b.findUser(req.User)
+	if err != nil {
+		return nil, err
+	}
+
+	log.INFO.Printf("keelhaul ScanVpcs: customer_id=%s region=%s\n", user.CustomerId, req.Region)
+	for _, r := range b.regions[user.CustomerId] {
+		if r.Region == req.Region {
+			return &service.ScanVpcsResponse{Region: r}, nil
+		}
+	}
+
+	return &service.ScanVpcsResponse{Region: &schema.Region{Region: req.Region, CustomerId: user.CustomerId}}, nil
+}
+
+func (b *Backend) LaunchStack(ctx context.Context, req *service.LaunchStackRequest) (*service.LaunchStackResponse, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	user, err := b.findUser(req.User)
+	if err != nil {
+		return nil, err
+	}
+	if req.Region == "" || req.VpcId == "" || req.SubnetId == "" {
+		return nil, grpc.Errorf(codes.InvalidArgument, "region, vpc_id and subnet_id are required")
+	}
+
+	id := fmt.Sprintf("%08x-0000-1000-8000-%012x", time.Now().Unix(), len(b.bastions))
+	log.INFO.Printf("keelhaul LaunchStack: customer_id=%s region=%s vpc_id=%s subnet_id=%s bastion_id=%s\n",
+		user.CustomerId, req.Region, req.VpcId, req.SubnetId, id)
+
+	b.bastions = append(b.bastions, &schema.BastionState{
+		Id:         id,
+		CustomerId: user.CustomerId,
+		Status:     "active",
+		LastSeen:   &opsee_types.Timestamp{Seconds: time.Now().Unix()},
+		Region:     req.Region,
+		VpcId:      req.VpcId,
+	})
+
+	return &service.LaunchStackResponse{StackId: "opsee-stack-" + user.CustomerId}, nil
+}
+
+func (b *Backend) AuthenticateBastion(context.Context, *service.AuthenticateBastionRequest) (*service.AuthenticateBastionResponse, error) {
+	return nil, unimplemented("AuthenticateBastion")
+}
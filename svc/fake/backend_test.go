@@ -0,0 +1,112 @@
+package fake
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/boop/svc"
+)
+
+const testFixtures = `
+customers:
+  - id: 5963d7bc-6ba2-11e5-8603-6ba085b2f5b5
+    name: isis
+    users:
+      - id: 1
+        email: sterling@isis.com
+        name: Sterling Archer
+    bastion_states:
+      - id: d07cac86-df4a-11e5-a446-4b21b841f273
+        status: active
+        last_seen: 30s
+        region: us-west-2
+        vpc_id: vpc-1234
+      - id: 45cde7e8-d118-11e5-a310-ef438a026494
+        status: inactive
+        last_seen: 2016-03-01T00:00:00Z
+        region: us-west-2
+        vpc_id: vpc-1234
+    credentials:
+      access_key_id: AKIAFAKE
+      secret_access_key: secret
+      session_token: token
+`
+
+func startBackend(t *testing.T) (*svc.OpseeServices, func()) {
+	f, err := ioutil.TempFile("", "boop-fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testFixtures)
+	f.Close()
+
+	fixtures, err := LoadFixtures(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backend, err := NewBackend(fixtures)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go backend.Serve(lis)
+
+	cfg := svc.ServiceConfig{Address: lis.Addr().String(), Timeout: svc.Profiles["local"].Cats.Timeout, Plaintext: true}
+	return svc.NewOpseeServices(&svc.Profile{Name: "test", Cats: cfg, Spanx: cfg, Keelhaul: cfg}), func() { lis.Close() }
+}
+
+func TestBackend(t *testing.T) {
+	services, stop := startBackend(t)
+	defer stop()
+
+	user, err := services.GetUser("sterling@isis.com", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.CustomerId != "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5" {
+		t.Errorf("unexpected customer id: %s", user.CustomerId)
+	}
+
+	creds, err := services.GetRoleCreds(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(creds.AccessKeyID) != "AKIAFAKE" {
+		t.Errorf("unexpected access key: %s", aws.StringValue(creds.AccessKeyID))
+	}
+
+	states, err := services.GetBastionStates([]string{user.CustomerId})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("expected 2 bastion states, got %d", len(states))
+	}
+
+	states, err = services.GetBastionStates(nil, &service.Filter{Key: "status", Value: "active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 1 || states[0].Id != "d07cac86-df4a-11e5-a446-4b21b841f273" {
+		t.Fatalf("expected only the active bastion, got %v", states)
+	}
+}
+
+func TestBackendUnknownUser(t *testing.T) {
+	services, stop := startBackend(t)
+	defer stop()
+
+	if _, err := services.GetUser("lana@isis.com", ""); err == nil {
+		t.Fatal("expected error for unknown user")
+	}
+}
@@ -0,0 +1,177 @@
+// Package fake provides in-memory implementations of the svc interfaces for tests.
+package fake
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/opsee/basic/schema"
+	opsee_aws_credentials "github.com/opsee/basic/schema/aws/credentials"
+	"github.com/opsee/basic/service"
+	"github.com/opsee/boop/errors"
+	opsee_types "github.com/opsee/protobuf/opseeproto/types"
+)
+
+// Services is an in-memory svc.Services.
+type Services struct {
+	Users         []*schema.User
+	BastionStates []*schema.BastionState
+	// credentials by customer id, Creds is returned for customers not in the map
+	CustomerCreds map[string]*opsee_aws_credentials.Value
+	Creds         *opsee_aws_credentials.Value
+	// ScanVpcs results by customer id and region
+	Regions map[string]map[string]*schema.Region
+
+	// LaunchStack requests, each adds a bastion unless LaunchErr is set
+	Launched  []*service.LaunchStackRequest
+	LaunchErr error
+	// status of launched bastions, active if empty
+	LaunchStatus string
+	// launched bastions never check in, they get no bastion state
+	LaunchUnregistered bool
+	// called with each launched bastion, e.g. to add its instance to fake AWS
+	OnLaunch func(*schema.BastionState)
+	// stack id returned by LaunchStack, opsee-stack-<customer id> if empty
+	LaunchStackId string
+
+	mu sync.Mutex
+}
+
+func NewServices() *Services {
+	return &Services{
+		CustomerCreds: make(map[string]*opsee_aws_credentials.Value),
+		Regions:       make(map[string]map[string]*schema.Region),
+		Creds: &opsee_aws_credentials.Value{
+			AccessKeyID:     aws.String("AKIAFAKE"),
+			SecretAccessKey: aws.String("fake-secret"),
+			SessionToken:    aws.String("fake-token"),
+		},
+	}
+}
+
+func (s *Services) GetUser(email string, custID string) (*schema.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, u := range s.Users {
+		if (email != "" && u.Email == email) || (custID != "" && u.CustomerId == custID) {
+			return u, nil
+		}
+	}
+
+	return nil, errors.NewNotFoundErrorF("user not found: %s%s", email, custID)
+}
+
+func (s *Services) GetBastionStates(customerIDs []string, filters ...*service.Filter) ([]*schema.BastionState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := []*schema.BastionState{}
+	for _, b := range s.BastionStates {
+		if len(customerIDs) > 0 && !contains(customerIDs, b.CustomerId) {
+			continue
+		}
+		if !matches(b, filters) {
+			continue
+		}
+		states = append(states, b)
+	}
+
+	return states, nil
+}
+
+func (s *Services) GetRoleCreds(user *schema.User) (*opsee_aws_credentials.Value, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.CustomerCreds[user.CustomerId]; ok {
+		return c, nil
+	}
+	if s.Creds == nil {
+		return nil, errors.Newf(errors.KindPermission, "no credentials for customer: %s", user.CustomerId)
+	}
+
+	return s.Creds, nil
+}
+
+func (s *Services) ScanVpcs(user *schema.User, region string) (*schema.Region, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.Regions[user.CustomerId][region]; ok {
+		return r, nil
+	}
+
+	return &schema.Region{Region: region, CustomerId: user.CustomerId}, nil
+}
+
+func (s *Services) LaunchStack(req *service.LaunchStackRequest) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.LaunchErr != nil {
+		return "", s.LaunchErr
+	}
+	if req.Region == "" || req.VpcId == "" || req.SubnetId == "" {
+		return "", errors.NewUserError("region, vpc_id and subnet_id are required")
+	}
+
+	status := s.LaunchStatus
+	if status == "" {
+		status = "active"
+	}
+
+	bastion := &schema.BastionState{
+		Id:         fmt.Sprintf("%08x-0000-1000-8000-%012x", len(s.Launched)+1, len(s.BastionStates)),
+		CustomerId: req.User.CustomerId,
+		Status:     status,
+		LastSeen:   &opsee_types.Timestamp{Seconds: time.Now().Unix()},
+		Region:     req.Region,
+		VpcId:      req.VpcId,
+	}
+	s.Launched = append(s.Launched, req)
+	if !s.LaunchUnregistered {
+		s.BastionStates = append(s.BastionStates, bastion)
+	}
+	if s.OnLaunch != nil {
+		s.OnLaunch(bastion)
+	}
+
+	if s.LaunchStackId != "" {
+		return s.LaunchStackId, nil
+	}
+	return "opsee-stack-" + req.User.CustomerId, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(b *schema.BastionState, filters []*service.Filter) bool {
+	for _, f := range filters {
+		var v string
+		switch f.Key {
+		case "status":
+			v = b.Status
+		case "region":
+			v = b.Region
+		case "vpc_id":
+			v = b.VpcId
+		case "id":
+			v = b.Id
+		default:
+			continue
+		}
+		if v != f.Value {
+			return false
+		}
+	}
+	return true
+}
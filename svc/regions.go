@@ -0,0 +1,121 @@
+package svc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opsee/boop/errors"
+)
+
+const (
+	DefaultRegionWorkers = 5
+	DefaultRegionTimeout = 30 * time.Second
+)
+
+// RegionFunc does the work for a single region.
+type RegionFunc func(region string) (interface{}, error)
+
+// RegionResult is the outcome of a RegionFunc.
+type RegionResult struct {
+	Region string
+	Value  interface{}
+	Err    error
+}
+
+// RegionResults are in the order the regions were given.
+type RegionResults []RegionResult
+
+// FanOut runs a RegionFunc for many regions concurrently.
+type FanOut struct {
+	// max number of regions queried at once
+	Workers int
+	// how long to wait for a single region. The AWS SDK can't cancel
+	// requests, so a region that times out is abandoned, not stopped.
+	Timeout time.Duration
+}
+
+// Run calls f for each region and waits for all of them. A failing region
+// doesn't stop the others, check the results' errors or use Err.
+func (f FanOut) Run(regions []string, fn RegionFunc) RegionResults {
+	workers := f.Workers
+	if workers <= 0 {
+		workers = DefaultRegionWorkers
+	}
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultRegionTimeout
+	}
+
+	results := make(RegionResults, len(regions))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+
+	for w := 0; w < workers && w < len(regions); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = runRegion(regions[i], timeout, fn)
+			}
+		}()
+	}
+
+	for i := range regions {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+func runRegion(region string, timeout time.Duration, fn RegionFunc) RegionResult {
+	done := make(chan RegionResult, 1)
+	go func() {
+		v, err := fn(region)
+		done <- RegionResult{Region: region, Value: v, Err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r
+	case <-time.After(timeout):
+		return RegionResult{
+			Region: region,
+			Err:    errors.Newf(errors.KindTimeout, "no answer after %s", timeout),
+		}
+	}
+}
+
+// Failed returns the results with errors.
+func (rs RegionResults) Failed() RegionResults {
+	failed := RegionResults{}
+	for _, r := range rs {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+// Err combines the errors of all failed regions, nil if none failed. Its
+// kind is the failed regions' kind if they agree, KindAWS otherwise.
+func (rs RegionResults) Err() error {
+	failed := rs.Failed()
+	if len(failed) == 0 {
+		return nil
+	}
+
+	kind := errors.KindOf(failed[0].Err)
+	msgs := make([]string, len(failed))
+	for i, r := range failed {
+		if errors.KindOf(r.Err) != kind {
+			kind = errors.KindAWS
+		}
+		msgs[i] = fmt.Sprintf("%s: %s", r.Region, strings.TrimSpace(r.Err.Error()))
+	}
+
+	return errors.Newf(kind, "%d of %d regions failed: %s", len(failed), len(rs), strings.Join(msgs, "; "))
+}
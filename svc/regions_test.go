@@ -0,0 +1,92 @@
+package svc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opsee/boop/errors"
+)
+
+var testRegions = []string{"us-west-1", "us-west-2", "us-east-1", "eu-west-1", "eu-central-1", "sa-east-1"}
+
+func TestFanOutOrderAndWorkers(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+	)
+
+	results := FanOut{Workers: 2, Timeout: time.Second}.Run(testRegions, func(region string) (interface{}, error) {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "answer from " + region, nil
+	})
+
+	if max > 2 {
+		t.Errorf("expected at most 2 concurrent regions, got %d", max)
+	}
+	if len(results) != len(testRegions) {
+		t.Fatalf("expected %d results, got %d", len(testRegions), len(results))
+	}
+	for i, r := range results {
+		if r.Region != testRegions[i] || r.Value != "answer from "+testRegions[i] || r.Err != nil {
+			t.Errorf("unexpected result %d: %v", i, r)
+		}
+	}
+	if err := results.Err(); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+func TestFanOutPartialFailure(t *testing.T) {
+	results := FanOut{Timeout: 50 * time.Millisecond}.Run(testRegions, func(region string) (interface{}, error) {
+		switch region {
+		case "eu-west-1":
+			return nil, fmt.Errorf("connection reset")
+		case "sa-east-1":
+			time.Sleep(time.Second)
+		}
+		return region, nil
+	})
+
+	failed := results.Failed()
+	if len(failed) != 2 || failed[0].Region != "eu-west-1" || failed[1].Region != "sa-east-1" {
+		t.Fatalf("unexpected failed regions: %v", failed)
+	}
+	if errors.KindOf(failed[1].Err) != errors.KindTimeout {
+		t.Errorf("expected timeout for sa-east-1, got %v", failed[1].Err)
+	}
+	if results[0].Value != "us-west-1" {
+		t.Errorf("expected other regions to answer, got %v", results[0])
+	}
+
+	err := results.Err()
+	if err == nil || !strings.Contains(err.Error(), "2 of 6 regions failed") ||
+		!strings.Contains(err.Error(), "eu-west-1: connection reset") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestFanOutErrorKind(t *testing.T) {
+	results := FanOut{Timeout: 10 * time.Millisecond}.Run(testRegions[:2], func(region string) (interface{}, error) {
+		time.Sleep(time.Second)
+		return nil, nil
+	})
+
+	if kind := errors.KindOf(results.Err()); kind != errors.KindTimeout {
+		t.Errorf("expected timeout kind when all regions time out, got %s", kind)
+	}
+}
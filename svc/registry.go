@@ -0,0 +1,114 @@
+package svc
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+// DefaultRegions are scanned unless the config lists regions.
+var DefaultRegions = []string{
+	"us-west-1",
+	"us-west-2",
+	"us-east-1",
+	"eu-west-1",
+	"eu-central-1",
+	"sa-east-1",
+	"ap-southeast-1",
+	"ap-southeast-2",
+	"ap-northeast-1",
+	"ap-northeast-2",
+}
+
+// RegionRegistry is the list of regions boop knows about.
+type RegionRegistry struct {
+	Regions []string
+}
+
+// LoadRegions returns the regions from the config's regions key, or the
+// default regions.
+func LoadRegions() *RegionRegistry {
+	regions := viper.GetStringSlice("regions")
+	if len(regions) == 0 {
+		regions = DefaultRegions
+	}
+
+	return &RegionRegistry{Regions: append([]string{}, regions...)}
+}
+
+// Refresh replaces the regions with the ones returned by DescribeRegions.
+func (r *RegionRegistry) Refresh(client EC2) error {
+	resp, err := client.DescribeRegions(&ec2.DescribeRegionsInput{})
+	if err != nil {
+		return errors.Wrapf(err, "cannot describe regions")
+	}
+
+	regions := []string{}
+	for _, region := range resp.Regions {
+		regions = append(regions, aws.StringValue(region.RegionName))
+	}
+	if len(regions) == 0 {
+		return errors.NewSystemError("DescribeRegions returned no regions")
+	}
+	sort.Strings(regions)
+
+	r.Regions = regions
+	return nil
+}
+
+// Select filters the regions with a comma separated list of regions to
+// include and regions prefixed with - to exclude, e.g. "us-west-2,us-east-1"
+// or "-sa-east-1,-ap-northeast-2". An empty filter selects all regions.
+func (r *RegionRegistry) Select(filter string) ([]string, error) {
+	var include, exclude []string
+	for _, f := range strings.Split(filter, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+
+		list := &include
+		if strings.HasPrefix(f, "-") {
+			f = strings.TrimPrefix(f, "-")
+			list = &exclude
+		}
+		if !r.Has(f) {
+			return nil, errors.NewUserErrorF("unknown region %q, known regions: %s", f, strings.Join(r.Regions, ", "))
+		}
+		*list = append(*list, f)
+	}
+
+	selected := []string{}
+	for _, region := range r.Regions {
+		if len(include) > 0 && !contains(include, region) {
+			continue
+		}
+		if contains(exclude, region) {
+			continue
+		}
+		selected = append(selected, region)
+	}
+
+	if len(selected) == 0 {
+		return nil, errors.NewUserErrorF("no regions left after applying %q", filter)
+	}
+
+	return selected, nil
+}
+
+func (r *RegionRegistry) Has(region string) bool {
+	return contains(r.Regions, region)
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package svc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/opsee/boop/errors"
+	"github.com/spf13/viper"
+)
+
+func TestLoadRegions(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	if r := LoadRegions(); !reflect.DeepEqual(r.Regions, DefaultRegions) {
+		t.Errorf("expected default regions, got %v", r.Regions)
+	}
+
+	viper.Set("regions", []string{"us-west-2", "eu-west-1"})
+	if r := LoadRegions(); !reflect.DeepEqual(r.Regions, []string{"us-west-2", "eu-west-1"}) {
+		t.Errorf("expected configured regions, got %v", r.Regions)
+	}
+}
+
+func TestSelectRegions(t *testing.T) {
+	r := &RegionRegistry{Regions: []string{"us-west-1", "us-west-2", "us-east-1", "sa-east-1"}}
+
+	tests := []struct {
+		filter   string
+		expected []string
+	}{
+		{"", []string{"us-west-1", "us-west-2", "us-east-1", "sa-east-1"}},
+		{"us-east-1,us-west-2", []string{"us-west-2", "us-east-1"}},
+		{"-sa-east-1", []string{"us-west-1", "us-west-2", "us-east-1"}},
+		{"us-west-1, us-west-2,-us-west-2", []string{"us-west-1"}},
+	}
+
+	for _, test := range tests {
+		selected, err := r.Select(test.filter)
+		if err != nil {
+			t.Errorf("%q: %s", test.filter, err)
+			continue
+		}
+		if !reflect.DeepEqual(selected, test.expected) {
+			t.Errorf("%q: expected %v, got %v", test.filter, test.expected, selected)
+		}
+	}
+
+	for _, filter := range []string{"mars-north-1", "-mars-north-1", "-us-west-1,-us-west-2,-us-east-1,-sa-east-1"} {
+		if _, err := r.Select(filter); !errors.IsUserError(err) {
+			t.Errorf("%q: expected user error, got %v", filter, err)
+		}
+	}
+}
+
+type regionsEC2 struct {
+	EC2
+	regions []string
+}
+
+func (c regionsEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	out := &ec2.DescribeRegionsOutput{}
+	for _, r := range c.regions {
+		out.Regions = append(out.Regions, &ec2.Region{RegionName: aws.String(r)})
+	}
+	return out, nil
+}
+
+func TestRefreshRegions(t *testing.T) {
+	r := &RegionRegistry{Regions: DefaultRegions}
+
+	if err := r.Refresh(regionsEC2{regions: []string{"us-west-2", "ap-south-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Regions, []string{"ap-south-1", "us-west-2"}) {
+		t.Errorf("unexpected regions: %v", r.Regions)
+	}
+
+	if err := r.Refresh(regionsEC2{}); err == nil {
+		t.Error("expected error for empty region list")
+	}
+}
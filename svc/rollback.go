@@ -0,0 +1,19 @@
+package svc
+
+import "github.com/aws/aws-sdk-go/service/cloudformation"
+
+// ContinueUpdateRollbackInput adds ResourcesToSkip, which the vendored
+// aws-sdk-go doesn't know about yet.
+type ContinueUpdateRollbackInput struct {
+	_ struct{} `type:"structure"`
+
+	StackName *string `type:"string" required:"true"`
+	// logical ids of resources that failed to roll back, nested stack
+	// resources as NestedStack.Resource
+	ResourcesToSkip []*string `type:"list"`
+}
+
+func (c *cfnClient) ContinueUpdateRollback(in *ContinueUpdateRollbackInput) (*cloudformation.ContinueUpdateRollbackOutput, error) {
+	out := &cloudformation.ContinueUpdateRollbackOutput{}
+	return out, c.send("ContinueUpdateRollback", in, out)
+}
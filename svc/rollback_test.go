@@ -0,0 +1,49 @@
+package svc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+func TestContinueUpdateRollbackRequest(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`<ContinueUpdateRollbackResponse><ContinueUpdateRollbackResult/></ContinueUpdateRollbackResponse>`))
+	}))
+	defer server.Close()
+
+	sess := session.New(aws.NewConfig().
+		WithCredentials(credentials.NewStaticCredentials("AKIAFAKE", "secret", "")).
+		WithRegion("us-west-2").
+		WithEndpoint(server.URL).
+		WithMaxRetries(0))
+	client := &cfnClient{cloudformation.New(sess)}
+
+	_, err := client.ContinueUpdateRollback(&ContinueUpdateRollbackInput{
+		StackName:       aws.String("opsee-stack"),
+		ResourcesToSkip: []*string{aws.String("BastionInstance"), aws.String("Ingress.SecurityGroup")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for key, expected := range map[string]string{
+		"Action":                   "ContinueUpdateRollback",
+		"StackName":                "opsee-stack",
+		"ResourcesToSkip.member.1": "BastionInstance",
+		"ResourcesToSkip.member.2": "Ingress.SecurityGroup",
+	} {
+		if form.Get(key) != expected {
+			t.Errorf("expected %s=%s, got %q", key, expected, form.Get(key))
+		}
+	}
+}
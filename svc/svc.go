@@ -1,104 +1,223 @@
 package svc
 
 import (
-	"crypto/tls"
 	"time"
 
 	log "github.com/mborsuk/jwalterweatherman"
 	"github.com/opsee/basic/schema"
 	opsee_aws_credentials "github.com/opsee/basic/schema/aws/credentials"
 	"github.com/opsee/basic/service"
+	"github.com/opsee/boop/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	grpc_credentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
 )
 
 const tcpTimeout = time.Duration(3) * time.Second
 
+func init() {
+	// grpc's reconnect chatter is only interesting with --verbose
+	grpclog.SetLogger(log.INFO)
+}
+
+type UserGetter interface {
+	GetUser(email string, custID string) (*schema.User, error)
+}
+
+type BastionStateGetter interface {
+	GetBastionStates(customerIDs []string, filters ...*service.Filter) ([]*schema.BastionState, error)
+}
+
+type RoleCredsGetter interface {
+	GetRoleCreds(user *schema.User) (*opsee_aws_credentials.Value, error)
+}
+
+type VpcScanner interface {
+	ScanVpcs(user *schema.User, region string) (*schema.Region, error)
+}
+
+type StackLauncher interface {
+	// LaunchStack launches a bastion stack and returns the stack's id
+	LaunchStack(req *service.LaunchStackRequest) (string, error)
+}
+
+// Services is everything boop needs from the opsee backend.
+type Services interface {
+	UserGetter
+	BastionStateGetter
+	RoleCredsGetter
+	VpcScanner
+	StackLauncher
+}
+
 type OpseeServices struct {
+	// endpoints to use, production if nil
+	Profile  *Profile
 	cats     service.CatsClient
 	spanx    service.SpanxClient
 	keelhaul service.KeelhaulClient
 	//	awsSession session.Session
 }
 
-func (o *OpseeServices) initCats() {
+func NewOpseeServices(profile *Profile) *OpseeServices {
+	return &OpseeServices{Profile: profile}
+}
+
+func (o *OpseeServices) profile() *Profile {
+	if o.Profile == nil {
+		p := Profiles[DefaultProfile]
+		o.Profile = &p
+	}
+	return o.Profile
+}
+
+func requestContext(cfg ServiceConfig) (context.Context, context.CancelFunc) {
+	if cfg.RequestTimeout > 0 {
+		return context.WithTimeout(context.Background(), cfg.RequestTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// serviceError wraps errors from service calls, an unavailable
+// service is reported as unreachable.
+func serviceError(name string, cfg ServiceConfig, method string, err error) error {
+	if grpc.Code(err) == codes.Unavailable {
+		return errors.NewUnavailableError(name, cfg.Address, err)
+	}
+	return errors.NewServiceError(name, method, err)
+}
+
+func dialError(name string, cfg ServiceConfig, err error) error {
+	if _, ok := err.(errors.BoopError); ok {
+		return err
+	}
+	return errors.NewUnavailableError(name, cfg.Address, err)
+}
+
+func (o *OpseeServices) initCats() error {
 	if o.cats != nil {
-		return
+		return nil
 	}
-	conn, err := grpc.Dial("cats.in.opsee.com:443",
-		grpc.WithTransportCredentials(grpc_credentials.NewTLS(&tls.Config{})),
-		grpc.WithTimeout(tcpTimeout),
-		grpc.WithBlock())
+	conn, err := o.profile().Cats.dial()
 	if err != nil {
-		log.ERROR.Fatal(err)
+		return dialError("cats", o.profile().Cats, err)
 	}
 	o.cats = service.NewCatsClient(conn)
+	return nil
 }
 
-func (o *OpseeServices) initSpanx() {
+func (o *OpseeServices) initSpanx() error {
 	if o.spanx != nil {
-		return
+		return nil
 	}
-	conn, err := grpc.Dial("spanx.in.opsee.com:8443",
-		grpc.WithTransportCredentials(grpc_credentials.NewTLS(&tls.Config{})),
-		grpc.WithTimeout(tcpTimeout))
+	conn, err := o.profile().Spanx.dial()
 	if err != nil {
-		panic(err)
+		return dialError("spanx", o.profile().Spanx, err)
 	}
 	o.spanx = service.NewSpanxClient(conn)
+	return nil
 }
 
-func (o *OpseeServices) initKeelhaul() {
+func (o *OpseeServices) initKeelhaul() error {
 	if o.keelhaul != nil {
-		return
+		return nil
 	}
-	conn, err := grpc.Dial("keelhaul.in.opsee.com:443",
-		grpc.WithTransportCredentials(grpc_credentials.NewTLS(&tls.Config{})),
-		grpc.WithTimeout(tcpTimeout))
+	conn, err := o.profile().Keelhaul.dial()
 	if err != nil {
-		panic(err)
+		return dialError("keelhaul", o.profile().Keelhaul, err)
 	}
 	o.keelhaul = service.NewKeelhaulClient(conn)
+	return nil
 }
 
 func (o *OpseeServices) GetRoleCreds(user *schema.User) (*opsee_aws_credentials.Value, error) {
-	o.initSpanx()
+	if err := o.initSpanx(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(o.profile().Spanx)
+	defer cancel()
 
-	spanxResp, err := o.spanx.GetCredentials(context.Background(), &service.GetCredentialsRequest{
+	spanxResp, err := o.spanx.GetCredentials(ctx, &service.GetCredentialsRequest{
 		User: user,
 	})
 	if err != nil {
-		return nil, err
+		return nil, serviceError("spanx", o.profile().Spanx, "GetCredentials", err)
 	}
 
 	return spanxResp.GetCredentials(), nil
 }
 
 func (o *OpseeServices) GetBastionStates(customerIDs []string, filters ...*service.Filter) ([]*schema.BastionState, error) {
-	o.initKeelhaul()
+	if err := o.initKeelhaul(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(o.profile().Keelhaul)
+	defer cancel()
 
-	keelResp, err := o.keelhaul.ListBastionStates(context.Background(), &service.ListBastionStatesRequest{
+	keelResp, err := o.keelhaul.ListBastionStates(ctx, &service.ListBastionStatesRequest{
 		CustomerIds: customerIDs,
 		Filters:     filters,
 	})
 	if err != nil {
-		return nil, err
+		return nil, serviceError("keelhaul", o.profile().Keelhaul, "ListBastionStates", err)
 	}
 
 	return keelResp.GetBastionStates(), nil
 }
 
 func (o *OpseeServices) GetUser(email string, custID string) (*schema.User, error) {
-	o.initCats()
+	if err := o.initCats(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(o.profile().Cats)
+	defer cancel()
 
-	userResp, err := o.cats.GetUser(context.Background(), &service.GetUserRequest{
+	userResp, err := o.cats.GetUser(ctx, &service.GetUserRequest{
 		Email:      email,
 		CustomerId: custID,
 	})
 	if err != nil {
-		return nil, err
+		return nil, serviceError("cats", o.profile().Cats, "GetUser", err)
 	}
 
 	return userResp.User, nil
 }
+
+func (o *OpseeServices) ScanVpcs(user *schema.User, region string) (*schema.Region, error) {
+	if err := o.initKeelhaul(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := requestContext(o.profile().Keelhaul)
+	defer cancel()
+
+	keelResp, err := o.keelhaul.ScanVpcs(ctx, &service.ScanVpcsRequest{
+		User:   user,
+		Region: region,
+	})
+	if err != nil {
+		return nil, serviceError("keelhaul", o.profile().Keelhaul, "ScanVpcs", err)
+	}
+
+	return keelResp.Region, nil
+}
+
+func (o *OpseeServices) LaunchStack(req *service.LaunchStackRequest) (string, error) {
+	if err := o.initKeelhaul(); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := requestContext(o.profile().Keelhaul)
+	defer cancel()
+
+	keelResp, err := o.keelhaul.LaunchStack(ctx, req)
+	if err != nil {
+		return "", serviceError("keelhaul", o.profile().Keelhaul, "LaunchStack", err)
+	}
+
+	return keelResp.StackId, nil
+}
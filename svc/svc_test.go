@@ -0,0 +1,59 @@
+package svc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opsee/basic/schema"
+	"github.com/opsee/boop/errors"
+)
+
+func unreachableServices(t *testing.T) *OpseeServices {
+	// grab a free port and close it again so nothing is listening there
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	cfg := ServiceConfig{Address: addr, Timeout: 200 * time.Millisecond, Plaintext: true}
+	return NewOpseeServices(&Profile{Name: "test", Cats: cfg, Spanx: cfg, Keelhaul: cfg})
+}
+
+func TestUnreachableServices(t *testing.T) {
+	services := unreachableServices(t)
+
+	_, err := services.GetUser("sterling@isis.com", "")
+	assertUnavailable(t, err, "cats")
+
+	_, err = services.GetRoleCreds(&schema.User{CustomerId: "5963d7bc-6ba2-11e5-8603-6ba085b2f5b5"})
+	assertUnavailable(t, err, "spanx")
+
+	_, err = services.GetBastionStates([]string{})
+	assertUnavailable(t, err, "keelhaul")
+}
+
+func assertUnavailable(t *testing.T, err error, service string) {
+	if err == nil {
+		t.Fatalf("expected error from %s", service)
+	}
+	if !errors.IsUnavailableError(err) {
+		t.Errorf("expected unavailable error from %s, got %#v", service, err)
+	}
+	if !strings.HasPrefix(err.Error(), service+" unreachable") || !strings.Contains(err.Error(), "VPN") {
+		t.Errorf("unexpected error message: %s", err)
+	}
+}
+
+func TestInvalidTLSSettings(t *testing.T) {
+	cfg := ServiceConfig{Address: "127.0.0.1:1", Timeout: tcpTimeout, CAFile: "/nonexistent/ca.pem"}
+	services := NewOpseeServices(&Profile{Name: "test", Cats: cfg, Spanx: cfg, Keelhaul: cfg})
+
+	_, err := services.GetUser("sterling@isis.com", "")
+	if err == nil || !errors.IsUserError(err) {
+		t.Fatalf("expected user error for invalid TLS settings, got %v", err)
+	}
+}
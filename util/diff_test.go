@@ -0,0 +1,20 @@
+package util
+
+import "testing"
+
+func TestDiff(t *testing.T) {
+	for _, test := range []struct {
+		a, b, expected string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\nc\n", "a\nB\nc\n", "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n"},
+		{"", "x\n", "--- old\n+++ new\n@@ -0,0 +1 @@\n+x\n"},
+		{"x\n", "", "--- old\n+++ new\n@@ -1 +0,0 @@\n-x\n"},
+		// changes more than 2*context lines apart get their own hunks
+		{"1\n2\n3\n4\n5\n6\n7\n", "one\n2\n3\n4\n5\n6\nseven\n", "--- old\n+++ new\n@@ -1,2 +1,2 @@\n-1\n+one\n 2\n@@ -6,2 +6,2 @@\n 6\n-7\n+seven\n"},
+	} {
+		if diff := Diff("old", "new", test.a, test.b, 1); diff != test.expected {
+			t.Errorf("diff of %q and %q: expected\n%s\ngot\n%s", test.a, test.b, test.expected, diff)
+		}
+	}
+}
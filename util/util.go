@@ -45,7 +45,7 @@ func parseUserID(id string) (email string, uuid string, err error) {
 	return "", "", errors.NewUserError("no email or UUID found in string")
 }
 
-func GetUserFromArgs(args []string, pos int, svcs *svc.OpseeServices) (*schema.User, error) {
+func GetUserFromArgs(args []string, pos int, svcs svc.UserGetter) (*schema.User, error) {
 	if len(args) < pos+1 {
 		return nil, errors.NewUserError("missing user argument")
 	}